@@ -0,0 +1,111 @@
+// Package cluster connects the nodes of a multi-node server. Every node
+// owns the boards of some zones; a Placement tells which node owns a
+// zone and a Bus carries requests and updates between nodes.
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// NodeID names a node of the cluster
+type NodeID string
+
+// Packet is a message received from another node
+type Packet struct {
+	From NodeID
+	Data []byte
+}
+
+// Errors returned by Bus.Send
+var (
+	ErrUnknownNode = errors.New("unknown node")
+	ErrBusFull     = errors.New("too many messages waiting for node")
+	ErrUnreachable = errors.New("node unreachable")
+	ErrClosed      = errors.New("bus closed")
+)
+
+// Bus carries messages between nodes. Messages from one node to another
+// arrive in the order they were sent, but delivery is not guaranteed:
+// messages to a node that is down or too far behind are lost.
+type Bus interface {
+	// Self returns the ID of the local node
+	Self() NodeID
+
+	// Send queues a message for another node. It never blocks; a message
+	// that cannot be queued is dropped with an error. The bus may keep
+	// data, so it must not be changed afterwards.
+	Send(to NodeID, data []byte) error
+
+	// Receive returns the messages sent to the local node
+	Receive() <-chan Packet
+
+	// Close disconnects the node. The Receive channel stays open.
+	Close() error
+}
+
+// Placement assigns the grid's zones, and with them their boards and
+// subscribers, to nodes
+type Placement interface {
+	// Owner returns the node owning a zone, empty when there are no nodes
+	Owner(zoneID types.ZoneID) NodeID
+
+	// Nodes returns every node of the cluster
+	Nodes() []NodeID
+
+	// WithNodes returns the same kind of placement over other nodes
+	WithNodes(nodes []NodeID) Placement
+}
+
+// ZoneCount is the number of zones on the grid
+const ZoneCount = types.ZonesPerRow * types.ZonesPerRow
+
+// Ranges places zones in contiguous ranges of zone IDs, one per node in
+// the order given. Zones are numbered row by row, so each node owns a
+// band of the grid.
+type Ranges struct {
+	nodes []NodeID
+}
+
+// NewRanges splits the zones evenly between nodes
+func NewRanges(nodes []NodeID) *Ranges {
+	return &Ranges{nodes: append([]NodeID(nil), nodes...)}
+}
+
+func (r *Ranges) Owner(zoneID types.ZoneID) NodeID {
+	if len(r.nodes) == 0 {
+		return ""
+	}
+	return r.nodes[int(zoneID)*len(r.nodes)/ZoneCount]
+}
+
+func (r *Ranges) Nodes() []NodeID {
+	return append([]NodeID(nil), r.nodes...)
+}
+
+func (r *Ranges) WithNodes(nodes []NodeID) Placement {
+	return NewRanges(nodes)
+}
+
+// ParseNodes reads a cluster description of the form
+// "a=host:port,b=host:port" into the node IDs, in order, and their
+// addresses
+func ParseNodes(spec string) ([]NodeID, map[NodeID]string, error) {
+	nodes := make([]NodeID, 0)
+	addrs := make(map[NodeID]string)
+	for _, entry := range strings.Split(spec, ",") {
+		id, addr, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || id == "" || addr == "" {
+			return nil, nil, fmt.Errorf("invalid node %q, want id=host:port", entry)
+		}
+		if _, dup := addrs[NodeID(id)]; dup {
+			return nil, nil, fmt.Errorf("node %q listed twice", id)
+		}
+		nodes = append(nodes, NodeID(id))
+		addrs[NodeID(id)] = addr
+	}
+	return nodes, addrs, nil
+}
@@ -0,0 +1,102 @@
+package cluster
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+func TestParseNodes(t *testing.T) {
+	nodes, addrs, err := ParseNodes("a=10.0.0.1:7000, b=10.0.0.2:7000")
+	if err != nil {
+		t.Fatalf("ParseNodes: %v", err)
+	}
+	if want := []NodeID{"a", "b"}; !reflect.DeepEqual(nodes, want) {
+		t.Errorf("nodes %v, want %v", nodes, want)
+	}
+	if want := map[NodeID]string{"a": "10.0.0.1:7000", "b": "10.0.0.2:7000"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("addresses %v, want %v", addrs, want)
+	}
+
+	for _, spec := range []string{"", "a", "a=", "=host:1", "a=h:1,a=h:2"} {
+		if _, _, err := ParseNodes(spec); err == nil {
+			t.Errorf("ParseNodes(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestPlacements(t *testing.T) {
+	nodes := []NodeID{"a", "b", "c"}
+	for name, placement := range map[string]Placement{"ranges": NewRanges(nodes), "ring": NewRing(nodes)} {
+		t.Run(name, func(t *testing.T) {
+			if got := placement.Nodes(); !reflect.DeepEqual(got, nodes) {
+				t.Errorf("Nodes() = %v, want %v", got, nodes)
+			}
+			shares := make(map[NodeID]int)
+			for zone := 0; zone < ZoneCount; zone++ {
+				shares[placement.Owner(types.ZoneID(zone))]++
+			}
+			for _, node := range nodes {
+				// Ranges split evenly; the ring only roughly
+				if shares[node] < ZoneCount/6 {
+					t.Errorf("node %s owns %d of %d zones", node, shares[node], ZoneCount)
+				}
+			}
+
+			fewer := placement.WithNodes(nodes[:2])
+			for zone := 0; zone < ZoneCount; zone++ {
+				if owner := fewer.Owner(types.ZoneID(zone)); owner == "c" {
+					t.Fatalf("zone %d still placed on removed node c", zone)
+				}
+			}
+		})
+	}
+}
+
+func TestRingMovesFewZones(t *testing.T) {
+	before := NewRing([]NodeID{"a", "b", "c"})
+	after := before.WithNodes([]NodeID{"a", "b", "c", "d"})
+	for zone := 0; zone < ZoneCount; zone++ {
+		was, is := before.Owner(types.ZoneID(zone)), after.Owner(types.ZoneID(zone))
+		if was != is && is != "d" {
+			t.Fatalf("zone %d moved from %s to %s, not to the new node", zone, was, is)
+		}
+	}
+}
+
+func TestNetwork(t *testing.T) {
+	network := NewNetwork()
+	a, b := network.Join("a"), network.Join("b")
+
+	data := []byte("hello")
+	if err := a.Send("b", data); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	data[0] = 'j'
+	if p := <-b.Receive(); p.From != "a" || string(p.Data) != "hello" {
+		t.Errorf("received %q from %s, want \"hello\" from a", p.Data, p.From)
+	}
+
+	if err := a.Send("c", nil); !errors.Is(err, ErrUnknownNode) {
+		t.Errorf("send to unknown node: got %v, want ErrUnknownNode", err)
+	}
+	b.Close()
+	if err := a.Send("b", nil); !errors.Is(err, ErrUnknownNode) {
+		t.Errorf("send to closed node: got %v, want ErrUnknownNode", err)
+	}
+	if err := b.Send("a", nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("send from closed node: got %v, want ErrClosed", err)
+	}
+}
+
+func TestPlacementsWithoutNodes(t *testing.T) {
+	for name, placement := range map[string]Placement{"ranges": NewRanges(nil), "ring": NewRing(nil)} {
+		for _, zone := range []types.ZoneID{0, ZoneCount - 1} {
+			if owner := placement.Owner(zone); owner != "" {
+				t.Errorf("%s: zone %d owned by %q without nodes", name, zone, owner)
+			}
+		}
+	}
+}
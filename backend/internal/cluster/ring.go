@@ -0,0 +1,73 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// Points each node takes on a Ring, evening out the nodes' shares
+const ringPointsPerNode = 128
+
+// Ring places zones on a consistent hash ring: each zone belongs to the
+// first node point at or after the hash of the zone's top-left board
+// coordinate. Adding or removing a node only moves the zones next to its
+// points, about a share of the grid, unlike Ranges which shifts every
+// range boundary.
+type Ring struct {
+	nodes  []NodeID
+	owners []NodeID // By zone ID, worked out once
+}
+
+// ringPoint is a node's position on the ring
+type ringPoint struct {
+	hash uint64
+	node NodeID
+}
+
+// NewRing places the zones on a ring of the given nodes
+func NewRing(nodes []NodeID) *Ring {
+	points := make([]ringPoint, 0, len(nodes)*ringPointsPerNode)
+	for _, node := range nodes {
+		for i := 0; i < ringPointsPerNode; i++ {
+			points = append(points, ringPoint{hash: ringHash([]byte(string(node) + "#" + strconv.Itoa(i))), node: node})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	r := &Ring{nodes: append([]NodeID(nil), nodes...), owners: make([]NodeID, ZoneCount)}
+	if len(points) == 0 {
+		return r
+	}
+	for zone := range r.owners {
+		var key [4]byte
+		binary.BigEndian.PutUint32(key[:], uint32(types.ZoneID(zone).Origin()))
+		hash := ringHash(key[:])
+		i := sort.Search(len(points), func(i int) bool { return points[i].hash >= hash })
+		if i == len(points) {
+			i = 0
+		}
+		r.owners[zone] = points[i].node
+	}
+	return r
+}
+
+func ringHash(data []byte) uint64 {
+	sum := sha256.Sum256(data)
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func (r *Ring) Owner(zoneID types.ZoneID) NodeID {
+	return r.owners[zoneID]
+}
+
+func (r *Ring) Nodes() []NodeID {
+	return append([]NodeID(nil), r.nodes...)
+}
+
+func (r *Ring) WithNodes(nodes []NodeID) Placement {
+	return NewRing(nodes)
+}
@@ -0,0 +1,414 @@
+package cluster
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// How many messages may wait for a peer before sends to it fail
+	tcpQueueSize = 4096
+
+	// Largest frame accepted from a peer, and before it has proven it
+	// knows the cluster secret
+	maxFrameSize     = 64 << 20
+	maxHandshakeSize = 256
+
+	// Size of the random challenges exchanged when connecting
+	challengeSize = 32
+
+	// Time allowed to connect to a peer or write a frame to it
+	tcpTimeout = 5 * time.Second
+
+	// How long to wait before dialing a peer that could not be reached
+	redialDelay = time.Second
+)
+
+// TCPBus connects nodes over TCP. Every node listens on its address and
+// keeps a connection to each peer, redialing it while it is down. Frames
+// hold a 4 byte big endian length followed by the message.
+//
+// Nodes share a secret and prove they know it when connecting: the
+// accepting node sends a random challenge, the dialing node answers with
+// its ID, a challenge of its own and an HMAC of the first challenge and
+// its ID, and the accepting node answers with an HMAC of the second
+// challenge and its own ID. Either side drops the connection on a wrong
+// answer. Traffic is not encrypted, so the bus belongs on a private
+// network.
+//
+// Messages wait in a queue per peer written by the peer's own goroutine,
+// so Send never blocks on the network. Sends to a peer that is down fail
+// with ErrUnreachable, and messages queued when it went down are dropped.
+type TCPBus struct {
+	self     NodeID
+	secret   []byte
+	listener net.Listener
+	inbox    chan Packet
+	peers    map[NodeID]*tcpPeer
+
+	// Accepted connections, closed with the bus
+	connsMux sync.Mutex
+	conns    map[net.Conn]bool
+
+	done chan struct{}
+	once sync.Once
+}
+
+// tcpPeer is the sending side of the link to another node
+type tcpPeer struct {
+	id    NodeID
+	addr  string
+	queue chan []byte
+
+	// Set while the peer cannot be reached
+	down atomic.Bool
+}
+
+// ErrNoSecret is returned by ListenTCP without a cluster secret
+var ErrNoSecret = errors.New("cluster secret is empty")
+
+// ListenTCP starts the bus of node self, listening on its address in
+// nodes, which lists every node of the cluster. Only nodes knowing
+// secret may connect.
+func ListenTCP(self NodeID, nodes map[NodeID]string, secret []byte) (*TCPBus, error) {
+	addr, ok := nodes[self]
+	if !ok {
+		return nil, fmt.Errorf("node %q is not part of the cluster", self)
+	}
+	if len(secret) == 0 {
+		return nil, ErrNoSecret
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen for cluster peers: %w", err)
+	}
+
+	b := &TCPBus{
+		self:     self,
+		secret:   append([]byte(nil), secret...),
+		listener: listener,
+		inbox:    make(chan Packet, tcpQueueSize),
+		peers:    make(map[NodeID]*tcpPeer),
+		conns:    make(map[net.Conn]bool),
+		done:     make(chan struct{}),
+	}
+	for id, addr := range nodes {
+		if id == self {
+			continue
+		}
+		p := &tcpPeer{id: id, addr: addr, queue: make(chan []byte, tcpQueueSize)}
+		p.down.Store(true)
+		b.peers[id] = p
+		go b.writeLoop(p)
+	}
+	go b.acceptLoop()
+
+	log.Printf("🔗 Node %s listening for %d peers on %s", self, len(b.peers), listener.Addr())
+	return b, nil
+}
+
+// Addr returns the address the bus listens on
+func (b *TCPBus) Addr() net.Addr { return b.listener.Addr() }
+
+func (b *TCPBus) Self() NodeID { return b.self }
+
+func (b *TCPBus) Send(to NodeID, data []byte) error {
+	select {
+	case <-b.done:
+		return ErrClosed
+	default:
+	}
+
+	p := b.peers[to]
+	if p == nil {
+		return ErrUnknownNode
+	}
+	if p.down.Load() {
+		return ErrUnreachable
+	}
+	select {
+	case p.queue <- data:
+		return nil
+	default:
+		return ErrBusFull
+	}
+}
+
+func (b *TCPBus) Receive() <-chan Packet { return b.inbox }
+
+func (b *TCPBus) Close() error {
+	b.once.Do(func() {
+		close(b.done)
+		b.listener.Close()
+
+		b.connsMux.Lock()
+		for conn := range b.conns {
+			conn.Close()
+		}
+		b.connsMux.Unlock()
+	})
+	return nil
+}
+
+// writeLoop keeps the connection to a peer and sends the messages queued
+// for it. Messages that cannot be written are dropped.
+func (b *TCPBus) writeLoop(p *tcpPeer) {
+	var conn net.Conn
+	var w *bufio.Writer
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	logged := false
+	for {
+		if conn == nil {
+			var err error
+			if conn, err = b.dial(p); err != nil {
+				// Logged once per outage, as the peer is redialed every second
+				if !logged {
+					log.Printf("🔌 Cannot reach node %s at %s: %v", p.id, p.addr, err)
+					logged = true
+				}
+				p.down.Store(true)
+				p.drop()
+				select {
+				case <-b.done:
+					return
+				case <-time.After(redialDelay):
+				}
+				continue
+			}
+			w = bufio.NewWriter(conn)
+			p.down.Store(false)
+			logged = false
+			log.Printf("🔗 Connected to node %s at %s", p.id, p.addr)
+		}
+
+		var data []byte
+		select {
+		case <-b.done:
+			return
+		case data = <-p.queue:
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(tcpTimeout))
+		err := writeFrame(w, data)
+		// Flushed once the queue is drained, so a burst shares writes
+		if err == nil && len(p.queue) == 0 {
+			err = w.Flush()
+		}
+		if err != nil {
+			log.Printf("🔌 Lost connection to node %s: %v", p.id, err)
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+// dial connects to a peer and authenticates both ends
+func (b *TCPBus) dial(p *tcpPeer) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", p.addr, tcpTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(tcpTimeout))
+	if err := b.introduce(conn, p.id); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// introduce answers the challenge of the peer being dialed and checks
+// the peer's answer to the local node's challenge
+func (b *TCPBus) introduce(conn net.Conn, peer NodeID) error {
+	challenge, err := readFrameLimit(conn, maxHandshakeSize)
+	if err != nil {
+		return err
+	}
+	if len(challenge) != challengeSize {
+		return errors.New("invalid challenge")
+	}
+
+	ours, err := newChallenge()
+	if err != nil {
+		return err
+	}
+	for _, data := range [][]byte{[]byte(b.self), ours, b.proof("dial", challenge, b.self)} {
+		if err := writeFrame(conn, data); err != nil {
+			return err
+		}
+	}
+
+	answer, err := readFrameLimit(conn, maxHandshakeSize)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(answer, b.proof("accept", ours, peer)) {
+		return errors.New("node failed to prove it knows the cluster secret")
+	}
+	return nil
+}
+
+// authenticate challenges a connecting node and returns its ID once it
+// proved it knows the cluster secret
+func (b *TCPBus) authenticate(conn net.Conn, r io.Reader) (NodeID, error) {
+	challenge, err := newChallenge()
+	if err != nil {
+		return "", err
+	}
+	if err := writeFrame(conn, challenge); err != nil {
+		return "", err
+	}
+
+	var hello [3][]byte // ID, challenge, proof
+	for i := range hello {
+		if hello[i], err = readFrameLimit(r, maxHandshakeSize); err != nil {
+			return "", err
+		}
+	}
+	from := NodeID(hello[0])
+	if _, known := b.peers[from]; !known {
+		return "", fmt.Errorf("unknown node %q", from)
+	}
+	if len(hello[1]) != challengeSize || !hmac.Equal(hello[2], b.proof("dial", challenge, from)) {
+		return "", fmt.Errorf("node %q failed to prove it knows the cluster secret", from)
+	}
+
+	if err := writeFrame(conn, b.proof("accept", hello[1], b.self)); err != nil {
+		return "", err
+	}
+	return from, nil
+}
+
+// proof is the HMAC a node in role sends to show it knows the secret,
+// binding the other side's challenge to the node's own ID
+func (b *TCPBus) proof(role string, challenge []byte, id NodeID) []byte {
+	mac := hmac.New(sha256.New, b.secret)
+	mac.Write([]byte(role))
+	mac.Write(challenge)
+	mac.Write([]byte(id))
+	return mac.Sum(nil)
+}
+
+func newChallenge() ([]byte, error) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, fmt.Errorf("generate challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// drop discards the messages queued for a peer
+func (p *tcpPeer) drop() {
+	for {
+		select {
+		case <-p.queue:
+		default:
+			return
+		}
+	}
+}
+
+func (b *TCPBus) acceptLoop() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			select {
+			case <-b.done:
+				return
+			default:
+			}
+			log.Printf("⚠️ Accepting cluster connection failed: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go b.readLoop(conn)
+	}
+}
+
+// readLoop receives the messages of a peer's connection
+func (b *TCPBus) readLoop(conn net.Conn) {
+	b.connsMux.Lock()
+	b.conns[conn] = true
+	b.connsMux.Unlock()
+	defer func() {
+		b.connsMux.Lock()
+		delete(b.conns, conn)
+		b.connsMux.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(tcpTimeout))
+	from, err := b.authenticate(conn, r)
+	if err != nil {
+		log.Printf("⚠️ Refused cluster connection from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	for {
+		data, err := readFrame(r)
+		if err != nil {
+			select {
+			case <-b.done:
+			default:
+				if err != io.EOF {
+					log.Printf("🔌 Connection from node %s failed: %v", from, err)
+				}
+			}
+			return
+		}
+
+		select {
+		case b.inbox <- Packet{From: from, Data: data}:
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func writeFrame(w io.Writer, data []byte) error {
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	return readFrameLimit(r, maxFrameSize)
+}
+
+func readFrameLimit(r io.Reader, limit uint32) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > limit {
+		return nil, fmt.Errorf("frame of %d bytes exceeds the limit", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,159 @@
+package cluster
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	messages := [][]byte{[]byte("first"), {}, bytes.Repeat([]byte{0xAB}, 70000)}
+	for _, data := range messages {
+		if err := writeFrame(&buf, data); err != nil {
+			t.Fatalf("writeFrame: %v", err)
+		}
+	}
+	for i, want := range messages {
+		got, err := readFrame(&buf)
+		if err != nil {
+			t.Fatalf("readFrame %d: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("frame %d: got %d bytes, want %d", i, len(got), len(want))
+		}
+	}
+	if _, err := readFrame(&buf); err == nil {
+		t.Error("reading past the last frame succeeded")
+	}
+}
+
+func TestReadFrameLimit(t *testing.T) {
+	var buf bytes.Buffer
+	writeFrame(&buf, make([]byte, maxHandshakeSize+1))
+	if _, err := readFrameLimit(&buf, maxHandshakeSize); err == nil {
+		t.Error("frame over the limit was read")
+	}
+
+	buf.Reset()
+	writeFrame(&buf, []byte("truncated"))
+	buf.Truncate(buf.Len() - 1)
+	if _, err := readFrame(&buf); err == nil {
+		t.Error("truncated frame was read")
+	}
+}
+
+// freeAddrs returns loopback addresses for nodes, on ports free when
+// checked
+func freeAddrs(t *testing.T, nodes ...NodeID) map[NodeID]string {
+	t.Helper()
+	addrs := make(map[NodeID]string)
+	for _, id := range nodes {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		addrs[id] = l.Addr().String()
+		l.Close()
+	}
+	return addrs
+}
+
+func listen(t *testing.T, self NodeID, addrs map[NodeID]string, secret string) *TCPBus {
+	t.Helper()
+	b, err := ListenTCP(self, addrs, []byte(secret))
+	if err != nil {
+		t.Fatalf("ListenTCP %s: %v", self, err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// sendWhenUp retries a send until the connection to the peer is up
+func sendWhenUp(t *testing.T, b *TCPBus, to NodeID, data []byte) error {
+	t.Helper()
+	deadline := time.Now().Add(3 * redialDelay)
+	for {
+		err := b.Send(to, data)
+		if !errors.Is(err, ErrUnreachable) || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTCPBusDelivers(t *testing.T) {
+	addrs := freeAddrs(t, "a", "b")
+	a := listen(t, "a", addrs, "secret")
+	b := listen(t, "b", addrs, "secret")
+
+	if err := sendWhenUp(t, a, "b", []byte("message 0")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	for i := 1; i < 100; i++ {
+		if err := a.Send("b", []byte(fmt.Sprintf("message %d", i))); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		select {
+		case p := <-b.Receive():
+			if want := fmt.Sprintf("message %d", i); p.From != "a" || string(p.Data) != want {
+				t.Fatalf("received %q from %s, want %q from a", p.Data, p.From, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %d not received", i)
+		}
+	}
+
+	if err := a.Send("c", []byte("nobody")); !errors.Is(err, ErrUnknownNode) {
+		t.Errorf("send to unknown node: got %v, want ErrUnknownNode", err)
+	}
+	a.Close()
+	if err := a.Send("b", []byte("closed")); !errors.Is(err, ErrClosed) {
+		t.Errorf("send on closed bus: got %v, want ErrClosed", err)
+	}
+}
+
+func TestTCPBusRefusesWrongSecret(t *testing.T) {
+	addrs := freeAddrs(t, "a", "b")
+	a := listen(t, "a", addrs, "secret")
+	b := listen(t, "b", addrs, "guess")
+
+	if err := sendWhenUp(t, b, "a", []byte("intruder")); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("send with the wrong secret: got %v, want ErrUnreachable", err)
+	}
+	select {
+	case p := <-a.Receive():
+		t.Errorf("received %q from a node with the wrong secret", p.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTCPBusRefusesUnknownNode(t *testing.T) {
+	addrs := freeAddrs(t, "a", "b")
+	a := listen(t, "a", map[NodeID]string{"a": addrs["a"]}, "secret")
+	b := listen(t, "b", addrs, "secret")
+
+	if err := sendWhenUp(t, b, "a", []byte("stranger")); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("send from a node outside the cluster: got %v, want ErrUnreachable", err)
+	}
+	select {
+	case p := <-a.Receive():
+		t.Errorf("received %q from a node outside the cluster", p.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestListenTCPErrors(t *testing.T) {
+	addrs := freeAddrs(t, "a")
+	if _, err := ListenTCP("a", addrs, nil); !errors.Is(err, ErrNoSecret) {
+		t.Errorf("empty secret: got %v, want ErrNoSecret", err)
+	}
+	if _, err := ListenTCP("z", addrs, []byte("secret")); err == nil {
+		t.Error("node missing from the cluster could listen")
+	}
+}
@@ -1,11 +1,15 @@
 package hub
 
 import (
+	"errors"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/one-million-go/backend/pkg/protocol"
 	"github.com/one-million-go/backend/pkg/types"
 )
 
@@ -21,38 +25,72 @@ const (
 
 	// Maximum message size allowed from peer.
 	maxMessageSize = 512 * 1024 // 512 KB
+
+	// Region fetches a client may have in flight at once
+	maxRegionFetches = 4
 )
 
 // ClientConnection represents a WebSocket client connection
 type ClientConnection struct {
-	ID   string
-	conn *websocket.Conn
-	hub  *GameHub
-	send chan *types.Message
+	ID    string // Written by the hub only, read elsewhere under idMux
+	idMux sync.RWMutex // Guards ID and features
+	conn  *websocket.Conn
+	codec protocol.Codec // Encoding negotiated with the WebSocket subprotocol
+	hub   *GameHub
+
+	// Messages waiting for WritePump, and how the connection ends once it
+	// is closed; guarded by sendMux
+	sendMux sync.Mutex
+	queue   sendQueue
+	closed  bool
+	flush   bool // Write the queued messages before the close frame
+	frame   closeFrame
+	wake    chan struct{} // Signalled when messages are queued or the connection closes
+
+	// Handshake: protocol version 0 until the first message arrives
+	compression     bool // permessage-deflate negotiated for the connection
+	protocolVersion uint16
+	features        map[string]bool
+	resumable       bool   // A resume token was issued to the client
+	resumeNonce     string // Nonce of the last token issued, replaced on every WELCOME
 
 	// Client state
-	lastActivity time.Time
+	lastActivity atomic.Int64 // Unix nanoseconds, written by ReadPump
 	position     types.BoardCoordinate // Current viewport center
 	subscribedZones map[types.ZoneID]bool
+	regionFetches   chan struct{} // One slot per region fetch in flight
+}
+
+// closeFrame is a WebSocket close code with its reason
+type closeFrame struct {
+	code int
+	text string
 }
 
-// NewClientConnection creates a new client connection
-func NewClientConnection(conn *websocket.Conn, hub *GameHub) *ClientConnection {
-	return &ClientConnection{
+// NewClientConnection creates a new client connection. compression tells
+// whether permessage-deflate was negotiated during the upgrade.
+func NewClientConnection(conn *websocket.Conn, hub *GameHub, compression bool) *ClientConnection {
+	c := &ClientConnection{
 		ID:              uuid.New().String(),
 		conn:            conn,
+		codec:           protocol.ForSubprotocol(conn.Subprotocol()),
 		hub:             hub,
-		send:            make(chan *types.Message, 256),
-		lastActivity:    time.Now(),
+		queue:           newSendQueue(hub.config),
+		wake:            make(chan struct{}, 1),
+		compression:     compression,
+		features:        make(map[string]bool),
 		subscribedZones: make(map[types.ZoneID]bool),
+		regionFetches:   make(chan struct{}, maxRegionFetches),
 	}
+	c.lastActivity.Store(time.Now().UnixNano())
+	return c
 }
 
 // ReadPump pumps messages from the websocket connection to the hub
 func (c *ClientConnection) ReadPump() {
 	defer func() {
 		c.hub.Unregister <- c
-		c.conn.Close()
+		c.close(closeFrame{code: websocket.CloseNormalClosure}, false)
 	}()
 
 	// Set connection parameters
@@ -65,33 +103,65 @@ func (c *ClientConnection) ReadPump() {
 
 	// Read messages from WebSocket
 	for {
-		var msg types.Message
-		err := c.conn.ReadJSON(&msg)
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error for client %s: %v", c.ID, err)
+				log.Printf("WebSocket error for client %s: %v", c.clientID(), err)
 			}
 			break
 		}
+		msg, err := c.codec.Decode(data)
+		if err != nil {
+			c.rejectRequest(err)
+			continue
+		}
 
-		c.lastActivity = time.Now()
+		now := time.Now()
+		c.lastActivity.Store(now.UnixNano())
 
 		// Send message to hub for processing
 		inboundMsg := &InboundMessage{
-			ClientID: c.ID,
-			Message:  &msg,
+			client:   c,
+			Message:  msg,
+			received: now,
 		}
 
 		select {
 		case c.hub.inbound <- inboundMsg:
 		default:
 			// Hub inbound channel is full, close the connection
-			log.Printf("⚠️ Hub inbound channel full, disconnecting client %s", c.ID)
+			log.Printf("⚠️ Hub inbound channel full, disconnecting client %s", c.clientID())
 			return
 		}
 	}
 }
 
+// rejectRequest answers a message that failed to decode or validate with
+// an INVALID_REQUEST error listing the offending fields
+func (c *ClientConnection) rejectRequest(err error) {
+	errMsg := &types.Message{
+		ID:        uuid.New().String(),
+		Type:      types.MsgError,
+		Timestamp: time.Now().Unix(),
+	}
+	errData := &types.ErrorData{
+		Code:    "INVALID_REQUEST",
+		Message: err.Error(),
+	}
+	var reqErr *protocol.RequestError
+	if errors.As(err, &reqErr) {
+		if reqErr.ID != "" {
+			errMsg.ID = reqErr.ID // Answer with the request's ID
+		}
+		errData.Fields = reqErr.Fields
+	}
+	errMsg.Data = errData
+
+	if !c.SendMessage(errMsg) {
+		log.Printf("⚠️ Dropped error reply to client %s: %v", c.clientID(), err)
+	}
+}
+
 // WritePump pumps messages from the hub to the websocket connection
 func (c *ClientConnection) WritePump() {
 	ticker := time.NewTicker(pingPeriod)
@@ -102,17 +172,28 @@ func (c *ClientConnection) WritePump() {
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// The hub closed the channel
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+		case <-c.wake:
+			c.sendMux.Lock()
+			messages := c.queue.take()
+			closed, flush, frame := c.closed, c.flush, c.frame
+			c.sendMux.Unlock()
+
+			if closed && !flush {
+				// close has written the close frame already
 				return
 			}
 
-			// Send the message
-			if err := c.conn.WriteJSON(message); err != nil {
-				log.Printf("WriteJSON error for client %s: %v", c.ID, err)
+			// Send the messages, e.g. the error explaining a close
+			for _, m := range messages {
+				if err := c.write(m.msg); err != nil {
+					log.Printf("Write error for client %s: %v", c.clientID(), err)
+					return
+				}
+			}
+
+			if closed {
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(frame.code, frame.text))
 				return
 			}
 
@@ -125,25 +206,152 @@ func (c *ClientConnection) WritePump() {
 	}
 }
 
-// SendMessage sends a message to this client
-func (c *ClientConnection) SendMessage(msg *types.Message) bool {
+// write encodes a message with the connection's codec and sends it.
+// Messages that cannot be encoded are logged and skipped.
+func (c *ClientConnection) write(message *types.Message) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	data, err := c.codec.Encode(message)
+	if err != nil {
+		log.Printf("Encode error for client %s: %v", c.clientID(), err)
+		return nil
+	}
+	frameType := websocket.TextMessage
+	if c.codec.Binary() {
+		frameType = websocket.BinaryMessage
+	}
+	return c.conn.WriteMessage(frameType, data)
+}
+
+// Close ends the connection with a WebSocket close code once the
+// messages already queued for the client have been written
+func (c *ClientConnection) Close(code int, text string) {
+	c.close(closeFrame{code: code, text: text}, true)
+}
+
+// close is the one way a connection ends, and only its first call has
+// any effect. No more messages are queued afterwards. With flush, the
+// close frame follows the messages already queued; otherwise they are
+// discarded and the connection closes right away.
+func (c *ClientConnection) close(frame closeFrame, flush bool) {
+	c.sendMux.Lock()
+	started := c.startClose(frame, flush)
+	c.sendMux.Unlock()
+	if started {
+		c.finishClose(frame, flush)
+	}
+}
+
+// startClose marks the connection closed, reporting false when it
+// already was; sendMux must be held
+func (c *ClientConnection) startClose(frame closeFrame, flush bool) bool {
+	if c.closed {
+		return false
+	}
+	c.closed, c.flush, c.frame = true, flush, frame
+	return true
+}
+
+// finishClose wakes WritePump to end the connection, and closes it right
+// away unless the queued messages are to be written first
+func (c *ClientConnection) finishClose(frame closeFrame, flush bool) {
+	c.signal()
+
+	if !flush {
+		// WritePump may be blocked writing to a slow client
+		go func() {
+			c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(frame.code, frame.text), time.Now().Add(writeWait))
+			c.conn.Close()
+		}()
+	}
+}
+
+// signal wakes WritePump unless it is already due to wake
+func (c *ClientConnection) signal() {
 	select {
-	case c.send <- msg:
-		return true
+	case c.wake <- struct{}{}:
 	default:
-		// Send buffer is full
-		return false
 	}
 }
 
+// push queues a message for WritePump. A client too far behind to keep
+// is closed with CloseTooSlow by the one push that reports pushOverflow.
+func (c *ClientConnection) push(m queuedMessage) (pushResult, int) {
+	tooSlow := closeFrame{code: protocol.CloseTooSlow, text: "too many messages queued"}
+
+	c.sendMux.Lock()
+	if c.closed {
+		c.sendMux.Unlock()
+		return pushClosed, 0
+	}
+	result, superseded := c.queue.push(m)
+	if result == pushOverflow {
+		c.startClose(tooSlow, false)
+	}
+	c.sendMux.Unlock()
+
+	switch result {
+	case pushQueued, pushCoalesced:
+		c.signal()
+	case pushOverflow:
+		c.finishClose(tooSlow, false)
+	}
+	return result, superseded
+}
+
+// takeQueue removes the messages WritePump has not written yet, for a
+// session to keep
+func (c *ClientConnection) takeQueue() sendQueue {
+	c.sendMux.Lock()
+	defer c.sendMux.Unlock()
+	queue := c.queue
+	c.queue.messages = nil
+	return queue
+}
+
+// HasFeature reports whether a protocol feature was negotiated for the
+// connection
+func (c *ClientConnection) HasFeature(feature string) bool {
+	c.idMux.RLock()
+	defer c.idMux.RUnlock()
+	return c.features[feature]
+}
+
+// setFeature records whether a feature was negotiated
+func (c *ClientConnection) setFeature(feature string, enabled bool) {
+	c.idMux.Lock()
+	c.features[feature] = enabled
+	c.idMux.Unlock()
+}
+
+// clientID returns the client's ID for use outside the hub
+func (c *ClientConnection) clientID() string {
+	c.idMux.RLock()
+	defer c.idMux.RUnlock()
+	return c.ID
+}
+
+// setID changes the client's identity when it resumes an earlier session
+func (c *ClientConnection) setID(id string) {
+	c.idMux.Lock()
+	c.ID = id
+	c.idMux.Unlock()
+}
+
+// SendMessage queues a reply for this client. It reports false when the
+// client is too far behind or its connection is closing.
+func (c *ClientConnection) SendMessage(msg *types.Message) bool {
+	result, _ := c.push(queuedMessage{msg: msg})
+	return result == pushQueued
+}
+
 // IsAlive checks if the connection is still active
 func (c *ClientConnection) IsAlive() bool {
-	return time.Since(c.lastActivity) < pongWait*2
+	return time.Since(c.GetLastActivity()) < pongWait*2
 }
 
 // GetLastActivity returns the last activity time
 func (c *ClientConnection) GetLastActivity() time.Time {
-	return c.lastActivity
+	return time.Unix(0, c.lastActivity.Load())
 }
 
 // UpdatePosition updates the client's current viewport position
@@ -178,4 +386,4 @@ func (c *ClientConnection) GetSubscribedZones() []types.ZoneID {
 		zones = append(zones, zoneID)
 	}
 	return zones
-}
\ No newline at end of file
+}
@@ -0,0 +1,579 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/one-million-go/backend/internal/cluster"
+	"github.com/one-million-go/backend/pkg/protocol"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// How long a node waits for another to answer a call
+const callTimeout = 5 * time.Second
+
+// clusterCodec encodes client messages inside frames. The binary codec
+// keeps their payload types, unlike JSON into an empty interface.
+var clusterCodec = protocol.BinaryCodec{}
+
+// frameKind tells what a frame between nodes carries
+type frameKind string
+
+const (
+	frameRequest     frameKind = "request"     // A client's request for a board of the receiving node
+	frameSubscribe   frameKind = "subscribe"   // A client subscribes to zones of the receiving node
+	frameUnsubscribe frameKind = "unsubscribe" // A client unsubscribes from zones of the receiving node
+	frameRelease     frameKind = "release"     // A client is gone; its seats are freed
+	frameDeliver     frameKind = "deliver"     // A message for clients connected to the receiving node
+	frameFetchBoards frameKind = "fetchBoards" // Call for the boards of a region fetch
+	frameExportSGF   frameKind = "exportSgf"   // Call for a board's SGF
+	frameImportSGF   frameKind = "importSgf"   // Call to replace a board with an SGF game
+	frameReply       frameKind = "reply"       // Answer to a call
+	framePlacement   frameKind = "placement"   // The cluster's nodes changed
+	frameHandoff     frameKind = "handoff"     // Zones with their boards and subscribers for their new owner
+	frameHandoffAck  frameKind = "handoffAck"  // Released zones their new owner took
+)
+
+// frame is a message between the nodes of a cluster, sent as JSON
+type frame struct {
+	Kind     frameKind               `json:"kind"`
+	ClientID string                  `json:"clientId,omitempty"`
+	Node     cluster.NodeID          `json:"node,omitempty"` // Node of the client when not the sender
+	Clients  []string                `json:"clients,omitempty"`
+	Features []string                `json:"features,omitempty"` // Features the client negotiated with its node
+	Message  []byte                  `json:"message,omitempty"`  // Client message in clusterCodec
+	Board    *types.BoardCoordinate  `json:"board,omitempty"`
+	Zones    []types.ZoneID          `json:"zones,omitempty"`
+	Coords   []types.BoardCoordinate `json:"coords,omitempty"`
+	CallID   uint64                  `json:"callId,omitempty"`
+	Data     []byte                  `json:"data,omitempty"`
+	Found    bool                    `json:"found,omitempty"`
+	Error    string                  `json:"error,omitempty"`
+	Handoffs []*zoneHandoff          `json:"handoffs,omitempty"`
+	Released []zoneKey               `json:"released,omitempty"`
+
+	// Node lists of the placements since startup, from epoch 1 on
+	Placements [][]cluster.NodeID `json:"placements,omitempty"`
+}
+
+// remoteClient is a client of another node with requests on boards or
+// zones of this one
+type remoteClient struct {
+	node     cluster.NodeID
+	features []string
+}
+
+// clusterNode is the hub's part in a cluster. Each node serves the
+// clients connected to it and owns the boards of the zones the placement
+// gives it: requests for other boards are forwarded to their owner,
+// which sends its replies and updates back through the client's node.
+// Sessions stay with the node a client was connected to, so a client
+// resuming on another node starts over.
+type clusterNode struct {
+	bus cluster.Bus
+
+	// Current placement, replaced when the cluster's nodes change.
+	// rebalanceMux serializes the changes.
+	view         atomic.Pointer[placementView]
+	rebalanceMux sync.Mutex
+
+	// Clients of other nodes, by client ID
+	remotesMux sync.RWMutex
+	remotes    map[string]*remoteClient
+
+	// Calls waiting for their reply, by call ID
+	callsMux sync.Mutex
+	calls    map[uint64]chan *frame
+	nextCall atomic.Uint64
+
+	// Zones handed to other nodes that did not confirm taking them yet
+	releasedMux sync.Mutex
+	released    map[zoneKey]*releasedZone
+}
+
+// placementView is a placement with the epoch it was introduced in. The
+// placement given at startup has epoch 0 and each rebalance raises it.
+type placementView struct {
+	placement cluster.Placement
+	epoch     uint64
+	history   [][]cluster.NodeID // Node lists of epochs 1 to epoch
+}
+
+func newClusterNode(bus cluster.Bus, placement cluster.Placement) *clusterNode {
+	n := &clusterNode{
+		bus:      bus,
+		remotes:  make(map[string]*remoteClient),
+		calls:    make(map[uint64]chan *frame),
+		released: make(map[zoneKey]*releasedZone),
+	}
+	n.view.Store(&placementView{placement: placement})
+	return n
+}
+
+// placement returns the current placement
+func (n *clusterNode) placement() cluster.Placement {
+	return n.view.Load().placement
+}
+
+// remoteOwner returns the node owning a zone when it is not this one
+func (h *GameHub) remoteOwner(zoneID types.ZoneID) (cluster.NodeID, bool) {
+	if h.node == nil {
+		return "", false
+	}
+	owner := h.node.placement().Owner(zoneID)
+	return owner, owner != h.node.bus.Self()
+}
+
+// owns reports whether this node owns a board
+func (h *GameHub) owns(coord types.BoardCoordinate) bool {
+	_, remote := h.remoteOwner(types.ZoneFor(coord))
+	return !remote
+}
+
+// peers returns the other nodes of the cluster
+func (h *GameHub) peers() []cluster.NodeID {
+	if h.node == nil {
+		return nil
+	}
+	peers := make([]cluster.NodeID, 0)
+	for _, id := range h.node.placement().Nodes() {
+		if id != h.node.bus.Self() {
+			peers = append(peers, id)
+		}
+	}
+	return peers
+}
+
+// sendFrame sends a frame to another node
+func (h *GameHub) sendFrame(to cluster.NodeID, f *frame) error {
+	data, err := json.Marshal(f)
+	if err == nil {
+		err = h.node.bus.Send(to, data)
+	}
+	if err != nil {
+		log.Printf("⚠️ Failed to send %s to node %s: %v", f.Kind, to, err)
+	}
+	if errors.Is(err, cluster.ErrUnreachable) || errors.Is(err, cluster.ErrUnknownNode) {
+		h.forgetNode(to)
+	}
+	return err
+}
+
+// forwardRequest hands a client's board request to the node owning the
+// board. Requests of clients of other nodes are passed on when the board
+// has moved since their node sent them.
+func (h *GameHub) forwardRequest(owner cluster.NodeID, inMsg *InboundMessage) {
+	data, err := clusterCodec.Encode(inMsg.Message)
+	if err == nil {
+		f := h.clientFrame(frameRequest, inMsg.ClientID, inMsg.remote)
+		f.Message = data
+		err = h.sendFrame(owner, f)
+	}
+	if err != nil {
+		h.sendError(inMsg.ClientID, "NODE_UNAVAILABLE", "The server holding this board is unavailable")
+	}
+}
+
+// clientFrame starts a frame about a client, telling the receiver where
+// the client is connected. remote is nil for clients of this node.
+func (h *GameHub) clientFrame(kind frameKind, clientID string, remote *remoteClient) *frame {
+	f := &frame{Kind: kind, ClientID: clientID}
+	if remote != nil {
+		f.Node, f.Features = remote.node, remote.features
+	} else if client := h.localClient(clientID); client != nil {
+		f.Features = negotiatedFeatures(client)
+	}
+	return f
+}
+
+// localClient returns a client of this node, connected or waiting to
+// resume
+func (h *GameHub) localClient(clientID string) *ClientConnection {
+	h.clientsMux.RLock()
+	defer h.clientsMux.RUnlock()
+	if client := h.clients[clientID]; client != nil {
+		return client
+	}
+	if s := h.sessions[clientID]; s != nil {
+		return s.client
+	}
+	return nil
+}
+
+// deliverRemote sends a message to clients of other nodes, one frame per
+// node
+func (h *GameHub) deliverRemote(recipients map[cluster.NodeID][]string, m queuedMessage) {
+	if len(recipients) == 0 {
+		return
+	}
+	data, err := clusterCodec.Encode(m.msg)
+	if err != nil {
+		log.Printf("⚠️ Failed to encode %s for other nodes: %v", m.msg.Type, err)
+		return
+	}
+	for node, clients := range recipients {
+		h.sendFrame(node, &frame{Kind: frameDeliver, Clients: clients, Message: data, Board: m.board})
+	}
+}
+
+// remoteNode returns the node a client of another node is connected to
+func (h *GameHub) remoteNode(clientID string) (cluster.NodeID, bool) {
+	if h.node == nil {
+		return "", false
+	}
+	h.node.remotesMux.RLock()
+	defer h.node.remotesMux.RUnlock()
+	if r := h.node.remotes[clientID]; r != nil {
+		return r.node, true
+	}
+	return "", false
+}
+
+// remoteHasFeature reports whether a client of another node negotiated a
+// feature
+func (h *GameHub) remoteHasFeature(clientID, feature string) bool {
+	if h.node == nil {
+		return false
+	}
+	h.node.remotesMux.RLock()
+	defer h.node.remotesMux.RUnlock()
+	if r := h.node.remotes[clientID]; r != nil {
+		for _, f := range r.features {
+			if f == feature {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rememberRemote records where a client of another node is connected and
+// returns the record, or nil when the client is connected to this node
+func (h *GameHub) rememberRemote(node cluster.NodeID, clientID string, features []string) *remoteClient {
+	if node == h.node.bus.Self() {
+		return nil
+	}
+	r := &remoteClient{node: node, features: features}
+	h.node.remotesMux.Lock()
+	h.node.remotes[clientID] = r
+	h.node.remotesMux.Unlock()
+	return r
+}
+
+// frameClient records the client a frame is about, which is connected to
+// the sender unless the frame names its node
+func (h *GameHub) frameClient(from cluster.NodeID, f *frame) *remoteClient {
+	node := f.Node
+	if node == "" {
+		node = from
+	}
+	return h.rememberRemote(node, f.ClientID, f.Features)
+}
+
+// forgetNode drops the clients of a node the bus reports gone, freeing
+// their seats and subscriptions as if each had disconnected. Clients of
+// a node that comes back are remembered again with their next request.
+func (h *GameHub) forgetNode(node cluster.NodeID) {
+	gone := make([]string, 0)
+	h.node.remotesMux.Lock()
+	for clientID, r := range h.node.remotes {
+		if r.node == node {
+			gone = append(gone, clientID)
+			delete(h.node.remotes, clientID)
+		}
+	}
+	h.node.remotesMux.Unlock()
+	if len(gone) == 0 {
+		return
+	}
+	log.Printf("🔌 Node %s is gone, forgetting its %d clients", node, len(gone))
+
+	// The sender may be a shard, which must not wait on its own queue
+	go func() {
+		for _, s := range h.shards {
+			s := s
+			s.do(func() { s.forgetClients(gone) })
+		}
+	}()
+}
+
+// remoteClientOf returns the record of a client of another node, nil for
+// clients of this node or unknown ones
+func (h *GameHub) remoteClientOf(clientID string) *remoteClient {
+	if h.node == nil {
+		return nil
+	}
+	h.node.remotesMux.RLock()
+	defer h.node.remotesMux.RUnlock()
+	return h.node.remotes[clientID]
+}
+
+// releaseRemote tells the other nodes that a client is gone
+func (h *GameHub) releaseRemote(clientID string) {
+	for _, node := range h.peers() {
+		h.sendFrame(node, &frame{Kind: frameRelease, ClientID: clientID})
+	}
+}
+
+// subscribeRemote adds a client to the subscribers of a zone owned by
+// another node. remote is nil for clients of this node.
+func (h *GameHub) subscribeRemote(owner cluster.NodeID, clientID string, remote *remoteClient, zoneID types.ZoneID) {
+	f := h.clientFrame(frameSubscribe, clientID, remote)
+	f.Zones = []types.ZoneID{zoneID}
+	h.sendFrame(owner, f)
+}
+
+// unsubscribeRemote removes a client from the subscribers of a zone
+// owned by another node
+func (h *GameHub) unsubscribeRemote(owner cluster.NodeID, clientID string, zoneID types.ZoneID) {
+	h.sendFrame(owner, &frame{Kind: frameUnsubscribe, ClientID: clientID, Zones: []types.ZoneID{zoneID}})
+}
+
+// callNode sends a frame to another node and waits for its reply
+func (h *GameHub) callNode(to cluster.NodeID, f *frame) (*frame, error) {
+	n := h.node
+	f.CallID = n.nextCall.Add(1)
+	reply := make(chan *frame, 1)
+	n.callsMux.Lock()
+	n.calls[f.CallID] = reply
+	n.callsMux.Unlock()
+	defer func() {
+		n.callsMux.Lock()
+		delete(n.calls, f.CallID)
+		n.callsMux.Unlock()
+	}()
+
+	if err := h.sendFrame(to, f); err != nil {
+		return nil, err
+	}
+
+	select {
+	case r := <-reply:
+		if r.Error != "" {
+			return nil, errors.New(r.Error)
+		}
+		return r, nil
+	case <-time.After(callTimeout):
+		return nil, fmt.Errorf("node %s did not answer within %s", to, callTimeout)
+	}
+}
+
+// reply answers a call from another node
+func (h *GameHub) reply(to cluster.NodeID, call *frame, r *frame) {
+	r.Kind = frameReply
+	r.CallID = call.CallID
+	h.sendFrame(to, r)
+}
+
+// fetchRemoteBoards returns snapshots of boards owned by another node
+func (h *GameHub) fetchRemoteBoards(owner cluster.NodeID, coords []types.BoardCoordinate) (map[types.BoardCoordinate]*types.BoardState, error) {
+	r, err := h.callNode(owner, &frame{Kind: frameFetchBoards, Coords: coords})
+	if err != nil {
+		return nil, err
+	}
+	msg, err := clusterCodec.Decode(r.Message)
+	if err != nil {
+		return nil, err
+	}
+	region, ok := msg.Data.(*types.RegionDataResponse)
+	if !ok {
+		return nil, fmt.Errorf("node %s answered with %s", owner, msg.Type)
+	}
+	return region.Boards, nil
+}
+
+// receiveFrames handles the frames other nodes send to this one
+func (h *GameHub) receiveFrames() {
+	for packet := range h.node.bus.Receive() {
+		f := &frame{}
+		if err := json.Unmarshal(packet.Data, f); err != nil {
+			log.Printf("⚠️ Invalid frame from node %s: %v", packet.From, err)
+			continue
+		}
+		h.handleFrame(packet.From, f)
+	}
+}
+
+func (h *GameHub) handleFrame(from cluster.NodeID, f *frame) {
+	if err := checkFrame(f); err != nil {
+		log.Printf("⚠️ Refused %s frame from node %s: %v", f.Kind, from, err)
+		if f.CallID != 0 && f.Kind != frameReply {
+			h.reply(from, f, &frame{Error: err.Error()})
+		}
+		return
+	}
+
+	switch f.Kind {
+	case frameRequest:
+		msg, err := clusterCodec.Decode(f.Message)
+		if err != nil {
+			log.Printf("⚠️ Invalid request forwarded by node %s: %v", from, err)
+			return
+		}
+		req, ok := msg.Data.(types.BoardRequest)
+		if !ok {
+			log.Printf("⚠️ Node %s forwarded a %s, which is not a board request", from, msg.Type)
+			return
+		}
+		inMsg := &InboundMessage{ClientID: f.ClientID, Message: msg, remote: h.frameClient(from, f), received: time.Now()}
+		s := h.shardFor(req.Board())
+		s.do(func() { s.processInboundMessage(inMsg) })
+
+	case frameSubscribe:
+		remote := h.frameClient(from, f)
+		for _, zoneID := range f.Zones {
+			zoneID, s := zoneID, h.shardForZone(zoneID)
+			s.do(func() { s.subscribe(zoneID, f.ClientID, remote) })
+		}
+
+	case frameUnsubscribe:
+		for _, zoneID := range f.Zones {
+			zoneID, s := zoneID, h.shardForZone(zoneID)
+			s.do(func() { s.unsubscribe(zoneID, f.ClientID) })
+		}
+
+	case framePlacement:
+		h.applyPlacements(f.Placements)
+
+	case frameHandoff:
+		h.receiveHandoff(f.Handoffs)
+
+	case frameHandoffAck:
+		h.forgetReleased(f.Released)
+
+	case frameRelease:
+		h.node.remotesMux.Lock()
+		delete(h.node.remotes, f.ClientID)
+		h.node.remotesMux.Unlock()
+		h.releaseLocalSeats(f.ClientID)
+
+	case frameDeliver:
+		msg, err := clusterCodec.Decode(f.Message)
+		if err != nil {
+			log.Printf("⚠️ Invalid message delivered by node %s: %v", from, err)
+			return
+		}
+		m := queuedMessage{msg: msg, board: f.Board}
+		for _, clientID := range f.Clients {
+			h.deliver(clientID, m)
+		}
+
+	case frameFetchBoards:
+		go h.answerFetchBoards(from, f)
+
+	case frameExportSGF:
+		go func() {
+			data, found := h.exportSGF(*f.Board)
+			h.reply(from, f, &frame{Data: data, Found: found})
+		}()
+
+	case frameImportSGF:
+		go func() {
+			r := &frame{}
+			if err := h.importSGF(*f.Board, f.Data); err != nil {
+				r.Error = err.Error()
+			}
+			h.reply(from, f, r)
+		}()
+
+	case frameReply:
+		h.node.callsMux.Lock()
+		reply := h.node.calls[f.CallID]
+		h.node.callsMux.Unlock()
+		if reply != nil {
+			// A duplicate reply, or one to a call that gave up, is dropped
+			select {
+			case reply <- f:
+			default:
+			}
+		}
+
+	default:
+		log.Printf("⚠️ Unknown frame %q from node %s", f.Kind, from)
+	}
+}
+
+// checkFrame rejects frames lacking what their kind needs, so a faulty
+// peer can neither crash the node nor act for a client it does not name
+func checkFrame(f *frame) error {
+	switch f.Kind {
+	case frameRequest, frameSubscribe, frameUnsubscribe, frameRelease:
+		if f.ClientID == "" {
+			return errors.New("no client ID")
+		}
+	case frameExportSGF, frameImportSGF:
+		if f.Board == nil {
+			return errors.New("no board")
+		}
+		if !onGrid(*f.Board) {
+			return fmt.Errorf("board %s is off the grid", *f.Board)
+		}
+	case frameFetchBoards:
+		for _, coord := range f.Coords {
+			if !onGrid(coord) {
+				return fmt.Errorf("board %s is off the grid", coord)
+			}
+		}
+	case framePlacement:
+		for i, nodes := range f.Placements {
+			if len(nodes) == 0 {
+				return fmt.Errorf("placement %d lists no nodes", i+1)
+			}
+		}
+	}
+	for _, zoneID := range f.Zones {
+		if int(zoneID) >= cluster.ZoneCount {
+			return fmt.Errorf("zone %d is off the grid", zoneID)
+		}
+	}
+	for _, key := range f.Released {
+		if int(key.Zone) >= cluster.ZoneCount {
+			return fmt.Errorf("zone %d is off the grid", key.Zone)
+		}
+	}
+	for _, hz := range f.Handoffs {
+		if hz == nil || int(hz.Zone) >= cluster.ZoneCount {
+			return errors.New("handoff of a zone off the grid")
+		}
+		for _, b := range hz.Boards {
+			if b.State == nil || !onGrid(b.Coord) || types.ZoneFor(b.Coord) != hz.Zone {
+				return fmt.Errorf("handoff of zone %d holds a board of another zone", hz.Zone)
+			}
+		}
+	}
+	return nil
+}
+
+// onGrid reports whether a board lies on the grid
+func onGrid(coord types.BoardCoordinate) bool {
+	x, y := coord.Unpack()
+	return x < types.GridSize && y < types.GridSize
+}
+
+// answerFetchBoards replies to another node's region fetch with the
+// boards it asked for. A node whose placement is behind may ask for
+// boards this one no longer owns, which it must not be given stale.
+func (h *GameHub) answerFetchBoards(from cluster.NodeID, call *frame) {
+	for _, coord := range call.Coords {
+		if !h.owns(coord) {
+			h.reply(from, call, &frame{Error: fmt.Sprintf("board %s is not on node %s", coord, h.node.bus.Self())})
+			return
+		}
+	}
+	msg := &types.Message{
+		Type:      types.MsgRegionData,
+		Timestamp: time.Now().Unix(),
+		Data:      &types.RegionDataResponse{Boards: h.snapshotBoards(call.Coords)},
+	}
+	data, err := clusterCodec.Encode(msg)
+	if err != nil {
+		h.reply(from, call, &frame{Error: err.Error()})
+		return
+	}
+	h.reply(from, call, &frame{Message: data})
+}
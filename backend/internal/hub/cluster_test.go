@@ -0,0 +1,221 @@
+package hub
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/one-million-go/backend/internal/cluster"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	board := types.NewBoardCoordinate(3, 700)
+	frames := []*frame{
+		{Kind: frameRequest, ClientID: "c1", Node: "b", Features: []string{"deltas"}, Message: []byte{1, 2, 3}},
+		{Kind: frameSubscribe, ClientID: "c1", Zones: []types.ZoneID{0, 3968}},
+		{Kind: frameDeliver, Clients: []string{"c1", "c2"}, Message: []byte{4}, Board: &board},
+		{Kind: frameFetchBoards, CallID: 7, Coords: []types.BoardCoordinate{board, types.NewBoardCoordinate(999, 999)}},
+		{Kind: frameReply, CallID: 7, Data: []byte("(;GM[1])"), Found: true, Error: "failed"},
+		{Kind: framePlacement, Placements: [][]cluster.NodeID{{"a"}, {"a", "b"}}},
+		{Kind: frameHandoffAck, Released: []zoneKey{{Zone: 3968, Epoch: 2}}},
+	}
+	for _, f := range frames {
+		data, err := json.Marshal(f)
+		if err != nil {
+			t.Fatalf("marshal %s: %v", f.Kind, err)
+		}
+		got := &frame{}
+		if err := json.Unmarshal(data, got); err != nil {
+			t.Fatalf("unmarshal %s: %v", f.Kind, err)
+		}
+		if !reflect.DeepEqual(got, f) {
+			t.Errorf("%s frame changed in transit:\n got %+v\nwant %+v", f.Kind, got, f)
+		}
+	}
+}
+
+func TestCheckFrame(t *testing.T) {
+	onBoard := types.NewBoardCoordinate(999, 0)
+	offBoard := types.NewBoardCoordinate(1000, 0)
+	tests := []struct {
+		name  string
+		frame *frame
+		ok    bool
+	}{
+		{"request", &frame{Kind: frameRequest, ClientID: "c1"}, true},
+		{"request without client", &frame{Kind: frameRequest}, false},
+		{"subscribe without client", &frame{Kind: frameSubscribe, Zones: []types.ZoneID{1}}, false},
+		{"unsubscribe without client", &frame{Kind: frameUnsubscribe, Zones: []types.ZoneID{1}}, false},
+		{"release without client", &frame{Kind: frameRelease}, false},
+		{"zone off the grid", &frame{Kind: frameSubscribe, ClientID: "c1", Zones: []types.ZoneID{cluster.ZoneCount}}, false},
+		{"export", &frame{Kind: frameExportSGF, Board: &onBoard}, true},
+		{"export without board", &frame{Kind: frameExportSGF}, false},
+		{"import without board", &frame{Kind: frameImportSGF, Data: []byte("(;)")}, false},
+		{"import off the grid", &frame{Kind: frameImportSGF, Board: &offBoard}, false},
+		{"fetch", &frame{Kind: frameFetchBoards, Coords: []types.BoardCoordinate{onBoard}}, true},
+		{"fetch off the grid", &frame{Kind: frameFetchBoards, Coords: []types.BoardCoordinate{onBoard, offBoard}}, false},
+		{"deliver", &frame{Kind: frameDeliver, Clients: []string{"c1"}}, true},
+		{"placement", &frame{Kind: framePlacement, Placements: [][]cluster.NodeID{{"a"}, {"a", "b"}}}, true},
+		{"placement without nodes", &frame{Kind: framePlacement, Placements: [][]cluster.NodeID{{"a"}, {}}}, false},
+		{"ack off the grid", &frame{Kind: frameHandoffAck, Released: []zoneKey{{Zone: cluster.ZoneCount}}}, false},
+		{"handoff", &frame{Kind: frameHandoff, Handoffs: []*zoneHandoff{{Zone: types.ZoneFor(onBoard), Boards: []handoffBoard{{Coord: onBoard, State: &types.BoardState{}}}}}}, true},
+		{"handoff of another zone's board", &frame{Kind: frameHandoff, Handoffs: []*zoneHandoff{{Zone: 0, Boards: []handoffBoard{{Coord: onBoard, State: &types.BoardState{}}}}}}, false},
+		{"handoff without state", &frame{Kind: frameHandoff, Handoffs: []*zoneHandoff{{Zone: types.ZoneFor(onBoard), Boards: []handoffBoard{{Coord: onBoard}}}}}, false},
+		{"nil handoff", &frame{Kind: frameHandoff, Handoffs: []*zoneHandoff{nil}}, false},
+	}
+	for _, tt := range tests {
+		if err := checkFrame(tt.frame); (err == nil) != tt.ok {
+			t.Errorf("%s: checkFrame = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+// startCluster runs a hub per node on an in-process network. wrap, when
+// given, wraps the bus of each node.
+func startCluster(t *testing.T, placement cluster.Placement, wrap func(cluster.Bus) cluster.Bus, nodes ...cluster.NodeID) map[cluster.NodeID]*testServer {
+	t.Helper()
+	network := cluster.NewNetwork()
+	servers := make(map[cluster.NodeID]*testServer)
+	for _, id := range nodes {
+		config := testConfig(2)
+		config.Bus = network.Join(id)
+		if wrap != nil {
+			config.Bus = wrap(config.Bus)
+		}
+		config.Placement = placement
+		servers[id] = startHub(t, config)
+		t.Cleanup(func() { config.Bus.Close() })
+	}
+	return servers
+}
+
+func TestClusterProxiesClients(t *testing.T) {
+	// Zones in ranges, so node a owns the top half of the grid
+	nodes := []cluster.NodeID{"a", "b"}
+	servers := startCluster(t, cluster.NewRanges(nodes), nil, nodes...)
+
+	// Board rows 496 to 511 lie in zones of node a, 512 on in zones of b
+	local := types.NewBoardCoordinate(0, 511)
+	remote := types.NewBoardCoordinate(0, 512)
+	if !servers["a"].hub.owns(local) || servers["a"].hub.owns(remote) || !servers["b"].hub.owns(remote) {
+		t.Fatal("boards are not placed as the test expects")
+	}
+
+	black := servers["a"].connect(t)
+	white := servers["b"].connect(t)
+	watcher := servers["a"].connect(t)
+
+	// Seats and moves on b's board, one player through each node
+	black.claimSeat(0, 512, "black")
+	white.claimSeat(0, 512, "white")
+	if result := black.move(0, 512, 60); !result.Success {
+		t.Fatalf("forwarded move failed: %+v", result.Error)
+	}
+	if result := black.move(0, 511, 60); result.Success {
+		t.Fatal("move on a board without a seat succeeded")
+	}
+
+	// A region across both nodes is gathered from both
+	region := watcher.fetchRegion(0, 510, 1, 4)
+	if len(region.Boards) != 4 {
+		t.Fatalf("region holds %d boards, want 4", len(region.Boards))
+	}
+	if board := region.Boards[remote]; board == nil || board.MoveCount != 1 || board.Version != 1 {
+		t.Fatalf("board of node b in the region: %+v, want the move played", board)
+	}
+	if board := region.Boards[local]; board == nil || board.MoveCount != 0 {
+		t.Fatalf("board of node a in the region: %+v, want it empty", board)
+	}
+
+	// A subscription to b's zone receives the moves played there. The
+	// fetch goes to b after the subscription, so it is registered once
+	// the fetch is answered.
+	zones := watcher.subscribe(0, 512)
+	found := false
+	for _, zoneID := range zones {
+		found = found || zoneID == types.ZoneFor(remote)
+	}
+	if !found {
+		t.Fatalf("subscribed to zones %v, missing zone %d", zones, types.ZoneFor(remote))
+	}
+	if board := watcher.fetchBoard(0, 512); board.MoveCount != 1 {
+		t.Fatalf("forwarded fetch: %d moves, want 1", board.MoveCount)
+	}
+
+	if result := white.move(0, 512, 61); !result.Success {
+		t.Fatalf("move failed: %+v", result.Error)
+	}
+	delta := watcher.awaitType(types.MsgBoardDelta, nil).Data.(*types.BoardDelta)
+	if delta.BoardX != 0 || delta.BoardY != 512 || delta.Version != 2 || delta.MoveCount != 2 {
+		t.Errorf("delta %+v, want move 2 on (0,512)", delta)
+	}
+	black.awaitType(types.MsgBoardDelta, func(msg *types.Message) bool {
+		return msg.Data.(*types.BoardDelta).Version == 2
+	})
+}
+
+func TestGoneNodeIsForgotten(t *testing.T) {
+	nodes := []cluster.NodeID{"a", "b"}
+	buses := make(map[cluster.NodeID]cluster.Bus)
+	servers := startCluster(t, cluster.NewRanges(nodes), func(bus cluster.Bus) cluster.Bus {
+		buses[bus.Self()] = bus
+		return bus
+	}, nodes...)
+
+	// White plays on a board of node a through node b, and watches it
+	coord := types.NewBoardCoordinate(0, 400)
+	black := servers["a"].connect(t)
+	white := servers["b"].connect(t)
+	black.claimSeat(0, 400, "black")
+	white.claimSeat(0, 400, "white")
+	white.subscribe(0, 400)
+	white.fetchBoard(0, 400)
+	a := servers["a"].hub
+	if _, ok := a.remoteNode(white.id); !ok {
+		t.Fatal("node a does not know white is on node b")
+	}
+
+	// The delta for white fails to reach b once it has left
+	buses["b"].Close()
+	if result := black.move(0, 400, 60); !result.Success {
+		t.Fatalf("move: %+v", result.Error)
+	}
+	other := servers["a"].connect(t)
+	deadline := time.Now().Add(awaitTimeout)
+	for {
+		reply := other.claimSeatReply(0, 400, "white")
+		if _, granted := reply.Data.(*types.BoardState); granted {
+			break
+		}
+		if data := reply.Data.(*types.ErrorData); data.Code != "SEAT_TAKEN" || time.Now().After(deadline) {
+			t.Fatalf("white seat not released: %s", data.Code)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := a.remoteNode(white.id); ok {
+		t.Error("node a still routes white to node b")
+	}
+	s := a.shardFor(coord)
+	subscribed := true
+	s.call(func() { subscribed = s.zoneSubscriptions[types.ZoneFor(coord)][white.id] })
+	if subscribed {
+		t.Error("white still subscribed on node a")
+	}
+}
+
+func TestFetchBoardsOnlyFromOwner(t *testing.T) {
+	nodes := []cluster.NodeID{"a", "b"}
+	servers := startCluster(t, cluster.NewRanges(nodes), nil, nodes...)
+	b := servers["b"].hub
+
+	owned := []types.BoardCoordinate{types.NewBoardCoordinate(0, 0), types.NewBoardCoordinate(5, 511)}
+	if boards, err := b.fetchRemoteBoards("a", owned); err != nil || len(boards) != len(owned) {
+		t.Fatalf("fetch of node a's boards: %d boards, error %v", len(boards), err)
+	}
+	mixed := append(owned, types.NewBoardCoordinate(0, 512))
+	if _, err := b.fetchRemoteBoards("a", mixed); err == nil {
+		t.Error("node a answered for a board of node b")
+	}
+}
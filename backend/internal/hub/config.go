@@ -0,0 +1,77 @@
+package hub
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/one-million-go/backend/internal/cluster"
+	"github.com/one-million-go/backend/internal/storage"
+	"github.com/one-million-go/backend/pkg/rules"
+)
+
+// Config holds server-wide game settings
+type Config struct {
+	// KoRule is applied to every new board unless changed with SET_RULESET
+	KoRule rules.KoRule
+
+	// Scoring and Komi are applied to every new board
+	Scoring rules.Scoring
+	Komi    float64
+
+	// Store persists boards across restarts; nil keeps them in memory only
+	Store storage.Store
+
+	// EvictAfter is how long a board may go without moves before it is
+	// dropped from memory and reloaded from Store on next access. Zero
+	// keeps every board in memory.
+	EvictAfter time.Duration
+
+	// ResumeGrace is how long a disconnected client that completed the
+	// handshake keeps its seats and subscriptions for a reconnect, and
+	// how long a resume token is valid after its WELCOME. Zero disables
+	// resuming.
+	ResumeGrace time.Duration
+
+	// ResumeSecret signs resume tokens; a random secret is used when empty
+	ResumeSecret []byte
+
+	// SlowClientDrop is how many messages may wait for a client before
+	// board updates to it are dropped; it resyncs with FETCH_BOARD
+	// sinceVersion. SlowClientLimit is how many may wait before the
+	// client is disconnected. Board updates never disconnect a client:
+	// a SlowClientDrop of zero or above the limit drops them once the
+	// limit is reached.
+	SlowClientDrop  int
+	SlowClientLimit int
+
+	// Shards is how many goroutines own the boards, each a share of the
+	// zones with their boards and subscribers
+	Shards int
+
+	// Bus connects the server to the other nodes of its cluster, and
+	// Placement tells which node owns each zone. A nil Bus runs a single
+	// node owning every board.
+	Bus       cluster.Bus
+	Placement cluster.Placement
+
+	// PlacementFile keeps the placement across restarts: every rebalance
+	// is saved to it, and a saved placement replaces Placement's nodes at
+	// startup. Empty starts from Placement every time.
+	PlacementFile string
+}
+
+// DefaultConfig returns the settings used when none are given
+func DefaultConfig() Config {
+	return Config{
+		KoRule:      rules.DefaultKoRule,
+		Scoring:     rules.DefaultScoring,
+		Komi:        rules.DefaultKomi,
+		EvictAfter:  30 * time.Minute,
+		ResumeGrace: 2 * time.Minute,
+
+		SlowClientDrop:  128,
+		SlowClientLimit: 512,
+
+		Shards: runtime.NumCPU(),
+	}
+}
@@ -0,0 +1,89 @@
+package hub
+
+import (
+	"testing"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// resync sends FETCH_BOARD from a known version and returns the reply,
+// BOARD_DELTAS or BOARD_STATE
+func (c *testClient) resync(x, y uint16, since uint32) *types.Message {
+	c.send(types.MsgFetchBoard, &types.FetchBoardData{BoardX: x, BoardY: y, SinceVersion: &since})
+	return c.await("reply to FETCH_BOARD", func(msg *types.Message) bool {
+		return msg.Type == types.MsgBoardDeltas || msg.Type == types.MsgBoardState || msg.Type == types.MsgError
+	})
+}
+
+func TestResyncFromVersion(t *testing.T) {
+	server := startHub(t, testConfig(2))
+	black, white, watcher := server.connect(t), server.connect(t), server.connect(t)
+	black.claimSeat(3, 3, "black")
+	white.claimSeat(3, 3, "white")
+	start := watcher.fetchBoard(3, 3).Version
+
+	// Black fills the top rows and white the bottom ones, so nothing is
+	// ever captured
+	var played []uint16
+	play := func(moves int) {
+		t.Helper()
+		for i := 0; i < moves; i++ {
+			n := uint16(len(played))
+			player, pos := black, n/2
+			if n%2 == 1 {
+				player, pos = white, types.BoardPoints-1-n/2
+			}
+			if result := player.move(3, 3, pos); !result.Success {
+				t.Fatalf("move %d at %d: %+v", n+1, pos, result.Error)
+			}
+			played = append(played, pos)
+		}
+	}
+
+	play(5)
+	reply := watcher.resync(3, 3, start)
+	resynced, ok := reply.Data.(*types.BoardDeltasData)
+	if !ok {
+		t.Fatalf("resync answered with %s, want BOARD_DELTAS", reply.Type)
+	}
+	if resynced.Version != start+5 || len(resynced.Deltas) != 5 {
+		t.Fatalf("%d deltas up to version %d, want 5 up to %d", len(resynced.Deltas), resynced.Version, start+5)
+	}
+	for i, delta := range resynced.Deltas {
+		if delta.Version != start+uint32(i)+1 || delta.Move == nil || delta.Move.Position != played[i] {
+			t.Errorf("delta %d: version %d with move %+v, want version %d at %d", i, delta.Version, delta.Move, start+uint32(i)+1, played[i])
+		}
+	}
+
+	reply = watcher.resync(3, 3, start+5)
+	if resynced, ok := reply.Data.(*types.BoardDeltasData); !ok || len(resynced.Deltas) != 0 {
+		t.Errorf("resync from the current version answered with %s %+v, want no deltas", reply.Type, reply.Data)
+	}
+	reply = watcher.resync(3, 3, start+6)
+	if _, ok := reply.Data.(*types.BoardState); !ok {
+		t.Errorf("resync from a future version answered with %s, want BOARD_STATE", reply.Type)
+	}
+
+	// The oldest version still reachable is maxRecentDeltas behind
+	play(maxRecentDeltas - 5)
+	current := start + maxRecentDeltas
+	reply = watcher.resync(3, 3, start)
+	if resynced, ok := reply.Data.(*types.BoardDeltasData); !ok || len(resynced.Deltas) != maxRecentDeltas || resynced.Deltas[0].Version != start+1 {
+		t.Fatalf("resync from %d deltas back answered with %s, want all of them", maxRecentDeltas, reply.Type)
+	}
+
+	// One more move and the gap is too large for deltas
+	play(1)
+	reply = watcher.resync(3, 3, start)
+	board, ok := reply.Data.(*types.BoardState)
+	if !ok {
+		t.Fatalf("resync past the kept deltas answered with %s, want BOARD_STATE", reply.Type)
+	}
+	if board.Version != current+1 || int(board.MoveCount) != len(played) {
+		t.Errorf("board at version %d with %d moves, want %d with %d", board.Version, board.MoveCount, current+1, len(played))
+	}
+	reply = watcher.resync(3, 3, start+1)
+	if resynced, ok := reply.Data.(*types.BoardDeltasData); !ok || len(resynced.Deltas) != maxRecentDeltas {
+		t.Errorf("resync from the oldest kept version answered with %s, want %d deltas", reply.Type, maxRecentDeltas)
+	}
+}
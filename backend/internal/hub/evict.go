@@ -0,0 +1,110 @@
+package hub
+
+import (
+	"log"
+	"time"
+
+	"github.com/one-million-go/backend/pkg/rules"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// evictInterval returns how often idle boards are looked for
+func evictInterval(evictAfter time.Duration) time.Duration {
+	interval := evictAfter / 4
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+// restoreBoard prepares a board loaded from storage for play
+func restoreBoard(state *types.BoardState) *types.BoardState {
+	// Seats belonged to connections that no longer exist
+	state.BlackPlayer = ""
+	state.WhitePlayer = ""
+	if len(state.History) == 0 {
+		state.History = rules.InitialHistory()
+	}
+	return state
+}
+
+// findBoardState returns a board held in memory, reloading it from
+// storage if it was evicted. It returns nil for boards that were never
+// played on, without reading the store.
+func (s *shard) findBoardState(coord types.BoardCoordinate) *types.BoardState {
+	state, exists := s.boardStates[coord]
+	if exists {
+		return state
+	}
+
+	if s.hub.config.Store == nil || !s.stored[coord] {
+		return nil
+	}
+	state, err := s.hub.config.Store.Load(coord)
+	if err != nil {
+		log.Printf("⚠️ Failed to load board %s: %v", coord, err)
+		return nil
+	}
+	if state == nil {
+		return nil
+	}
+
+	s.boardStates[coord] = restoreBoard(state)
+	s.hub.stats.activeBoards.Add(1)
+	return state
+}
+
+// viewBoardState returns a board for reading. Boards that were never
+// played on are served from a shared empty state without allocating;
+// callers must not modify it. Handlers that go on to modify the board
+// first check that the client holds a seat, which the empty state never
+// has.
+func (s *shard) viewBoardState(coord types.BoardCoordinate) *types.BoardState {
+	if state := s.findBoardState(coord); state != nil {
+		return state
+	}
+	return s.hub.emptyBoard
+}
+
+// isPristine reports whether a board holds nothing worth keeping
+func (s *shard) isPristine(state *types.BoardState) bool {
+	return state.MoveCount == 0 &&
+		state.BlackStones == [46]byte{} && state.WhiteStones == [46]byte{} &&
+		state.KoRule == string(s.hub.config.KoRule)
+}
+
+// evictIdleBoards drops boards without moves for EvictAfter from memory.
+// Boards with seated players are kept, and boards that changed recently
+// keep a decaying Activity count that must reach zero first. Only boards
+// in the store, or never played on, are dropped.
+func (s *shard) evictIdleBoards() {
+	cutoff := uint32(time.Now().Add(-s.hub.config.EvictAfter).Unix())
+
+	evicted := 0
+	for coord, state := range s.boardStates {
+		if state.BlackPlayer != "" || state.WhitePlayer != "" {
+			continue
+		}
+		if state.Activity > 0 {
+			state.Activity /= 2
+			continue
+		}
+		if state.LastMove >= cutoff {
+			continue
+		}
+		if !s.stored[coord] && !s.isPristine(state) {
+			continue
+		}
+
+		delete(s.boardStates, coord)
+		evicted++
+	}
+	s.hub.stats.activeBoards.Add(int64(-evicted))
+
+	if evicted > 0 {
+		log.Printf("🧊 Shard %d evicted %d idle boards (%d remain in memory)", s.id, evicted, len(s.boardStates))
+	}
+}
@@ -0,0 +1,86 @@
+package hub
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/one-million-go/backend/internal/storage"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// countingStore counts the boards read from a store
+type countingStore struct {
+	storage.Store
+	loads atomic.Int64
+}
+
+func (s *countingStore) Load(coord types.BoardCoordinate) (*types.BoardState, error) {
+	s.loads.Add(1)
+	return s.Store.Load(coord)
+}
+
+func TestEvictedBoardReloads(t *testing.T) {
+	journal, err := storage.NewJournalStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewJournalStore: %v", err)
+	}
+	t.Cleanup(func() { journal.Close() })
+	store := &countingStore{Store: journal}
+	config := testConfig(2)
+	config.Store = store
+	config.EvictAfter = time.Hour
+	server := startHub(t, config)
+
+	coord := types.NewBoardCoordinate(3, 3)
+	black, white := server.connect(t), server.connect(t)
+	black.claimSeat(3, 3, "black")
+	white.claimSeat(3, 3, "white")
+	for i, player := range []*testClient{black, white, black} {
+		if result := player.move(3, 3, uint16(60+i)); !result.Success {
+			t.Fatalf("move %d: %+v", i, result.Error)
+		}
+	}
+
+	// Once its players are gone, the board is made idle for longer than
+	// EvictAfter
+	black.conn.Close()
+	white.conn.Close()
+	s := server.hub.shardFor(coord)
+	deadline := time.Now().Add(awaitTimeout)
+	for idle := false; !idle; {
+		s.call(func() {
+			state := s.boardStates[coord]
+			if state.BlackPlayer == "" && state.WhitePlayer == "" {
+				state.LastMove -= 2 * 3600
+				state.Activity = 0
+				idle = true
+			}
+		})
+		if !idle && time.Now().After(deadline) {
+			t.Fatal("seats not released after the players left")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.call(s.evictIdleBoards)
+	if holds(server.hub, coord) {
+		t.Fatal("idle board was not evicted")
+	}
+
+	loads := store.loads.Load()
+	reader := server.connect(t)
+	if board := reader.fetchBoard(3, 3); board.MoveCount != 3 || board.Version != 3 {
+		t.Errorf("reloaded board has %d moves at version %d, want 3 at version 3", board.MoveCount, board.Version)
+	}
+	if !holds(server.hub, coord) || store.loads.Load() != loads+1 {
+		t.Errorf("board read %d times from the store and held %v, want once and held", store.loads.Load()-loads, holds(server.hub, coord))
+	}
+
+	// Boards never played on are answered without reading the store
+	if region := reader.fetchRegion(8, 8, 8, 8); len(region.Boards) == 0 {
+		t.Error("empty region fetch returned no boards")
+	}
+	if n := store.loads.Load() - loads; n != 1 {
+		t.Errorf("store read %d times after fetching boards never played on, want once", n)
+	}
+}
@@ -0,0 +1,447 @@
+package hub
+
+import (
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/one-million-go/backend/pkg/protocol"
+	"github.com/one-million-go/backend/pkg/rules"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// errNotPlaying is returned for moves and passes once play has stopped
+var errNotPlaying = &rules.MoveError{Code: "GAME_NOT_PLAYING", Message: "Board is not in the playing phase"}
+
+// endTurn records a move or pass by the current player in the board's
+// history and advances the board to the other player
+func endTurn(boardState *types.BoardState, position uint16, captures int) {
+	boardState.MoveCount++
+	boardState.LastMove = uint32(time.Now().Unix())
+
+	move := types.Move{
+		Position: position,
+		Player:   boardState.CurrentPlayer,
+		MoveNum:  boardState.MoveCount,
+		X:        types.BoardSize,
+		Y:        types.BoardSize,
+		Captures: uint16(captures),
+	}
+	if !move.IsPass() {
+		move.X = uint8(position % types.BoardSize)
+		move.Y = uint8(position / types.BoardSize)
+	}
+	boardState.Moves = append(boardState.Moves, move)
+	if boardState.Activity < 255 {
+		boardState.Activity++
+	}
+
+	// Toggle current player
+	if boardState.CurrentPlayer == 0 {
+		boardState.CurrentPlayer = 1
+	} else {
+		boardState.CurrentPlayer = 0
+	}
+}
+
+// playPass records a pass by the current player. The position is
+// repeated with the other player to move.
+func playPass(boardState *types.BoardState) {
+	last := boardState.History[len(boardState.History)-1]
+	boardState.History = append(boardState.History, types.PositionHash{
+		Hash:   last.Hash,
+		Player: boardState.CurrentPlayer,
+		Pass:   true,
+	})
+	boardState.Passes++
+	endTurn(boardState, types.PassPosition, 0)
+}
+
+// setPhase moves a board to a new game phase. Watchers are told with
+// notifyPhase once the change is complete.
+func setPhase(boardState *types.BoardState, phase byte) {
+	boardState.GamePhase = phase
+	boardState.BlackAccepted = false
+	boardState.WhiteAccepted = false
+}
+
+// notifyPhase tells a board's watchers which phase it is in now
+func (s *shard) notifyPhase(coord types.BoardCoordinate, boardState *types.BoardState, reason string) {
+	x, y := coord.Unpack()
+	msg := &types.Message{
+		ID:        uuid.New().String(),
+		Type:      types.MsgGamePhase,
+		Timestamp: time.Now().Unix(),
+		Data: &types.GamePhaseData{
+			BoardX:  x,
+			BoardY:  y,
+			Version: boardState.Version,
+			Phase:   boardState.GamePhase,
+			Result:  boardState.Result,
+			Reason:  reason,
+		},
+	}
+
+	if recipients := s.boardWatchers(coord, boardState); len(recipients) > 0 {
+		s.hub.sendOutboundMessage(&OutboundMessage{
+			Recipients: recipients,
+			Message:    msg,
+			Board:      &coord,
+		})
+	}
+
+	log.Printf("🏁 Board (%d,%d) entered phase %d (%s) %s", x, y, boardState.GamePhase, reason, boardState.Result)
+}
+
+// boardWatchers returns the clients that follow a board's game: its
+// seated players and every client subscribed to its zone
+func (s *shard) boardWatchers(coord types.BoardCoordinate, boardState *types.BoardState) []string {
+	watchers := s.zoneSubscribers(coord)
+	for _, id := range []string{boardState.BlackPlayer, boardState.WhitePlayer} {
+		if id != "" && !containsString(watchers, id) {
+			watchers = append(watchers, id)
+		}
+	}
+	return watchers
+}
+
+// maxRecentDeltas is how many deltas a board keeps for clients
+// resyncing with FETCH_BOARD sinceVersion
+const maxRecentDeltas = 64
+
+// bumpVersion marks a board as changed. Changes that have no delta break
+// the delta chain, so clients behind them get the full board instead.
+func bumpVersion(boardState *types.BoardState) {
+	boardState.Version++
+	boardState.RecentDeltas = nil
+}
+
+// recordDelta advances the board's version for its latest change and
+// keeps the delta describing it. withMove adds the last recorded move,
+// which resignations do not have.
+func recordDelta(coord types.BoardCoordinate, boardState *types.BoardState, captured []int, withMove bool) *types.BoardDelta {
+	boardState.Version++
+
+	x, y := coord.Unpack()
+	delta := &types.BoardDelta{
+		BoardX:        x,
+		BoardY:        y,
+		Version:       boardState.Version,
+		MoveCount:     boardState.MoveCount,
+		CurrentPlayer: boardState.CurrentPlayer,
+		GamePhase:     boardState.GamePhase,
+		Result:        boardState.Result,
+	}
+	if withMove && len(boardState.Moves) > 0 {
+		move := boardState.Moves[len(boardState.Moves)-1]
+		delta.Move = &move
+	}
+	if len(captured) > 0 {
+		delta.Captured = make([]uint16, len(captured))
+		for i, pos := range captured {
+			delta.Captured[i] = uint16(pos)
+		}
+	}
+
+	if len(boardState.RecentDeltas) >= maxRecentDeltas {
+		boardState.RecentDeltas = boardState.RecentDeltas[1:]
+	}
+	boardState.RecentDeltas = append(boardState.RecentDeltas, delta)
+	return delta
+}
+
+// deltasSince returns the deltas that take a client from version since
+// to the board's current version, or false when they are no longer all
+// available
+func deltasSince(boardState *types.BoardState, since uint32) ([]*types.BoardDelta, bool) {
+	if since > boardState.Version {
+		return nil, false
+	}
+	missing := int(boardState.Version - since)
+	if missing > len(boardState.RecentDeltas) {
+		return nil, false
+	}
+	return boardState.RecentDeltas[len(boardState.RecentDeltas)-missing:], true
+}
+
+// broadcastDelta sends a board change to the board's watchers, except
+// the client who made it and already got a MOVE_RESULT. Clients without
+// the deltas feature get a BOARD_UPDATE with the whole board instead.
+func (s *shard) broadcastDelta(coord types.BoardCoordinate, boardState *types.BoardState, delta *types.BoardDelta, originator string) {
+	deltaRecipients := make([]string, 0)
+	updateRecipients := make([]string, 0)
+	for _, id := range s.boardWatchers(coord, boardState) {
+		if id == originator {
+			continue
+		}
+		if s.hub.hasFeature(id, protocol.FeatureDeltas) {
+			deltaRecipients = append(deltaRecipients, id)
+		} else {
+			updateRecipients = append(updateRecipients, id)
+		}
+	}
+
+	if len(deltaRecipients) > 0 {
+		s.hub.sendOutboundMessage(&OutboundMessage{
+			Recipients: deltaRecipients,
+			Message: &types.Message{
+				ID:        uuid.New().String(),
+				Type:      types.MsgBoardDelta,
+				Timestamp: time.Now().Unix(),
+				Data:      delta,
+			},
+			Board: &coord,
+		})
+	}
+	if len(updateRecipients) > 0 {
+		s.hub.sendOutboundMessage(&OutboundMessage{
+			Recipients: updateRecipients,
+			Message: &types.Message{
+				ID:        uuid.New().String(),
+				Type:      types.MsgBoardUpdate,
+				Timestamp: time.Now().Unix(),
+				Data: &types.BoardUpdateData{
+					BoardX:   delta.BoardX,
+					BoardY:   delta.BoardY,
+					Move:     delta.Move,
+					NewState: boardState.Snapshot(),
+				},
+			},
+			Board: &coord,
+		})
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBoardAction decodes a PASS/RESIGN/ACCEPT_RESULT payload
+func (s *shard) parseBoardAction(inMsg *InboundMessage) (types.BoardCoordinate, *types.BoardState, bool) {
+	req, ok := requestData[types.BoardActionData](s.hub, inMsg)
+	if !ok {
+		return 0, nil, false
+	}
+
+	coord := types.NewBoardCoordinate(req.BoardX, req.BoardY)
+	return coord, s.viewBoardState(coord), true
+}
+
+func (s *shard) handlePass(inMsg *InboundMessage) {
+	coord, boardState, ok := s.parseBoardAction(inMsg)
+	if !ok {
+		return
+	}
+
+	if boardState.GamePhase != types.PhasePlaying {
+		s.sendMoveRejected(inMsg, errNotPlaying)
+		return
+	}
+	if err := checkTurn(boardState, inMsg.ClientID); err != nil {
+		s.sendMoveRejected(inMsg, err)
+		return
+	}
+
+	playPass(boardState)
+	scoring := boardState.Passes >= 2
+	if scoring {
+		boardState.DeadStones = nil
+		updateScore(boardState)
+		setPhase(boardState, types.PhaseScoring)
+	}
+	delta := recordDelta(coord, boardState, nil, true)
+	s.saveBoard(coord, boardState)
+
+	s.sendMoveAccepted(inMsg, boardState, delta)
+	s.broadcastDelta(coord, boardState, delta, inMsg.ClientID)
+	if scoring {
+		s.notifyPhase(coord, boardState, "passes")
+	}
+}
+
+func (s *shard) handleResign(inMsg *InboundMessage) {
+	coord, boardState, ok := s.parseBoardAction(inMsg)
+	if !ok {
+		return
+	}
+
+	if boardState.GamePhase == types.PhaseFinished {
+		s.sendMoveRejected(inMsg, errNotPlaying)
+		return
+	}
+
+	switch inMsg.ClientID {
+	case boardState.BlackPlayer:
+		boardState.Result = "W+R"
+	case boardState.WhitePlayer:
+		boardState.Result = "B+R"
+	default:
+		s.sendMoveRejected(inMsg, errNotSeated)
+		return
+	}
+
+	setPhase(boardState, types.PhaseFinished)
+	delta := recordDelta(coord, boardState, nil, false)
+	s.saveBoard(coord, boardState)
+
+	s.sendMoveAccepted(inMsg, boardState, delta)
+	s.broadcastDelta(coord, boardState, delta, inMsg.ClientID)
+	s.notifyPhase(coord, boardState, "resign")
+}
+
+func (s *shard) handleAcceptResult(inMsg *InboundMessage) {
+	coord, boardState, ok := s.parseBoardAction(inMsg)
+	if !ok {
+		return
+	}
+
+	if boardState.GamePhase != types.PhaseScoring {
+		s.hub.sendError(inMsg.ClientID, "GAME_NOT_SCORING", "Board is not in the scoring phase")
+		return
+	}
+
+	switch inMsg.ClientID {
+	case boardState.BlackPlayer:
+		boardState.BlackAccepted = true
+	case boardState.WhitePlayer:
+		boardState.WhiteAccepted = true
+	default:
+		s.hub.sendError(inMsg.ClientID, "NOT_SEATED", "Only seated players can accept the result")
+		return
+	}
+
+	finished := boardState.BlackAccepted && boardState.WhiteAccepted
+	if finished {
+		updateScore(boardState)
+		boardState.Result = rules.FormatResult(boardState.Score, rules.Scoring(boardState.Scoring))
+		setPhase(boardState, types.PhaseFinished)
+	}
+	bumpVersion(boardState)
+	s.saveBoard(coord, boardState)
+
+	s.sendBoardState(inMsg, boardState)
+	if finished {
+		s.notifyPhase(coord, boardState, "agreement")
+	}
+}
+
+func (s *shard) handleMarkDead(inMsg *InboundMessage) {
+	req, ok := requestData[types.MarkDeadData](s.hub, inMsg)
+	if !ok {
+		return
+	}
+
+	coord := types.NewBoardCoordinate(req.BoardX, req.BoardY)
+	boardState := s.viewBoardState(coord)
+
+	if boardState.GamePhase != types.PhaseScoring {
+		s.hub.sendError(inMsg.ClientID, "GAME_NOT_SCORING", "Board is not in the scoring phase")
+		return
+	}
+	if inMsg.ClientID != boardState.BlackPlayer && inMsg.ClientID != boardState.WhitePlayer {
+		s.hub.sendError(inMsg.ClientID, "NOT_SEATED", "Only seated players can mark dead stones")
+		return
+	}
+
+	if int(req.Position) >= rules.NumPoints || boardState.PointAt(int(req.Position)) == types.PointEmpty {
+		s.hub.sendError(inMsg.ClientID, "INVALID_POSITION", "No stone at position")
+		return
+	}
+
+	// Toggle the whole group: dead if any of it was alive, alive otherwise
+	dead := make(map[uint16]bool, len(boardState.DeadStones))
+	for _, pos := range boardState.DeadStones {
+		dead[pos] = true
+	}
+	group := boardState.GroupAt(int(req.Position))
+	markDead := !dead[req.Position]
+	for _, pos := range group {
+		dead[uint16(pos)] = markDead
+	}
+
+	boardState.DeadStones = nil // Snapshots may still hold the old slice
+	for pos := uint16(0); pos < rules.NumPoints; pos++ {
+		if dead[pos] {
+			boardState.DeadStones = append(boardState.DeadStones, pos)
+		}
+	}
+
+	// Any change invalidates earlier acceptances
+	boardState.BlackAccepted = false
+	boardState.WhiteAccepted = false
+	updateScore(boardState)
+	bumpVersion(boardState)
+	s.saveBoard(coord, boardState)
+
+	s.sendBoardState(inMsg, boardState)
+
+	// Show the opponent and spectators the updated marking as well
+	recipients := make([]string, 0)
+	for _, id := range s.boardWatchers(coord, boardState) {
+		if id != inMsg.ClientID {
+			recipients = append(recipients, id)
+		}
+	}
+	s.broadcastBoardState(coord, boardState, recipients)
+}
+
+// broadcastBoardState sends the whole board to watchers, for changes
+// that have no delta
+func (s *shard) broadcastBoardState(coord types.BoardCoordinate, boardState *types.BoardState, recipients []string) {
+	if len(recipients) == 0 {
+		return
+	}
+	s.hub.sendOutboundMessage(&OutboundMessage{
+		Recipients: recipients,
+		Message: &types.Message{
+			ID:        uuid.New().String(),
+			Type:      types.MsgBoardState,
+			Timestamp: time.Now().Unix(),
+			Data:      boardState.Snapshot(),
+		},
+		Board: &coord,
+	})
+}
+
+// updateScore recomputes the board's score from its stones and the
+// stones currently marked dead
+func updateScore(boardState *types.BoardState) {
+	dead := make([]int, len(boardState.DeadStones))
+	for i, pos := range boardState.DeadStones {
+		dead[i] = int(pos)
+	}
+
+	boardState.Score = rules.Score(boardState, dead)
+}
+
+// sendMoveAccepted replies to a move, pass or resignation with a
+// successful MOVE_RESULT carrying the resulting board change. Clients
+// without the deltas feature get the whole board as well.
+func (s *shard) sendMoveAccepted(inMsg *InboundMessage, boardState *types.BoardState, delta *types.BoardDelta) {
+	s.hub.metrics.moves.Inc()
+	result := &types.MoveResultData{
+		Success: true,
+		MoveID:  uuid.New().String(),
+		Delta:   delta,
+	}
+	if !s.hub.hasFeature(inMsg.ClientID, protocol.FeatureDeltas) {
+		result.BoardState = boardState.Snapshot()
+	}
+
+	response := &types.Message{
+		ID:        inMsg.Message.ID, // Use same ID for response
+		Type:      types.MsgMoveResult,
+		Timestamp: time.Now().Unix(),
+		Data:      result,
+	}
+
+	s.hub.sendOutboundMessage(&OutboundMessage{
+		Recipients: []string{inMsg.ClientID},
+		Message:    response,
+	})
+}
@@ -0,0 +1,104 @@
+package hub
+
+import (
+	"testing"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// act sends a PASS or RESIGN and returns its result
+func (c *testClient) act(msgType types.MessageType, x, y uint16) *types.MoveResultData {
+	return c.request(msgType, &types.BoardActionData{BoardX: x, BoardY: y}).Data.(*types.MoveResultData)
+}
+
+func TestTwoPassesEndPlay(t *testing.T) {
+	server := startHub(t, testConfig(2))
+	black, white := server.connect(t), server.connect(t)
+	black.claimSeat(3, 3, "black")
+	white.claimSeat(3, 3, "white")
+
+	if result := black.move(3, 3, 60); !result.Success {
+		t.Fatalf("move: %+v", result.Error)
+	}
+	if result := white.act(types.MsgPass, 3, 3); !result.Success {
+		t.Fatalf("first pass: %+v", result.Error)
+	}
+	if board := white.fetchBoard(3, 3); board.GamePhase != types.PhasePlaying {
+		t.Fatalf("phase %d after one pass, want playing", board.GamePhase)
+	}
+	if result := black.act(types.MsgPass, 3, 3); !result.Success {
+		t.Fatalf("second pass: %+v", result.Error)
+	}
+	phase := white.awaitType(types.MsgGamePhase, nil).Data.(*types.GamePhaseData)
+	if phase.Phase != types.PhaseScoring {
+		t.Fatalf("phase %d after two passes, want scoring", phase.Phase)
+	}
+	if result := white.move(3, 3, 61); result.Success || result.Error.Code != errNotPlaying.Code {
+		t.Errorf("move while scoring: %+v, want %s", result.Error, errNotPlaying.Code)
+	}
+
+	// Once both players accept the score the game is over
+	black.request(types.MsgAcceptResult, &types.BoardActionData{BoardX: 3, BoardY: 3})
+	white.request(types.MsgAcceptResult, &types.BoardActionData{BoardX: 3, BoardY: 3})
+	board := black.fetchBoard(3, 3)
+	if board.GamePhase != types.PhaseFinished || board.Result != "B+353.5" {
+		t.Fatalf("phase %d with result %q, want finished with B+353.5", board.GamePhase, board.Result)
+	}
+	for _, play := range []func() *types.MoveResultData{
+		func() *types.MoveResultData { return white.move(3, 3, 61) },
+		func() *types.MoveResultData { return white.act(types.MsgPass, 3, 3) },
+		func() *types.MoveResultData { return white.act(types.MsgResign, 3, 3) },
+	} {
+		if result := play(); result.Success || result.Error.Code != errNotPlaying.Code {
+			t.Errorf("play after the game ended: %+v, want %s", result.Error, errNotPlaying.Code)
+		}
+	}
+	if board := black.fetchBoard(3, 3); board.MoveCount != 3 || board.Result != "B+353.5" {
+		t.Errorf("finished game changed to %d moves with result %q", board.MoveCount, board.Result)
+	}
+}
+
+func TestResign(t *testing.T) {
+	tests := []struct {
+		color  string
+		result string
+	}{
+		{"black", "W+R"},
+		{"white", "B+R"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.color, func(t *testing.T) {
+			server := startHub(t, testConfig(2))
+			black, white, watcher := server.connect(t), server.connect(t), server.connect(t)
+			black.claimSeat(3, 3, "black")
+			white.claimSeat(3, 3, "white")
+			resigning, other := black, white
+			if tt.color == "white" {
+				resigning, other = white, black
+			}
+
+			// A player may resign out of turn
+			if result := black.move(3, 3, 60); !result.Success {
+				t.Fatalf("move: %+v", result.Error)
+			}
+			if result := watcher.act(types.MsgResign, 3, 3); result.Success || result.Error.Code != errNotSeated.Code {
+				t.Errorf("resign without a seat: %+v, want %s", result.Error, errNotSeated.Code)
+			}
+			if result := resigning.act(types.MsgResign, 3, 3); !result.Success {
+				t.Fatalf("resign: %+v", result.Error)
+			}
+
+			phase := other.awaitType(types.MsgGamePhase, nil).Data.(*types.GamePhaseData)
+			if phase.Phase != types.PhaseFinished || phase.Result != tt.result {
+				t.Errorf("phase %d with result %q, want finished with %s", phase.Phase, phase.Result, tt.result)
+			}
+			if result := other.move(3, 3, 61); result.Success || result.Error.Code != errNotPlaying.Code {
+				t.Errorf("move after the resignation: %+v, want %s", result.Error, errNotPlaying.Code)
+			}
+			if result := other.act(types.MsgResign, 3, 3); result.Success || result.Error.Code != errNotPlaying.Code {
+				t.Errorf("second resignation: %+v, want %s", result.Error, errNotPlaying.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,618 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/one-million-go/backend/internal/cluster"
+	"github.com/one-million-go/backend/pkg/rules"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+const (
+	// How long a node waits for the new owner of a zone it handed over to
+	// confirm taking it before sending the zone again
+	handoffTimeout = 10 * time.Second
+
+	// Most boards sent in one handoff frame
+	handoffBatchBoards = 256
+
+	// How long a zone moving to this node waits for its handoff before it
+	// is served from the store instead. Moves played on the old owner
+	// since its last save are lost if the handoff arrives later.
+	heldZoneTimeout = 6 * handoffTimeout
+
+	// Most requests held for one zone waiting for its handoff; more are
+	// refused
+	maxHeldWork = 1024
+)
+
+// A handoff is named by its zone and the epoch of the placement that
+// moved the zone. Every node applies the placements in epoch order, so
+// the old and new owner agree on the name of each handoff.
+
+// heldZone is work waiting for the boards of a zone moving to this node.
+// The zone stays frozen until the handoff of epoch arrives, or until
+// heldZoneTimeout has passed since.
+type heldZone struct {
+	epoch uint64 // Placement that moved the zone here
+	since time.Time
+	work  []func()
+}
+
+// zoneKey names a handoff
+type zoneKey struct {
+	Zone  types.ZoneID `json:"zone"`
+	Epoch uint64       `json:"epoch"`
+}
+
+// releasedZone is a zone this node handed over, kept and sent again
+// until its new owner confirms it took it. A zone released before its
+// own handoff reached this node is a relay: it has no handoff until the
+// one of epoch awaits arrives, which is then passed on.
+type releasedZone struct {
+	to      cluster.NodeID
+	handoff *zoneHandoff
+	awaits  uint64
+	sent    time.Time
+}
+
+// zoneHandoff carries a zone from its old owner to the new one
+type zoneHandoff struct {
+	Zone        types.ZoneID    `json:"zone"`
+	Epoch       uint64          `json:"epoch"`
+	Origin      cluster.NodeID  `json:"origin"` // Node handing the zone over
+	Boards      []handoffBoard  `json:"boards,omitempty"`
+	Subscribers []string        `json:"subscribers,omitempty"`
+	Clients     []handoffClient `json:"clients,omitempty"` // Where the subscribers and seated players are connected
+}
+
+func (hz *zoneHandoff) key() zoneKey {
+	return zoneKey{Zone: hz.Zone, Epoch: hz.Epoch}
+}
+
+// handoffBoard is a board in transit, with the state clients never see
+type handoffBoard struct {
+	Coord   types.BoardCoordinate `json:"coord"`
+	State   *types.BoardState     `json:"state"`
+	History []types.PositionHash  `json:"history"`
+	Deltas  []*types.BoardDelta   `json:"deltas,omitempty"`
+}
+
+// handoffClient tells the new owner of a zone where a client is connected
+type handoffClient struct {
+	ID       string         `json:"id"`
+	Node     cluster.NodeID `json:"node"`
+	Features []string       `json:"features,omitempty"`
+}
+
+// Rebalance moves the grid onto the given nodes, placed the way the
+// startup placement places zones. Every node hears of the change, and
+// each hands the zones it loses, with their boards, move history,
+// subscribers and seats, to their new owners. Games go on meanwhile:
+// requests for a moving zone wait on its new owner until the zone
+// arrives, however long that takes.
+//
+// The new nodes must already be connected to the cluster's bus.
+func (h *GameHub) Rebalance(nodes []cluster.NodeID) error {
+	if h.node == nil {
+		return errors.New("not running in a cluster")
+	}
+	if len(nodes) == 0 {
+		return errors.New("a cluster needs at least one node")
+	}
+	seen := make(map[cluster.NodeID]bool, len(nodes))
+	for _, id := range nodes {
+		if seen[id] {
+			return fmt.Errorf("node %q listed twice", id)
+		}
+		seen[id] = true
+	}
+
+	h.node.rebalanceMux.Lock()
+	defer h.node.rebalanceMux.Unlock()
+	h.applyPlacement(h.node.view.Load().epoch+1, nodes)
+	return nil
+}
+
+// applyPlacements catches up with the placements another node announced,
+// the node lists of epochs 1 on. Every node ends up with the same ones:
+// the longer list wins, and lists of the same length, from rebalances
+// started on two nodes at once, are decided at the first epoch they
+// differ in.
+func (h *GameHub) applyPlacements(placements [][]cluster.NodeID) {
+	n := h.node
+	n.rebalanceMux.Lock()
+	defer n.rebalanceMux.Unlock()
+
+	current := n.view.Load().history
+	first := 0
+	for first < len(current) && first < len(placements) && nodeList(current[first]) == nodeList(placements[first]) {
+		first++
+	}
+	newer := len(placements) > len(current) ||
+		len(placements) == len(current) && first < len(current) && nodeList(placements[first]) > nodeList(current[first])
+	if !newer {
+		return
+	}
+	for i := first; i < len(placements); i++ {
+		h.applyPlacement(uint64(i+1), placements[i])
+	}
+}
+
+func nodeList(nodes []cluster.NodeID) string {
+	ids := make([]string, len(nodes))
+	for i, id := range nodes {
+		ids[i] = string(id)
+	}
+	return strings.Join(ids, ",")
+}
+
+// applyPlacement switches to the placement of epoch, replacing those
+// from epoch on, then hands over the zones this node lost. The caller
+// holds rebalanceMux.
+func (h *GameHub) applyPlacement(epoch uint64, nodes []cluster.NodeID) {
+	n := h.node
+	old := n.view.Load()
+	history := append(append([][]cluster.NodeID(nil), old.history[:epoch-1]...), nodes)
+	next := &placementView{placement: old.placement.WithNodes(nodes), epoch: epoch, history: history}
+	self := n.bus.Self()
+
+	// Every node hears of the change before any zone or request this node
+	// passes on under it. Links keep their order, so receivers never send
+	// them back under the old placement.
+	announce := &frame{Kind: framePlacement, Placements: history}
+	for _, id := range unionNodes(old.placement.Nodes(), nodes) {
+		if id != self {
+			h.sendFrame(id, announce)
+		}
+	}
+
+	outgoing := make(map[*shard][]types.ZoneID)
+	incoming := make(map[*shard][]types.ZoneID)
+	lost, gained := 0, 0
+	for zoneID := types.ZoneID(0); zoneID < cluster.ZoneCount; zoneID++ {
+		was := old.placement.Owner(zoneID) == self
+		is := next.placement.Owner(zoneID) == self
+		s := h.shardForZone(zoneID)
+		switch {
+		case was && !is:
+			outgoing[s] = append(outgoing[s], zoneID)
+			lost++
+		case is && !was:
+			incoming[s] = append(incoming[s], zoneID)
+			gained++
+		}
+	}
+
+	// Zones coming here hold their work from the moment they are ours
+	now := time.Now()
+	for s, zones := range incoming {
+		s, zones := s, zones
+		s.call(func() {
+			for _, zoneID := range zones {
+				s.incoming[zoneID] = &heldZone{epoch: epoch, since: now}
+			}
+		})
+	}
+	n.view.Store(next)
+	h.savePlacement(next)
+	log.Printf("⚖️ Placement %d over %d nodes: handing over %d zones, taking over %d", epoch, len(nodes), lost, gained)
+
+	if lost == 0 {
+		return
+	}
+	stored := h.storedBoards(outgoing)
+	byOwner := make(map[cluster.NodeID][]*zoneHandoff)
+	for s, zones := range outgoing {
+		s, zones := s, zones
+		var handoffs []*zoneHandoff
+		var relays map[types.ZoneID]uint64
+		s.call(func() { handoffs, relays = s.releaseZones(zones, stored) })
+
+		n.releasedMux.Lock()
+		for _, hz := range handoffs {
+			hz.Origin, hz.Epoch = self, epoch
+			to := next.placement.Owner(hz.Zone)
+			n.released[hz.key()] = &releasedZone{to: to, handoff: hz, sent: now}
+			byOwner[to] = append(byOwner[to], hz)
+		}
+		for zoneID, awaits := range relays {
+			n.released[zoneKey{Zone: zoneID, Epoch: epoch}] = &releasedZone{to: next.placement.Owner(zoneID), awaits: awaits}
+		}
+		n.releasedMux.Unlock()
+	}
+	h.sendHandoffs(byOwner)
+}
+
+// unionNodes returns the nodes of both lists
+func unionNodes(a, b []cluster.NodeID) []cluster.NodeID {
+	seen := make(map[cluster.NodeID]bool, len(a)+len(b))
+	union := make([]cluster.NodeID, 0, len(a)+len(b))
+	for _, id := range append(append([]cluster.NodeID(nil), a...), b...) {
+		if !seen[id] {
+			seen[id] = true
+			union = append(union, id)
+		}
+	}
+	return union
+}
+
+// storedBoards loads the stored boards of outgoing zones, as evicted
+// boards travel with their zone too. Only the boards of those zones are
+// read from the store.
+func (h *GameHub) storedBoards(outgoing map[*shard][]types.ZoneID) map[types.ZoneID]map[types.BoardCoordinate]*types.BoardState {
+	if h.config.Store == nil {
+		return nil
+	}
+	moving := make(map[types.ZoneID]bool)
+	for _, zones := range outgoing {
+		for _, zoneID := range zones {
+			moving[zoneID] = true
+		}
+	}
+
+	coords, err := h.config.Store.Coords()
+	if err != nil {
+		log.Printf("⚠️ Failed to list stored boards for handoff, handing over boards in memory only: %v", err)
+		return nil
+	}
+	stored := make(map[types.ZoneID]map[types.BoardCoordinate]*types.BoardState)
+	for _, coord := range coords {
+		zoneID := types.ZoneFor(coord)
+		if !moving[zoneID] {
+			continue
+		}
+		state, err := h.config.Store.Load(coord)
+		if err != nil || state == nil {
+			log.Printf("⚠️ Failed to load stored board (%s) for handoff: %v", coord, err)
+			continue
+		}
+		if stored[zoneID] == nil {
+			stored[zoneID] = make(map[types.BoardCoordinate]*types.BoardState)
+		}
+		stored[zoneID][coord] = state
+	}
+	return stored
+}
+
+// releaseZones removes zones handed to other nodes from the shard and
+// returns them for their new owners. stored holds the zones' boards in
+// storage, sent for those not in memory. Zones still waiting for their
+// own handoff have nothing to send yet and are returned as relays, with
+// the epoch of the handoff they wait for. Work held for the zones is run
+// again, which forwards it.
+func (s *shard) releaseZones(zones []types.ZoneID, stored map[types.ZoneID]map[types.BoardCoordinate]*types.BoardState) ([]*zoneHandoff, map[types.ZoneID]uint64) {
+	byZone := make(map[types.ZoneID]*zoneHandoff, len(zones))
+	clientIDs := make(map[types.ZoneID]map[string]bool, len(zones))
+	handoffs := make([]*zoneHandoff, 0, len(zones))
+	relays := make(map[types.ZoneID]uint64)
+	var held []func()
+	for _, zoneID := range zones {
+		if waiting, ok := s.incoming[zoneID]; ok {
+			// Its boards and subscribers are still on their way
+			relays[zoneID] = waiting.epoch
+			held = append(held, waiting.work...)
+			delete(s.incoming, zoneID)
+			continue
+		}
+
+		hz := &zoneHandoff{Zone: zoneID}
+		byZone[zoneID] = hz
+		clientIDs[zoneID] = make(map[string]bool)
+		handoffs = append(handoffs, hz)
+
+		for coord, state := range stored[zoneID] {
+			if _, inMemory := s.boardStates[coord]; !inMemory {
+				hz.Boards = append(hz.Boards, newHandoffBoard(coord, restoreBoard(state)))
+			}
+		}
+	}
+
+	released := 0
+	for coord, state := range s.boardStates {
+		zoneID := types.ZoneFor(coord)
+		hz := byZone[zoneID]
+		if hz == nil {
+			continue
+		}
+		hz.Boards = append(hz.Boards, newHandoffBoard(coord, state))
+		for _, id := range []string{state.BlackPlayer, state.WhitePlayer} {
+			if id != "" {
+				s.leaveSeat(id, coord)
+				clientIDs[zoneID][id] = true
+			}
+		}
+		delete(s.boardStates, coord)
+		released++
+	}
+	s.hub.stats.activeBoards.Add(int64(-released))
+
+	for _, hz := range handoffs {
+		for id := range s.zoneSubscriptions[hz.Zone] {
+			hz.Subscribers = append(hz.Subscribers, id)
+			clientIDs[hz.Zone][id] = true
+		}
+		delete(s.zoneSubscriptions, hz.Zone)
+		hz.Clients = s.hub.handoffClients(clientIDs[hz.Zone])
+	}
+	for _, fn := range held {
+		fn()
+	}
+	return handoffs, relays
+}
+
+func newHandoffBoard(coord types.BoardCoordinate, state *types.BoardState) handoffBoard {
+	return handoffBoard{Coord: coord, State: state, History: state.History, Deltas: state.RecentDeltas}
+}
+
+// handoffClients tells where clients are connected
+func (h *GameHub) handoffClients(clientIDs map[string]bool) []handoffClient {
+	clients := make([]handoffClient, 0, len(clientIDs))
+	for id := range clientIDs {
+		if r := h.remoteClientOf(id); r != nil {
+			clients = append(clients, handoffClient{ID: id, Node: r.node, Features: r.features})
+		} else if client := h.localClient(id); client != nil {
+			clients = append(clients, handoffClient{ID: id, Node: h.node.bus.Self(), Features: negotiatedFeatures(client)})
+		}
+	}
+	return clients
+}
+
+// sendHandoffs sends zones to their new owners, batched into frames of
+// up to handoffBatchBoards boards. Each owner's zones are sent from a
+// goroutine of their own, as a full bus is waited on for up to
+// handoffTimeout and the caller may be receiving frames. A handoff lost
+// anyway is sent again until its new owner confirms it.
+func (h *GameHub) sendHandoffs(byOwner map[cluster.NodeID][]*zoneHandoff) {
+	for owner, handoffs := range byOwner {
+		owner, handoffs := owner, handoffs
+		go func() {
+			var batch []*zoneHandoff
+			boards := 0
+			for _, hz := range handoffs {
+				if len(batch) > 0 && boards+len(hz.Boards) > handoffBatchBoards {
+					h.sendHandoff(owner, batch)
+					batch, boards = nil, 0
+				}
+				batch = append(batch, hz)
+				boards += len(hz.Boards)
+			}
+			h.sendHandoff(owner, batch)
+		}()
+	}
+}
+
+func (h *GameHub) sendHandoff(owner cluster.NodeID, zones []*zoneHandoff) {
+	boards := 0
+	for _, hz := range zones {
+		boards += len(hz.Boards)
+	}
+	data, err := json.Marshal(&frame{Kind: frameHandoff, Handoffs: zones})
+	if err != nil {
+		log.Printf("⚠️ Failed to encode handoff of %d zones for node %s: %v", len(zones), owner, err)
+		return
+	}
+
+	deadline := time.Now().Add(handoffTimeout)
+	for {
+		err = h.node.bus.Send(owner, data)
+		if !errors.Is(err, cluster.ErrBusFull) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		log.Printf("⚠️ Lost handoff of %d zones with %d boards to node %s: %v", len(zones), boards, owner, err)
+	}
+}
+
+// receiveHandoff installs zones handed to this node
+func (h *GameHub) receiveHandoff(zones []*zoneHandoff) {
+	for _, hz := range zones {
+		hz := hz
+		for _, c := range hz.Clients {
+			h.rememberRemote(c.Node, c.ID, c.Features)
+		}
+		s := h.shardForZone(hz.Zone)
+		s.do(func() { s.takeZone(hz) })
+	}
+}
+
+// takeZone installs a zone's boards, seats and subscribers if the zone
+// waits for this handoff, then runs the work that waited for them. The
+// zone stays frozen until that handoff arrives, so work never runs
+// without its boards.
+func (s *shard) takeZone(hz *zoneHandoff) {
+	held, waiting := s.incoming[hz.Zone]
+	if !waiting || held.epoch != hz.Epoch {
+		s.hub.strayHandoff(hz)
+		return
+	}
+
+	for _, b := range hz.Boards {
+		state := b.State
+		state.History, state.RecentDeltas = b.History, b.Deltas
+		if len(state.History) == 0 {
+			state.History = rules.InitialHistory()
+		}
+
+		if current, exists := s.boardStates[b.Coord]; exists {
+			for _, id := range []string{current.BlackPlayer, current.WhitePlayer} {
+				if id != "" {
+					s.leaveSeat(id, b.Coord)
+				}
+			}
+		} else {
+			s.hub.stats.activeBoards.Add(1)
+		}
+
+		s.boardStates[b.Coord] = state
+		if state.BlackPlayer != "" {
+			s.takeSeat(state.BlackPlayer, b.Coord, 0)
+		}
+		if state.WhitePlayer != "" {
+			s.takeSeat(state.WhitePlayer, b.Coord, 1)
+		}
+		s.saveBoard(b.Coord, state)
+	}
+	for _, id := range hz.Subscribers {
+		s.addSubscriber(hz.Zone, id)
+	}
+
+	delete(s.incoming, hz.Zone)
+	s.hub.ackHandoff(hz)
+	if len(hz.Boards) > 0 || len(hz.Subscribers) > 0 {
+		log.Printf("📦 Took over zone %d from node %s with %d boards and %d subscribers", hz.Zone, hz.Origin, len(hz.Boards), len(hz.Subscribers))
+	}
+	for _, fn := range held.work {
+		fn()
+	}
+}
+
+// strayHandoff handles a handoff the zone does not wait for: one a relay
+// waits for is passed on, one of a placement this node has not seen yet
+// is dropped to be sent again later, and any other is a copy of one
+// already taken
+func (h *GameHub) strayHandoff(hz *zoneHandoff) {
+	if h.relayHandoff(hz) {
+		return
+	}
+	if hz.Epoch > h.node.view.Load().epoch {
+		log.Printf("🗑️ Dropped handoff of zone %d from node %s for placement %d, which this node has not seen yet", hz.Zone, hz.Origin, hz.Epoch)
+		return
+	}
+	h.ackHandoff(hz)
+}
+
+// relayHandoff passes a zone released before it reached this node on to
+// the node it was released to, reporting whether a relay waited for it
+func (h *GameHub) relayHandoff(hz *zoneHandoff) bool {
+	n := h.node
+	var relay *releasedZone
+	n.releasedMux.Lock()
+	for key, r := range n.released {
+		if key.Zone == hz.Zone && r.handoff == nil && r.awaits == hz.Epoch {
+			relayed := *hz
+			relayed.Origin, relayed.Epoch = n.bus.Self(), key.Epoch
+			r.handoff, r.sent, relay = &relayed, time.Now(), r
+			break
+		}
+	}
+	n.releasedMux.Unlock()
+	if relay == nil {
+		return false
+	}
+
+	h.ackHandoff(hz)
+	h.sendHandoffs(map[cluster.NodeID][]*zoneHandoff{relay.to: {relay.handoff}})
+	log.Printf("↪️ Relayed zone %d from node %s to node %s", hz.Zone, hz.Origin, relay.to)
+	return true
+}
+
+// ackHandoff tells the origin of a handoff that the zone arrived, so it
+// stops sending the zone
+func (h *GameHub) ackHandoff(hz *zoneHandoff) {
+	h.sendFrame(hz.Origin, &frame{Kind: frameHandoffAck, Released: []zoneKey{hz.key()}})
+}
+
+// forgetReleased drops released zones their new owner took
+func (h *GameHub) forgetReleased(keys []zoneKey) {
+	h.node.releasedMux.Lock()
+	defer h.node.releasedMux.Unlock()
+	for _, key := range keys {
+		delete(h.node.released, key)
+	}
+}
+
+// resendHandoffs sends released zones again whose new owner did not
+// confirm them within handoffTimeout, in case their handoff was lost or
+// dropped by a node behind on placements
+func (h *GameHub) resendHandoffs() {
+	n := h.node
+	byOwner := make(map[cluster.NodeID][]*zoneHandoff)
+	count := 0
+	n.releasedMux.Lock()
+	for _, r := range n.released {
+		if r.handoff != nil && time.Since(r.sent) >= handoffTimeout {
+			r.sent = time.Now()
+			byOwner[r.to] = append(byOwner[r.to], r.handoff)
+			count++
+		}
+	}
+	n.releasedMux.Unlock()
+	if count == 0 {
+		return
+	}
+	log.Printf("⏳ Sending %d handoffs again, unconfirmed after %s", count, handoffTimeout)
+	h.sendHandoffs(byOwner)
+}
+
+// expireHeldZones stops waiting for handoffs overdue by heldZoneTimeout.
+// The boards of those zones are read from the store from then on, and
+// the work held for them runs.
+func (h *GameHub) expireHeldZones() {
+	expired := make(map[*shard][]types.ZoneID)
+	count := 0
+	for _, s := range h.shards {
+		s := s
+		s.call(func() {
+			for zoneID, held := range s.incoming {
+				if time.Since(held.since) >= heldZoneTimeout {
+					expired[s] = append(expired[s], zoneID)
+					count++
+				}
+			}
+		})
+	}
+	if count == 0 {
+		return
+	}
+
+	var coords []types.BoardCoordinate
+	if h.config.Store != nil {
+		var err error
+		if coords, err = h.config.Store.Coords(); err != nil {
+			log.Printf("⚠️ Failed to list stored boards for overdue zones: %v", err)
+		}
+	}
+	log.Printf("⏳ Serving %d zones from storage, their handoffs overdue after %s", count, heldZoneTimeout)
+	for s, zones := range expired {
+		s, zones := s, zones
+		s.call(func() { s.releaseHeldZones(zones, coords) })
+	}
+}
+
+// releaseHeldZones stops zones waiting for their handoff, marking their
+// boards among coords as stored, then runs the work held for them
+func (s *shard) releaseHeldZones(zones []types.ZoneID, coords []types.BoardCoordinate) {
+	released := make(map[types.ZoneID]bool, len(zones))
+	var held []func()
+	for _, zoneID := range zones {
+		// The handoff may have arrived meanwhile
+		if waiting, ok := s.incoming[zoneID]; ok && time.Since(waiting.since) >= heldZoneTimeout {
+			released[zoneID] = true
+			held = append(held, waiting.work...)
+			delete(s.incoming, zoneID)
+		}
+	}
+	for _, coord := range coords {
+		if released[types.ZoneFor(coord)] {
+			s.stored[coord] = true
+		}
+	}
+	for _, fn := range held {
+		fn()
+	}
+}
+
+// settled reports whether a zone of this node has all its boards, i.e.
+// is not on its way here
+func (s *shard) settled(zoneID types.ZoneID) bool {
+	_, waiting := s.incoming[zoneID]
+	return !waiting
+}
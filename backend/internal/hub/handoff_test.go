@@ -0,0 +1,320 @@
+package hub
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/one-million-go/backend/internal/cluster"
+	"github.com/one-million-go/backend/internal/storage"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// holds reports whether a hub has a board in memory
+func holds(h *GameHub, coord types.BoardCoordinate) bool {
+	s := h.shardForZone(types.ZoneFor(coord))
+	var found bool
+	s.call(func() { _, found = s.boardStates[coord] })
+	return found
+}
+
+// awaitDelta waits for the delta of a board's move at version
+func awaitDelta(c *testClient, coord types.BoardCoordinate, version uint32) *types.BoardDelta {
+	x, y := coord.Unpack()
+	return c.awaitType(types.MsgBoardDelta, func(msg *types.Message) bool {
+		delta := msg.Data.(*types.BoardDelta)
+		return delta.BoardX == x && delta.BoardY == y && delta.Version == version
+	}).Data.(*types.BoardDelta)
+}
+
+func TestRebalanceMovesGames(t *testing.T) {
+	servers := startCluster(t, cluster.NewRanges([]cluster.NodeID{"a", "b"}), nil, "a", "b", "c")
+
+	// Under ranges over a and b, board (0,400) is on a and (0,900) on b.
+	// Over a, b and c they move to b and c.
+	boards := []struct {
+		coord     types.BoardCoordinate
+		from, to  cluster.NodeID
+		positions []uint16
+	}{
+		{types.NewBoardCoordinate(0, 400), "a", "b", []uint16{60, 61, 62}},
+		{types.NewBoardCoordinate(0, 900), "b", "c", []uint16{100, 101, 102}},
+	}
+	for _, b := range boards {
+		if !servers[b.from].hub.owns(b.coord) {
+			t.Fatalf("board (%s) is not on node %s before the rebalance", b.coord, b.from)
+		}
+	}
+
+	// A subscription replaces the previous one, so each board has its
+	// own watcher
+	black := servers["a"].connect(t)
+	white := servers["b"].connect(t)
+	watchers := make([]*testClient, len(boards))
+	for i, b := range boards {
+		x, y := b.coord.Unpack()
+		watcher := servers["c"].connect(t)
+		watchers[i] = watcher
+		black.claimSeat(x, y, "black")
+		white.claimSeat(x, y, "white")
+		if result := black.move(x, y, b.positions[0]); !result.Success {
+			t.Fatalf("move on (%s): %+v", b.coord, result.Error)
+		}
+		if result := white.move(x, y, b.positions[1]); !result.Success {
+			t.Fatalf("move on (%s): %+v", b.coord, result.Error)
+		}
+		watcher.subscribe(x, y)
+		if board := watcher.fetchBoard(x, y); board.MoveCount != 2 {
+			t.Fatalf("board (%s) has %d moves, want 2", b.coord, board.MoveCount)
+		}
+	}
+
+	if err := servers["a"].hub.Rebalance([]cluster.NodeID{"a", "b", "c"}); err != nil {
+		t.Fatalf("Rebalance: %v", err)
+	}
+
+	// Moves sent right away wait for the zone on its new owner, then go
+	// on from where the old owner left the game
+	for i, b := range boards {
+		x, y := b.coord.Unpack()
+		result := black.move(x, y, b.positions[2])
+		if !result.Success {
+			t.Fatalf("move on (%s) after the rebalance: %+v", b.coord, result.Error)
+		}
+		if result.Delta == nil || result.Delta.Version != 3 || result.Delta.MoveCount != 3 {
+			t.Errorf("move on (%s) after the rebalance: delta %+v, want move 3 at version 3", b.coord, result.Delta)
+		}
+		awaitDelta(watchers[i], b.coord, 3)
+		awaitDelta(white, b.coord, 3)
+
+		if !servers[b.to].hub.owns(b.coord) || !holds(servers[b.to].hub, b.coord) {
+			t.Errorf("board (%s) is not on node %s after the rebalance", b.coord, b.to)
+		}
+		if holds(servers[b.from].hub, b.coord) {
+			t.Errorf("board (%s) is still on node %s after the rebalance", b.coord, b.from)
+		}
+	}
+
+	// Every node confirmed the zones it took
+	deadline := time.Now().Add(awaitTimeout)
+	for _, id := range []cluster.NodeID{"a", "b"} {
+		for released(servers[id].hub) > 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("node %s still waits for %d zones to be confirmed", id, released(servers[id].hub))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func released(h *GameHub) int {
+	h.node.releasedMux.Lock()
+	defer h.node.releasedMux.Unlock()
+	return len(h.node.released)
+}
+
+// lossyBus loses the first handoff it is asked to send
+type lossyBus struct {
+	cluster.Bus
+	lost atomic.Bool
+}
+
+func (b *lossyBus) Send(to cluster.NodeID, data []byte) error {
+	if bytes.Contains(data, []byte(`"kind":"handoff"`)) && b.lost.CompareAndSwap(false, true) {
+		return nil
+	}
+	return b.Bus.Send(to, data)
+}
+
+func TestLostHandoffIsSentAgain(t *testing.T) {
+	lossy := func(bus cluster.Bus) cluster.Bus { return &lossyBus{Bus: bus} }
+	servers := startCluster(t, cluster.NewRanges([]cluster.NodeID{"a", "b"}), lossy, "a", "b")
+
+	black := servers["a"].connect(t)
+	white := servers["b"].connect(t)
+	black.claimSeat(0, 400, "black")
+	white.claimSeat(0, 400, "white")
+	if result := black.move(0, 400, 60); !result.Success {
+		t.Fatalf("move: %+v", result.Error)
+	}
+
+	// Node a hands all its zones to b, which never receives them
+	if err := servers["a"].hub.Rebalance([]cluster.NodeID{"b"}); err != nil {
+		t.Fatalf("Rebalance: %v", err)
+	}
+	id := white.send(types.MsgSendMove, &types.MoveRequestData{BoardX: 0, BoardY: 400, Position: 61})
+	time.Sleep(50 * time.Millisecond)
+	white.mu.Lock()
+	for _, msg := range white.received {
+		if msg.ID == id {
+			t.Error("move answered before its zone arrived")
+		}
+	}
+	white.mu.Unlock()
+
+	// Once overdue, the zones are sent again and the move is played on
+	// the game handed over
+	a := servers["a"].hub
+	a.node.releasedMux.Lock()
+	for _, r := range a.node.released {
+		r.sent = time.Time{}
+	}
+	a.node.releasedMux.Unlock()
+	a.resendHandoffs()
+
+	result := white.await("move result", func(msg *types.Message) bool { return msg.ID == id }).Data.(*types.MoveResultData)
+	if !result.Success || result.Delta == nil || result.Delta.MoveCount != 2 {
+		t.Fatalf("move after the handoff was sent again: %+v", result)
+	}
+}
+
+// handoffLossBus loses every handoff it is asked to send
+type handoffLossBus struct {
+	cluster.Bus
+}
+
+func (b handoffLossBus) Send(to cluster.NodeID, data []byte) error {
+	if bytes.Contains(data, []byte(`"kind":"handoff"`)) {
+		return nil
+	}
+	return b.Bus.Send(to, data)
+}
+
+func TestOverdueZoneIsServedFromStore(t *testing.T) {
+	// Both nodes save to the same directory, and no handoff ever arrives
+	store, err := storage.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	network := cluster.NewNetwork()
+	servers := make(map[cluster.NodeID]*testServer)
+	for _, id := range []cluster.NodeID{"a", "b"} {
+		config := testConfig(2)
+		config.Bus = handoffLossBus{Bus: network.Join(id)}
+		config.Placement = cluster.NewRanges([]cluster.NodeID{"a", "b"})
+		config.Store = store
+		servers[id] = startHub(t, config)
+		t.Cleanup(func() { config.Bus.Close() })
+	}
+
+	black := servers["a"].connect(t)
+	white := servers["b"].connect(t)
+	black.claimSeat(0, 400, "black")
+	white.claimSeat(0, 400, "white")
+	if result := black.move(0, 400, 60); !result.Success {
+		t.Fatalf("move: %+v", result.Error)
+	}
+	if err := servers["a"].hub.Rebalance([]cluster.NodeID{"b"}); err != nil {
+		t.Fatalf("Rebalance: %v", err)
+	}
+
+	// Requests past maxHeldWork are refused while the zone is frozen
+	b := servers["b"].hub
+	s := b.shardFor(types.NewBoardCoordinate(0, 400))
+	zoneID := types.ZoneFor(types.NewBoardCoordinate(0, 400))
+	deadline := time.Now().Add(awaitTimeout)
+	for {
+		var frozen bool
+		s.call(func() { frozen = !s.settled(zoneID) })
+		if frozen {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("zone never frozen on its new owner")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	var filler int
+	s.call(func() {
+		held := s.incoming[zoneID]
+		for len(held.work) < maxHeldWork {
+			held.work = append(held.work, func() { filler++ })
+		}
+	})
+	reader := servers["b"].connect(t)
+	reader.send(types.MsgFetchBoard, &types.FetchBoardData{BoardX: 0, BoardY: 400})
+	reader.await("refusal", func(msg *types.Message) bool {
+		errData, ok := msg.Data.(*types.ErrorData)
+		return ok && errData.Code == "ZONE_BUSY"
+	})
+	s.call(func() { s.incoming[zoneID].work = s.incoming[zoneID].work[:0] })
+
+	// A fetch waits for the zone until the handoff is overdue, then is
+	// answered with the board saved by the old owner
+	late := servers["b"].connect(t)
+	late.send(types.MsgFetchBoard, &types.FetchBoardData{BoardX: 0, BoardY: 400})
+	b.expireHeldZones()
+	var settled bool
+	s.call(func() { settled = s.settled(zoneID) })
+	if settled {
+		t.Fatal("zone released before its handoff was overdue")
+	}
+	s.call(func() {
+		for _, held := range s.incoming {
+			held.since = held.since.Add(-heldZoneTimeout)
+		}
+	})
+	b.expireHeldZones()
+
+	board := late.awaitType(types.MsgBoardState, nil).Data.(*types.BoardState)
+	if board.MoveCount != 1 {
+		t.Errorf("board of the overdue zone has %d moves, want the 1 saved", board.MoveCount)
+	}
+	if filler != 0 {
+		t.Errorf("%d requests ran that were cleared", filler)
+	}
+}
+
+func TestPlacementHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "placement.json")
+	startup := cluster.NewRanges([]cluster.NodeID{"a", "b"})
+	newHub := func() *GameHub {
+		config := testConfig(2)
+		config.Bus = cluster.NewNetwork().Join("a")
+		config.Placement = startup
+		config.PlacementFile = file
+		h, err := NewGameHub(config)
+		if err != nil {
+			t.Fatalf("NewGameHub: %v", err)
+		}
+		go h.Run()
+		t.Cleanup(func() { config.Bus.Close() })
+		return h
+	}
+	history := func(h *GameHub) [][]cluster.NodeID { return h.node.view.Load().history }
+
+	h := newHub()
+	want := [][]cluster.NodeID{{"a"}, {"a", "b"}}
+	h.applyPlacements(want)
+	if got := history(h); !reflect.DeepEqual(got, want) {
+		t.Fatalf("history %v, want %v", got, want)
+	}
+	if epoch, nodes := h.node.view.Load().epoch, h.node.placement().Nodes(); epoch != 2 || !reflect.DeepEqual(nodes, want[1]) {
+		t.Errorf("placement %d over %v, want 2 over %v", epoch, nodes, want[1])
+	}
+
+	// An older history is ignored, one of the same length that wins at
+	// the epoch it differs replaces the view from there on
+	h.applyPlacements(want[:1])
+	h.applyPlacements([][]cluster.NodeID{{"a"}, {"a"}})
+	if got := history(h); !reflect.DeepEqual(got, want) {
+		t.Errorf("history %v after older placements, want %v", got, want)
+	}
+	want = [][]cluster.NodeID{{"a"}, {"b", "a"}}
+	h.applyPlacements(want)
+	if got := history(h); !reflect.DeepEqual(got, want) {
+		t.Errorf("history %v after a winning placement, want %v", got, want)
+	}
+
+	// A restarted node picks up where it stopped
+	restarted := newHub()
+	if got := history(restarted); !reflect.DeepEqual(got, want) {
+		t.Errorf("history %v after a restart, want %v", got, want)
+	}
+	if epoch, nodes := restarted.node.view.Load().epoch, restarted.node.placement().Nodes(); epoch != 2 || !reflect.DeepEqual(nodes, want[1]) {
+		t.Errorf("placement %d over %v after a restart, want 2 over %v", epoch, nodes, want[1])
+	}
+}
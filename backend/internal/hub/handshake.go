@@ -0,0 +1,172 @@
+package hub
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/one-million-go/backend/pkg/protocol"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// handleHello answers a client's HELLO with the server's settings and
+// the features enabled for the connection. Clients whose protocol
+// version the server does not speak are disconnected. A valid resume
+// token restores the client's earlier session, and the messages queued
+// for it follow the WELCOME.
+func (h *GameHub) handleHello(inMsg *InboundMessage) {
+	req, ok := requestData[types.HelloData](h, inMsg)
+	if !ok {
+		return
+	}
+
+	client := h.getClient(inMsg.ClientID)
+	if client == nil {
+		return
+	}
+	if client.protocolVersion != 0 {
+		h.sendError(client.ID, "HANDSHAKE_DONE", "HELLO must be the first message")
+		return
+	}
+
+	if req.ProtocolVersion < protocol.MinVersion || req.ProtocolVersion > protocol.Version {
+		reason := fmt.Sprintf("Protocol version %d is not supported, the server speaks versions %d to %d",
+			req.ProtocolVersion, protocol.MinVersion, protocol.Version)
+		// Sent directly so it is queued before the close
+		client.SendMessage(&types.Message{
+			ID:        inMsg.Message.ID,
+			Type:      types.MsgError,
+			Timestamp: time.Now().Unix(),
+			Data:      &types.ErrorData{Code: "UNSUPPORTED_PROTOCOL", Message: reason},
+		})
+		client.Close(protocol.CloseUnsupportedVersion, "unsupported protocol version")
+		log.Printf("🚫 Refused client %s: protocol version %d", client.ID, req.ProtocolVersion)
+		return
+	}
+
+	client.protocolVersion = req.ProtocolVersion
+
+	var queued []queuedMessage
+	resumed := false
+	if req.ResumeToken != "" {
+		if clientID, nonce, ok := h.verifyResumeToken(req.ResumeToken); ok {
+			queued, resumed = h.resumeSession(client, clientID, nonce)
+		}
+		if !resumed {
+			log.Printf("⚠️ Client %s could not resume its session, starting a new one", client.ID)
+		}
+	}
+	client.resumable = h.config.ResumeGrace > 0
+
+	for _, feature := range req.Features {
+		switch feature {
+		case protocol.FeatureBinary:
+			client.setFeature(feature, client.codec.Binary())
+		case protocol.FeatureCompression:
+			client.setFeature(feature, client.compression)
+		case protocol.FeatureDeltas:
+			client.setFeature(feature, true)
+		}
+	}
+
+	welcome := h.welcomeData(client)
+	welcome.Resumed = resumed
+	h.sendOutboundMessage(&OutboundMessage{
+		Recipients: []string{client.ID},
+		Message: &types.Message{
+			ID:        inMsg.Message.ID,
+			Type:      types.MsgWelcome,
+			Timestamp: time.Now().Unix(),
+			Data:      welcome,
+		},
+	})
+	for _, m := range queued {
+		h.deliver(client.ID, m)
+	}
+	log.Printf("🤝 Handshake with %s: protocol %d, features %v", client.ID, client.protocolVersion, welcome.Features)
+}
+
+// ensureHandshake treats clients whose first message is not HELLO as
+// speaking the legacy protocol without optional features. They still
+// get a WELCOME with their client ID, as before the handshake existed,
+// ahead of the reply to that first message.
+func (h *GameHub) ensureHandshake(clientID string) {
+	client := h.getClient(clientID)
+	if client == nil || client.protocolVersion != 0 {
+		return
+	}
+	client.protocolVersion = protocol.LegacyVersion
+
+	h.sendOutboundMessage(&OutboundMessage{
+		Recipients: []string{client.ID},
+		Message: &types.Message{
+			ID:        uuid.New().String(),
+			Type:      types.MsgWelcome,
+			Timestamp: time.Now().Unix(),
+			Data:      h.welcomeData(client),
+		},
+	})
+	log.Printf("🤝 Client %s skipped HELLO, speaking protocol %d", client.ID, client.protocolVersion)
+}
+
+// hasFeature reports whether a client negotiated a feature. Clients
+// waiting to resume keep the features of their last connection, and
+// clients of other nodes those their node forwarded.
+func (h *GameHub) hasFeature(clientID, feature string) bool {
+	if client := h.getClient(clientID); client != nil {
+		return client.HasFeature(feature)
+	}
+
+	h.clientsMux.RLock()
+	s, exists := h.sessions[clientID]
+	h.clientsMux.RUnlock()
+	if exists {
+		return s.client.HasFeature(feature)
+	}
+	return h.remoteHasFeature(clientID, feature)
+}
+
+// negotiatedFeatures lists the optional features enabled for a client
+func negotiatedFeatures(client *ClientConnection) []string {
+	features := make([]string, 0)
+	for _, feature := range []string{protocol.FeatureBinary, protocol.FeatureCompression, protocol.FeatureDeltas} {
+		if client.HasFeature(feature) {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
+
+// welcomeData describes the server and the connection to a client
+func (h *GameHub) welcomeData(client *ClientConnection) *types.WelcomeData {
+	resumeToken := ""
+	if client.resumable {
+		resumeToken = h.resumeToken(client)
+	}
+
+	return &types.WelcomeData{
+		ClientID:        client.ID,
+		Message:         "Connected to One Million Go server",
+		ProtocolVersion: client.protocolVersion,
+		Features:        negotiatedFeatures(client),
+		ResumeToken:     resumeToken,
+		BoardSize:       types.BoardSize,
+		GridWidth:       types.GridSize,
+		GridHeight:      types.GridSize,
+		ZoneSize:        types.ZoneSize,
+		Ruleset: types.RulesetInfo{
+			KoRule:  string(h.config.KoRule),
+			Scoring: string(h.config.Scoring),
+			Komi:    h.config.Komi,
+		},
+		Limits: types.ServerLimits{
+			MaxMessageSize:     maxMessageSize,
+			MaxHistoryPage:     maxHistoryLimit,
+			MaxSubscribedZones: maxSubscribedZones,
+			MaxRegionSize:      types.MaxRegionSize,
+			RecentDeltas:       maxRecentDeltas,
+			ResumeGrace:        uint32(h.config.ResumeGrace / time.Second),
+		},
+	}
+}
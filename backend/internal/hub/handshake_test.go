@@ -0,0 +1,84 @@
+package hub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/one-million-go/backend/pkg/protocol"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// awaitClose waits for the server to close the connection and returns
+// the close error
+func (c *testClient) awaitClose() error {
+	deadline := time.After(awaitTimeout)
+	for {
+		c.mu.Lock()
+		closed, err := c.closed, c.closeErr
+		c.mu.Unlock()
+		if closed {
+			return err
+		}
+		select {
+		case <-c.arrived:
+		case <-deadline:
+			c.t.Fatalf("connection still open after %s", awaitTimeout)
+		}
+	}
+}
+
+func TestUnsupportedProtocolVersion(t *testing.T) {
+	server := startHub(t, testConfig(2))
+	for _, version := range []uint16{protocol.Version + 1, 100} {
+		c := server.open(t)
+		reply := c.request(types.MsgHello, &types.HelloData{ProtocolVersion: version})
+		if data, ok := reply.Data.(*types.ErrorData); !ok || data.Code != "UNSUPPORTED_PROTOCOL" {
+			t.Errorf("HELLO with version %d answered with %s %+v, want UNSUPPORTED_PROTOCOL", version, reply.Type, reply.Data)
+		}
+		if err := c.awaitClose(); !websocket.IsCloseError(err, protocol.CloseUnsupportedVersion) {
+			t.Errorf("version %d closed with %v, want close code %d", version, err, protocol.CloseUnsupportedVersion)
+		}
+	}
+}
+
+func TestSecondHello(t *testing.T) {
+	server := startHub(t, testConfig(2))
+	c := server.connect(t)
+
+	c.send(types.MsgHello, &types.HelloData{ProtocolVersion: protocol.Version})
+	if data := c.awaitType(types.MsgError, nil).Data.(*types.ErrorData); data.Code != "HANDSHAKE_DONE" {
+		t.Fatalf("second HELLO answered with %s, want HANDSHAKE_DONE", data.Code)
+	}
+	if board := c.fetchBoard(3, 3); board.MoveCount != 0 {
+		t.Errorf("new board has %d moves", board.MoveCount)
+	}
+}
+
+func TestLegacyClientWelcomed(t *testing.T) {
+	server := startHub(t, testConfig(2))
+	c := server.open(t)
+
+	// The WELCOME comes ahead of the reply to the first message
+	c.send(types.MsgFetchBoard, &types.FetchBoardData{BoardX: 3, BoardY: 3})
+	first := c.await("first message", func(*types.Message) bool { return true })
+	welcome, ok := first.Data.(*types.WelcomeData)
+	if !ok {
+		t.Fatalf("first message is %s, want WELCOME", first.Type)
+	}
+	if welcome.ClientID == "" || welcome.ProtocolVersion != protocol.LegacyVersion || len(welcome.Features) != 0 {
+		t.Errorf("WELCOME for client %q with protocol %d and features %v, want legacy without features",
+			welcome.ClientID, welcome.ProtocolVersion, welcome.Features)
+	}
+	c.awaitType(types.MsgBoardState, nil)
+
+	c.send(types.MsgPing, nil)
+	c.awaitType(types.MsgPong, nil)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, msg := range c.received {
+		if msg.Type == types.MsgWelcome {
+			t.Error("legacy client welcomed twice")
+		}
+	}
+}
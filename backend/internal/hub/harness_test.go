@@ -0,0 +1,253 @@
+package hub
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/one-million-go/backend/pkg/protocol"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// How long a test waits for a message before failing
+const awaitTimeout = 10 * time.Second
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// testConfig returns the settings tests start hubs with
+func testConfig(shards int) Config {
+	config := DefaultConfig()
+	config.Shards = shards
+	config.ResumeGrace = 0
+	return config
+}
+
+// testServer is a running hub behind a WebSocket endpoint
+type testServer struct {
+	hub *GameHub
+	url string
+}
+
+// startHub runs a hub with config and serves it over httptest
+func startHub(t *testing.T, config Config) *testServer {
+	t.Helper()
+	h, err := NewGameHub(config)
+	if err != nil {
+		t.Fatalf("NewGameHub: %v", err)
+	}
+	go h.Run()
+
+	upgrader := websocket.Upgrader{Subprotocols: protocol.Subprotocols}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := NewClientConnection(conn, h, false)
+		h.Register <- client
+		go client.WritePump()
+		go client.ReadPump()
+	}))
+	t.Cleanup(srv.Close)
+
+	return &testServer{hub: h, url: "ws" + strings.TrimPrefix(srv.URL, "http")}
+}
+
+// testClient is a WebSocket client that completed the handshake, speaking
+// the binary protocol. Every message it receives is kept until a test
+// awaits it.
+type testClient struct {
+	t    *testing.T
+	id   string
+	conn *websocket.Conn
+
+	writeMux sync.Mutex
+	mu       sync.Mutex
+	received []*types.Message
+	arrived  chan struct{}
+	closed   bool
+	closeErr error // Why the connection ended
+}
+
+// connect opens a connection to the server, sends HELLO with the deltas
+// feature and waits for the WELCOME
+func (ts *testServer) connect(t *testing.T) *testClient {
+	t.Helper()
+	c, _ := ts.dial(t, &types.HelloData{
+		ProtocolVersion: protocol.Version,
+		Features:        []string{protocol.FeatureDeltas},
+	})
+	return c
+}
+
+// dial opens a connection to the server and sends hello, returning the
+// WELCOME it is answered with
+func (ts *testServer) dial(t *testing.T, hello *types.HelloData) (*testClient, *types.WelcomeData) {
+	t.Helper()
+	c := ts.open(t)
+	welcome := c.request(types.MsgHello, hello).Data.(*types.WelcomeData)
+	c.id = welcome.ClientID
+	return c, welcome
+}
+
+// open opens a connection to the server without a handshake
+func (ts *testServer) open(t *testing.T) *testClient {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: []string{protocol.SubprotocolBinary}}
+	conn, _, err := dialer.Dial(ts.url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	c := &testClient{t: t, conn: conn, arrived: make(chan struct{}, 1)}
+	t.Cleanup(func() { conn.Close() })
+	go c.readLoop()
+	return c
+}
+
+func (c *testClient) readLoop() {
+	for {
+		_, data, err := c.conn.ReadMessage()
+		c.mu.Lock()
+		if err != nil {
+			c.closed, c.closeErr = true, err
+		} else if msg, decodeErr := (protocol.BinaryCodec{}).Decode(data); decodeErr == nil {
+			c.received = append(c.received, msg)
+		} else {
+			c.t.Errorf("undecodable message: %v", decodeErr)
+		}
+		c.mu.Unlock()
+
+		select {
+		case c.arrived <- struct{}{}:
+		default:
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// send writes a request and returns its ID
+func (c *testClient) send(msgType types.MessageType, data interface{}) string {
+	msg := &types.Message{ID: uuid.New().String(), Type: msgType, Timestamp: time.Now().Unix(), Data: data}
+	encoded, err := protocol.BinaryCodec{}.Encode(msg)
+	if err != nil {
+		c.t.Fatalf("encode %s: %v", msgType, err)
+	}
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, encoded); err != nil {
+		c.t.Errorf("send %s: %v", msgType, err)
+	}
+	return msg.ID
+}
+
+// await removes and returns the first message received that matches,
+// failing the test when none arrives in time
+func (c *testClient) await(what string, match func(*types.Message) bool) *types.Message {
+	deadline := time.After(awaitTimeout)
+	for {
+		c.mu.Lock()
+		for i, msg := range c.received {
+			if match(msg) {
+				c.received = append(c.received[:i:i], c.received[i+1:]...)
+				c.mu.Unlock()
+				return msg
+			}
+		}
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			c.t.Fatalf("connection closed while waiting for %s", what)
+		}
+
+		select {
+		case <-c.arrived:
+		case <-deadline:
+			c.t.Fatalf("no %s within %s", what, awaitTimeout)
+		}
+	}
+}
+
+// request sends a request and waits for the reply carrying its ID
+func (c *testClient) request(msgType types.MessageType, data interface{}) *types.Message {
+	id := c.send(msgType, data)
+	return c.await("reply to "+string(msgType), func(msg *types.Message) bool { return msg.ID == id })
+}
+
+// awaitType waits for a message of a type that matches
+func (c *testClient) awaitType(msgType types.MessageType, match func(*types.Message) bool) *types.Message {
+	return c.await(string(msgType), func(msg *types.Message) bool {
+		return msg.Type == msgType && (match == nil || match(msg))
+	})
+}
+
+// claimSeat takes a seat and fails the test unless it is granted
+func (c *testClient) claimSeat(x, y uint16, color string) {
+	id := c.send(types.MsgClaimSeat, &types.SeatData{BoardX: x, BoardY: y, Color: color})
+	reply := c.await("seat on "+types.NewBoardCoordinate(x, y).String(), func(msg *types.Message) bool {
+		return msg.ID == id || msg.Type == types.MsgError
+	})
+	if reply.Type != types.MsgBoardState {
+		c.t.Fatalf("claim %s seat on (%d,%d): %+v", color, x, y, reply.Data)
+	}
+}
+
+// move plays a move and returns its result
+func (c *testClient) move(x, y, pos uint16) *types.MoveResultData {
+	reply := c.request(types.MsgSendMove, &types.MoveRequestData{BoardX: x, BoardY: y, Position: pos})
+	return reply.Data.(*types.MoveResultData)
+}
+
+// fetchBoard returns a board's current state. The BOARD_STATE carries
+// neither the request's ID nor the board, so the client must not expect
+// another one meanwhile.
+func (c *testClient) fetchBoard(x, y uint16) *types.BoardState {
+	c.send(types.MsgFetchBoard, &types.FetchBoardData{BoardX: x, BoardY: y})
+	reply := c.await("BOARD_STATE", func(msg *types.Message) bool {
+		return msg.Type == types.MsgBoardState || msg.Type == types.MsgError
+	})
+	state, ok := reply.Data.(*types.BoardState)
+	if !ok {
+		c.t.Fatalf("FETCH_BOARD (%d,%d) answered with %s: %+v", x, y, reply.Type, reply.Data)
+	}
+	return state
+}
+
+// fetchRegion returns the boards of a region
+func (c *testClient) fetchRegion(startX, startY, width, height uint16) *types.RegionDataResponse {
+	c.send(types.MsgFetchRegion, &types.FetchRegionData{StartX: startX, StartY: startY, Width: width, Height: height})
+	reply := c.await("REGION_DATA", func(msg *types.Message) bool {
+		if region, ok := msg.Data.(*types.RegionDataResponse); ok {
+			return region.StartX == startX && region.StartY == startY
+		}
+		return msg.Type == types.MsgError
+	})
+	region, ok := reply.Data.(*types.RegionDataResponse)
+	if !ok {
+		c.t.Fatalf("FETCH_REGION answered with %s: %+v", reply.Type, reply.Data)
+	}
+	return region
+}
+
+// subscribe subscribes to the zones around a board
+func (c *testClient) subscribe(x, y uint16) []types.ZoneID {
+	reply := c.request(types.MsgSubscribeRegion, &types.SubscribeRegionData{CenterX: x, CenterY: y})
+	return reply.Data.(*types.SubscribedData).Zones
+}
@@ -0,0 +1,63 @@
+package hub
+
+import (
+	"time"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+const (
+	// Page size used when FETCH_HISTORY does not give a limit
+	defaultHistoryLimit = 50
+
+	// Largest page a client may request
+	maxHistoryLimit = 500
+)
+
+func (s *shard) handleFetchHistory(inMsg *InboundMessage) {
+	req, ok := requestData[types.FetchHistoryData](s.hub, inMsg)
+	if !ok {
+		return
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	coord := types.NewBoardCoordinate(req.BoardX, req.BoardY)
+	boardState := s.viewBoardState(coord)
+
+	start := req.Offset
+	if start > len(boardState.Moves) {
+		start = len(boardState.Moves)
+	}
+	end := start + limit
+	if end > len(boardState.Moves) {
+		end = len(boardState.Moves)
+	}
+
+	moves := make([]types.Move, end-start)
+	copy(moves, boardState.Moves[start:end])
+
+	response := &types.Message{
+		ID:        inMsg.Message.ID,
+		Type:      types.MsgHistory,
+		Timestamp: time.Now().Unix(),
+		Data: &types.HistoryData{
+			BoardX: req.BoardX,
+			BoardY: req.BoardY,
+			Total:  len(boardState.Moves),
+			Offset: start,
+			Moves:  moves,
+		},
+	}
+
+	s.hub.sendOutboundMessage(&OutboundMessage{
+		Recipients: []string{inMsg.ClientID},
+		Message:    response,
+	})
+}
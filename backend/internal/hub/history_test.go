@@ -0,0 +1,62 @@
+package hub
+
+import (
+	"testing"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+func TestFetchHistoryPaging(t *testing.T) {
+	const total = maxHistoryLimit + 100
+	server := startHub(t, testConfig(2))
+
+	// The board gets a long history without playing it out
+	coord := types.NewBoardCoordinate(3, 3)
+	s := server.hub.shardFor(coord)
+	s.call(func() {
+		state := s.getOrCreateBoardState(coord)
+		for i := 1; i <= total; i++ {
+			state.Moves = append(state.Moves, types.Move{Position: types.PassPosition, Player: byte(i+1) % 2, MoveNum: uint16(i)})
+		}
+	})
+
+	tests := []struct {
+		name          string
+		offset, limit int
+		wantOffset    int
+		wantMoves     int
+	}{
+		{"first page", 0, 10, 0, 10},
+		{"middle page", 40, 20, 40, 20},
+		{"last page cut short", total - 5, 10, total - 5, 5},
+		{"offset at the end", total, 10, total, 0},
+		{"offset past the end", total + 50, 10, total, 0},
+		{"limit 0 takes the default", 10, 0, 10, defaultHistoryLimit},
+		{"limit capped", 0, maxHistoryLimit + 1, 0, maxHistoryLimit},
+	}
+
+	client := server.connect(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := client.request(types.MsgFetchHistory, &types.FetchHistoryData{BoardX: 3, BoardY: 3, Offset: tt.offset, Limit: tt.limit})
+			history, ok := reply.Data.(*types.HistoryData)
+			if !ok {
+				t.Fatalf("FETCH_HISTORY answered with %s: %+v", reply.Type, reply.Data)
+			}
+			if history.Total != total || history.Offset != tt.wantOffset || len(history.Moves) != tt.wantMoves {
+				t.Fatalf("page of %d moves at %d of %d, want %d at %d of %d",
+					len(history.Moves), history.Offset, history.Total, tt.wantMoves, tt.wantOffset, total)
+			}
+			for i, move := range history.Moves {
+				if want := uint16(tt.wantOffset + i + 1); move.MoveNum != want {
+					t.Fatalf("move %d of the page is move %d, want %d", i, move.MoveNum, want)
+				}
+			}
+		})
+	}
+
+	reply := client.request(types.MsgFetchHistory, &types.FetchHistoryData{BoardX: 3, BoardY: 3, Offset: -1})
+	if reply.Type != types.MsgError {
+		t.Errorf("negative offset answered with %s, want an error", reply.Type)
+	}
+}
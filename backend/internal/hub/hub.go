@@ -1,53 +1,89 @@
 package hub
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/one-million-go/backend/internal/cluster"
+	"github.com/one-million-go/backend/pkg/rules"
 	"github.com/one-million-go/backend/pkg/types"
 )
 
-// GameHub coordinates all client connections and game state
+// GameHub routes client connections to the shards owning the game state.
+// The Run goroutine owns the connections: it handles the handshake and
+// zone subscriptions and hands every request about a board to the shard
+// owning the board. Boards are split between shards by zone, so a zone's
+// boards and subscribers live on one shard.
 type GameHub struct {
-	// Connection management
+	// Connection management. Only Run changes clients and sessions, under
+	// clientsMux so shards can look up the recipients of their messages.
 	clients    map[string]*ClientConnection
+	clientsMux sync.RWMutex
 	Register   chan *ClientConnection
 	Unregister chan *ClientConnection
-	clientsMux sync.RWMutex
 	
-	// Message routing
-	inbound  chan *InboundMessage
-	outbound chan *OutboundMessage
+	// Message routing. Replies and broadcasts go straight to the clients'
+	// send queues from whichever goroutine makes them; a channel back
+	// into Run could fill up and block its only reader.
+	inbound chan *InboundMessage
+	
+	// Owners of the game state, each running its own goroutine
+	shards []*shard
+	
+	// Cluster this hub is a node of, nil when running alone
+	node *clusterNode
+	
+	// Shared read-only state served for boards nobody has played on
+	emptyBoard *types.BoardState
+	
+	// Disconnected clients that may still resume, by client ID
+	sessions map[string]*session
 	
-	// Game state storage (sparse - only active boards)
-	boardStates map[types.BoardCoordinate]*types.BoardState
-	stateMux    sync.RWMutex
+	// Server-wide settings
+	config Config
 	
-	// Zone subscriptions: ZoneID → Set of ClientIDs
-	zoneSubscriptions map[types.ZoneID]map[string]bool
-	zoneMux          sync.RWMutex
+	// Statistics, safe to read from any goroutine
+	stats *hubCounters
 	
-	// Statistics
-	stats *HubStats
+	// Measurements served on /metrics
+	metrics *hubMetrics
 }
 
 // InboundMessage represents a message received from a client
 type InboundMessage struct {
 	ClientID string
 	Message  *types.Message
+
+	// Connection the message arrived on; ClientID is taken from it when
+	// the message is processed, as resuming a session changes it
+	client *ClientConnection
+
+	// Client of another node that forwarded the message, nil for clients
+	// of this node
+	remote *remoteClient
+	
+	// When the message reached this node
+	received time.Time
 }
 
 // OutboundMessage represents a message to send to client(s)
 type OutboundMessage struct {
 	Recipients []string // If empty, broadcast to all clients
 	Message    *types.Message
+	
+	// Board is set for updates sent to a board's watchers. Slow clients
+	// may have them coalesced or dropped, unlike replies.
+	Board *types.BoardCoordinate
 }
 
-// HubStats tracks hub performance metrics
+// HubStats is a snapshot of hub performance metrics
 type HubStats struct {
 	ConnectedClients    int
 	ActiveBoards       int
@@ -55,30 +91,134 @@ type HubStats struct {
 	MessagesReceived   uint64
 	ActiveSubscriptions int
 	Uptime             time.Time
+	
+	// Slow clients: board updates replaced by newer boards or dropped
+	// before being sent, and clients disconnected for falling behind
+	MessagesCoalesced uint64
+	MessagesDropped   uint64
+	SlowDisconnects   uint64
 }
 
-// NewGameHub creates a new game hub instance
-func NewGameHub() *GameHub {
-	return &GameHub{
+// hubCounters holds the live values behind HubStats. The hub updates
+// them while GetStats reads them from other goroutines.
+type hubCounters struct {
+	connectedClients    atomic.Int64
+	activeBoards        atomic.Int64
+	messagesSent        atomic.Uint64
+	messagesReceived    atomic.Uint64
+	activeSubscriptions atomic.Int64
+	messagesCoalesced   atomic.Uint64
+	messagesDropped     atomic.Uint64
+	slowDisconnects     atomic.Uint64
+	started             time.Time
+}
+
+// NewGameHub creates a new game hub instance over the boards saved in the
+// configured store
+func NewGameHub(config Config) (*GameHub, error) {
+	if _, ok := rules.ParseKoRule(string(config.KoRule)); !ok {
+		config.KoRule = rules.DefaultKoRule
+	}
+	if _, ok := rules.ParseScoring(string(config.Scoring)); !ok {
+		config.Scoring = rules.DefaultScoring
+	}
+	if config.SlowClientLimit <= 0 {
+		config.SlowClientLimit = DefaultConfig().SlowClientLimit
+	}
+	if config.SlowClientDrop <= 0 || config.SlowClientDrop > config.SlowClientLimit {
+		config.SlowClientDrop = config.SlowClientLimit
+	}
+	if config.Shards <= 0 {
+		config.Shards = DefaultConfig().Shards
+	}
+	
+	h := &GameHub{
 		clients:           make(map[string]*ClientConnection),
 		Register:         make(chan *ClientConnection, 100),
 		Unregister:       make(chan *ClientConnection, 100),
 		inbound:          make(chan *InboundMessage, 1000),
-		outbound:         make(chan *OutboundMessage, 1000),
-		boardStates:      make(map[types.BoardCoordinate]*types.BoardState),
-		zoneSubscriptions: make(map[types.ZoneID]map[string]bool),
-		stats: &HubStats{
-			Uptime: time.Now(),
+		shards:           make([]*shard, config.Shards),
+		sessions:         make(map[string]*session),
+		config:           config,
+		stats: &hubCounters{
+			started: time.Now(),
 		},
 	}
+	
+	h.emptyBoard = h.newBoardState()
+	for i := range h.shards {
+		h.shards[i] = newShard(h, i)
+	}
+	if config.Bus != nil {
+		if config.Placement == nil {
+			return nil, errors.New("cluster bus given without a placement")
+		}
+		h.node = newClusterNode(config.Bus, config.Placement)
+		view, err := h.loadPlacement(config.Placement)
+		if err != nil {
+			return nil, fmt.Errorf("load placement: %w", err)
+		}
+		h.node.view.Store(view)
+	}
+	h.metrics = newHubMetrics(h)
+	
+	if len(h.config.ResumeSecret) == 0 {
+		// Sessions live in memory, so tokens need not outlive the process
+		h.config.ResumeSecret = make([]byte, 32)
+		if _, err := rand.Read(h.config.ResumeSecret); err != nil {
+			return nil, fmt.Errorf("generate resume secret: %w", err)
+		}
+	}
+	
+	if config.Store != nil {
+		coords, err := config.Store.Coords()
+		if err != nil {
+			return nil, fmt.Errorf("list stored boards: %w", err)
+		}
+		restored := 0
+		for _, coord := range coords {
+			s := h.shardFor(coord)
+			s.stored[coord] = true
+			
+			// With eviction on, boards load when first needed, as evicted
+			// ones do; boards of other nodes are theirs to load
+			if config.EvictAfter > 0 || !h.owns(coord) {
+				continue
+			}
+			state, err := config.Store.Load(coord)
+			if err != nil {
+				return nil, fmt.Errorf("load board %s: %w", coord, err)
+			}
+			if state != nil {
+				s.boardStates[coord] = restoreBoard(state)
+				restored++
+			}
+		}
+		h.stats.activeBoards.Store(int64(restored))
+		log.Printf("💾 Restored %d of %d boards from storage", restored, len(coords))
+	}
+	
+	return h, nil
 }
 
-// Run starts the main hub event loop
+// Run starts the shards and the main hub event loop
 func (h *GameHub) Run() {
-	log.Println("🎮 GameHub starting...")
+	log.Printf("🎮 GameHub starting with %d shards...", len(h.shards))
+	for _, s := range h.shards {
+		go s.run()
+	}
+	if h.node != nil {
+		log.Printf("🔗 Node %s of a %d node cluster", h.node.bus.Self(), len(h.node.placement().Nodes()))
+		go h.receiveFrames()
+		go h.maintainCluster()
+	}
 	
-	// Start background goroutines
-	go h.handleMessages()
+	var sessionTick <-chan time.Time
+	if h.config.ResumeGrace > 0 {
+		ticker := time.NewTicker(sessionSweepInterval)
+		defer ticker.Stop()
+		sessionTick = ticker.C
+	}
 	
 	for {
 		select {
@@ -91,8 +231,8 @@ func (h *GameHub) Run() {
 		case inMsg := <-h.inbound:
 			h.processInboundMessage(inMsg)
 			
-		case outMsg := <-h.outbound:
-			h.sendOutboundMessage(outMsg)
+		case <-sessionTick:
+			h.expireSessions()
 		}
 	}
 }
@@ -102,75 +242,81 @@ func (h *GameHub) registerClient(client *ClientConnection) {
 	h.clients[client.ID] = client
 	h.clientsMux.Unlock()
 	
-	h.stats.ConnectedClients++
-	log.Printf("✅ Client registered: %s (total: %d)", client.ID, h.stats.ConnectedClients)
-	
-	// Send welcome message
-	welcomeMsg := &types.Message{
-		ID:        uuid.New().String(),
-		Type:      "WELCOME",
-		Timestamp: time.Now().Unix(),
-		Data: map[string]interface{}{
-			"clientId": client.ID,
-			"message":  "Connected to One Million Go server",
-		},
-	}
+	connected := h.stats.connectedClients.Add(1)
+	log.Printf("✅ Client registered: %s (total: %d)", client.ID, connected)
 	
-	h.outbound <- &OutboundMessage{
-		Recipients: []string{client.ID},
-		Message:    welcomeMsg,
-	}
+	// The client introduces itself with HELLO and gets a WELCOME back
 }
 
 func (h *GameHub) unregisterClient(client *ClientConnection) {
-	h.clientsMux.Lock()
-	delete(h.clients, client.ID)
-	h.clientsMux.Unlock()
+	current := h.clients[client.ID] == client
+	if current {
+		h.clientsMux.Lock()
+		delete(h.clients, client.ID)
+		h.clientsMux.Unlock()
+	}
 	
-	// Clean up zone subscriptions
-	h.zoneMux.Lock()
-	for zoneID, clientSet := range h.zoneSubscriptions {
-		delete(clientSet, client.ID)
-		if len(clientSet) == 0 {
-			delete(h.zoneSubscriptions, zoneID)
-		}
+	if !current {
+		// Already unregistered, or its session was taken over
+		client.close(closeFrame{code: websocket.CloseNormalClosure}, false)
+		return
 	}
-	h.zoneMux.Unlock()
 	
-	h.stats.ConnectedClients--
-	log.Printf("❌ Client unregistered: %s (total: %d)", client.ID, h.stats.ConnectedClients)
+	if client.resumable && h.config.ResumeGrace > 0 {
+		// Seats and subscriptions wait for the client to come back
+		h.detachClient(client)
+	} else {
+		// Clean up zone subscriptions
+		h.unsubscribeAll(client)
+		
+		h.releaseSeats(client.ID)
+	}
+	
+	connected := h.stats.connectedClients.Add(-1)
+	log.Printf("❌ Client unregistered: %s (total: %d)", client.ID, connected)
 	
 	// Close the connection
-	client.conn.Close()
+	client.close(closeFrame{code: websocket.CloseNormalClosure}, false)
 }
 
-func (h *GameHub) handleMessages() {
-	log.Println("📨 Message handler started")
+func (h *GameHub) processInboundMessage(inMsg *InboundMessage) {
+	h.stats.messagesReceived.Add(1)
+	h.metrics.received.With(typeLabel(inMsg.Message.Type)).Inc()
+	if inMsg.client != nil {
+		inMsg.ClientID = inMsg.client.ID
+	}
 	
-	for {
-		select {
-		case inMsg := <-h.inbound:
-			h.processInboundMessage(inMsg)
+	if inMsg.Message.Type != types.MsgHello {
+		h.ensureHandshake(inMsg.ClientID)
+	}
+	
+	// Requests about a single board are handled by the shard owning it,
+	// which may be on another node
+	if req, ok := inMsg.Message.Data.(types.BoardRequest); ok {
+		if owner, remote := h.remoteOwner(types.ZoneFor(req.Board())); remote {
+			h.forwardRequest(owner, inMsg)
+			return
 		}
+		s := h.shardFor(req.Board())
+		s.do(func() { s.processInboundMessage(inMsg) })
+		return
 	}
-}
-
-func (h *GameHub) processInboundMessage(inMsg *InboundMessage) {
-	h.stats.MessagesReceived++
 	
 	switch inMsg.Message.Type {
-	case types.MsgFetchBoard:
-		h.handleFetchBoard(inMsg)
+	case types.MsgHello:
+		h.handleHello(inMsg)
 		
 	case types.MsgFetchRegion:
+		// Timed until the region is sent
 		h.handleFetchRegion(inMsg)
-		
-	case types.MsgSendMove:
-		h.handleSendMove(inMsg)
+		return
 		
 	case types.MsgSubscribeRegion:
 		h.handleSubscribeRegion(inMsg)
 		
+	case types.MsgUnsubscribe:
+		h.handleUnsubscribeRegion(inMsg)
+		
 	case types.MsgPing:
 		h.handlePing(inMsg)
 		
@@ -178,55 +324,65 @@ func (h *GameHub) processInboundMessage(inMsg *InboundMessage) {
 		log.Printf("⚠️ Unknown message type from %s: %s", inMsg.ClientID, inMsg.Message.Type)
 		h.sendError(inMsg.ClientID, "UNKNOWN_MESSAGE_TYPE", "Unknown message type")
 	}
+	h.observeRequest(inMsg)
 }
 
-func (h *GameHub) handleFetchBoard(inMsg *InboundMessage) {
-	// Parse request data
-	dataBytes, _ := json.Marshal(inMsg.Message.Data)
-	var req types.FetchBoardData
-	if err := json.Unmarshal(dataBytes, &req); err != nil {
-		h.sendError(inMsg.ClientID, "INVALID_REQUEST", "Invalid fetch board request")
+func (h *GameHub) handleFetchRegion(inMsg *InboundMessage) {
+	req, ok := requestData[types.FetchRegionData](h, inMsg)
+	if !ok {
+		return
+	}
+	client := h.getClient(inMsg.ClientID)
+	if client == nil {
 		return
 	}
 	
-	// Get or create board state
-	coord := types.NewBoardCoordinate(req.BoardX, req.BoardY)
-	boardState := h.getOrCreateBoardState(coord)
-	
-	// Send response
-	response := &types.Message{
-		ID:        uuid.New().String(),
-		Type:      types.MsgBoardState,
-		Timestamp: time.Now().Unix(),
-		Data:      boardState,
+	// Each fetch holds a goroutine until its shards and nodes answer, so
+	// a client gets only a few at a time
+	select {
+	case client.regionFetches <- struct{}{}:
+	default:
+		h.sendError(inMsg.ClientID, "REGION_BUSY", "Too many region fetches in flight")
+		h.observeRequest(inMsg)
+		return
 	}
 	
-	h.outbound <- &OutboundMessage{
-		Recipients: []string{inMsg.ClientID},
-		Message:    response,
+	// Split the region's boards between this node and the others
+	local := make([]types.BoardCoordinate, 0)
+	remote := make(map[cluster.NodeID][]types.BoardCoordinate)
+	for y := req.StartY; y < req.StartY+req.Height && y < types.GridSize; y++ {
+		for x := req.StartX; x < req.StartX+req.Width && x < types.GridSize; x++ {
+			coord := types.NewBoardCoordinate(x, y)
+			if owner, ok := h.remoteOwner(types.ZoneFor(coord)); ok {
+				remote[owner] = append(remote[owner], coord)
+			} else {
+				local = append(local, coord)
+			}
+		}
 	}
 	
-	log.Printf("📋 Board state sent to %s: (%d,%d)", inMsg.ClientID, req.BoardX, req.BoardY)
+	// Gathered off the hub goroutine, so a busy shard delays only this reply
+	go func() {
+		defer func() { <-client.regionFetches }()
+		h.sendRegion(inMsg, req, local, remote)
+	}()
 }
 
-func (h *GameHub) handleFetchRegion(inMsg *InboundMessage) {
-	// Parse request data
-	dataBytes, _ := json.Marshal(inMsg.Message.Data)
-	var req types.FetchRegionData
-	if err := json.Unmarshal(dataBytes, &req); err != nil {
-		h.sendError(inMsg.ClientID, "INVALID_REQUEST", "Invalid fetch region request")
-		return
-	}
-	
-	// Collect board states for the region
-	boards := make(map[string]*types.BoardState)
-	
-	for y := req.StartY; y < req.StartY+req.Height && y < 1000; y++ {
-		for x := req.StartX; x < req.StartX+req.Width && x < 1000; x++ {
-			coord := types.NewBoardCoordinate(x, y)
-			boardState := h.getOrCreateBoardState(coord)
-			key := fmt.Sprintf("%d,%d", x, y)
-			boards[key] = boardState
+// sendRegion collects the boards of a region from their shards and nodes
+// and sends them to the client as one REGION_DATA
+func (h *GameHub) sendRegion(inMsg *InboundMessage, req *types.FetchRegionData, local []types.BoardCoordinate, remote map[cluster.NodeID][]types.BoardCoordinate) {
+	defer h.observeRequest(inMsg)
+	
+	boards := h.snapshotBoards(local)
+	for owner, coords := range remote {
+		part, err := h.fetchRemoteBoards(owner, coords)
+		if err != nil {
+			log.Printf("⚠️ Region fetch for %s failed on node %s: %v", inMsg.ClientID, owner, err)
+			h.sendError(inMsg.ClientID, "NODE_UNAVAILABLE", "Part of the region is unavailable")
+			return
+		}
+		for coord, board := range part {
+			boards[coord] = board
 		}
 	}
 	
@@ -246,158 +402,159 @@ func (h *GameHub) handleFetchRegion(inMsg *InboundMessage) {
 		Data:      regionData,
 	}
 	
-	h.outbound <- &OutboundMessage{
+	h.sendOutboundMessage(&OutboundMessage{
 		Recipients: []string{inMsg.ClientID},
 		Message:    response,
-	}
+	})
 	
 	log.Printf("🗺️ Region data sent to %s: (%d,%d) %dx%d", inMsg.ClientID, req.StartX, req.StartY, req.Width, req.Height)
 }
 
-func (h *GameHub) handleSendMove(inMsg *InboundMessage) {
-	// Parse request data
-	dataBytes, _ := json.Marshal(inMsg.Message.Data)
-	var req types.MoveRequestData
-	if err := json.Unmarshal(dataBytes, &req); err != nil {
-		h.sendError(inMsg.ClientID, "INVALID_REQUEST", "Invalid move request")
-		return
-	}
-	
-	// For now, just simulate accepting the move (TODO: Add Go rules validation)
-	coord := types.NewBoardCoordinate(req.BoardX, req.BoardY)
-	boardState := h.getOrCreateBoardState(coord)
-	
-	// Add move to board (simplified)
-	x := uint8(req.Position % 19)
-	y := uint8(req.Position / 19)
-	
-	stone := types.Stone{
-		X:     x,
-		Y:     y,
-		Color: req.Player,
-	}
-	boardState.Stones = append(boardState.Stones, stone)
-	boardState.MoveCount++
-	boardState.LastMove = uint32(time.Now().Unix())
-	
-	// Toggle current player
-	if boardState.CurrentPlayer == 0 {
-		boardState.CurrentPlayer = 1
-	} else {
-		boardState.CurrentPlayer = 0
-	}
-	
-	// Send success response
-	result := &types.MoveResultData{
-		Success:    true,
-		MoveID:     uuid.New().String(),
-		BoardState: boardState,
-	}
-	
-	response := &types.Message{
-		ID:        inMsg.Message.ID, // Use same ID for response
-		Type:      types.MsgMoveResult,
-		Timestamp: time.Now().Unix(),
-		Data:      result,
-	}
-	
-	h.outbound <- &OutboundMessage{
-		Recipients: []string{inMsg.ClientID},
-		Message:    response,
-	}
-	
-	log.Printf("♟️ Move processed for %s: (%d,%d) pos=%d", inMsg.ClientID, req.BoardX, req.BoardY, req.Position)
-}
-
-func (h *GameHub) handleSubscribeRegion(inMsg *InboundMessage) {
-	// TODO: Implement zone-based subscriptions
-	log.Printf("📡 Subscription request from %s (not implemented yet)", inMsg.ClientID)
-}
-
 func (h *GameHub) handlePing(inMsg *InboundMessage) {
 	// Send pong response
 	response := &types.Message{
 		ID:        inMsg.Message.ID,
 		Type:      types.MsgPong,
 		Timestamp: time.Now().Unix(),
-		Data:      map[string]interface{}{"timestamp": time.Now().Unix()},
+		Data:      &types.PongData{Timestamp: time.Now().Unix()},
 	}
 	
-	h.outbound <- &OutboundMessage{
+	h.sendOutboundMessage(&OutboundMessage{
 		Recipients: []string{inMsg.ClientID},
 		Message:    response,
-	}
+	})
 }
 
-func (h *GameHub) getOrCreateBoardState(coord types.BoardCoordinate) *types.BoardState {
-	h.stateMux.RLock()
-	if state, exists := h.boardStates[coord]; exists {
-		h.stateMux.RUnlock()
-		return state
-	}
-	h.stateMux.RUnlock()
-	
-	// Create new board state
-	h.stateMux.Lock()
-	defer h.stateMux.Unlock()
-	
-	// Double-check after acquiring write lock
-	if state, exists := h.boardStates[coord]; exists {
-		return state
-	}
-	
-	// Create fresh board state
-	state := &types.BoardState{
+// newBoardState returns an empty board using the server-wide settings
+func (h *GameHub) newBoardState() *types.BoardState {
+	return &types.BoardState{
 		MoveCount:     0,
 		LastMove:      uint32(time.Now().Unix()),
 		CurrentPlayer: 0, // Black goes first
 		GamePhase:     0, // Playing
 		Activity:      1,
-		Stones:        make([]types.Stone, 0),
+		KoRule:        string(h.config.KoRule),
+		Komi:          h.config.Komi,
+		Scoring:       string(h.config.Scoring),
+		History:       rules.InitialHistory(),
 		Moves:         make([]types.Move, 0),
 	}
+}
+
+// shardFor returns the shard owning a board
+func (h *GameHub) shardFor(coord types.BoardCoordinate) *shard {
+	return h.shardForZone(types.ZoneFor(coord))
+}
+
+// shardForZone returns the shard owning a zone's boards and subscribers
+func (h *GameHub) shardForZone(zoneID types.ZoneID) *shard {
+	return h.shards[int(zoneID)%len(h.shards)]
+}
+
+// snapshotBoards collects snapshots of boards of this node from their
+// shards
+func (h *GameHub) snapshotBoards(coords []types.BoardCoordinate) map[types.BoardCoordinate]*types.BoardState {
+	byShard := make(map[*shard][]types.BoardCoordinate)
+	for _, coord := range coords {
+		s := h.shardFor(coord)
+		byShard[s] = append(byShard[s], coord)
+	}
 	
-	h.boardStates[coord] = state
-	h.stats.ActiveBoards = len(h.boardStates)
-	
-	x, y := coord.Unpack()
-	log.Printf("🆕 Created new board state: (%d,%d)", x, y)
-	
-	return state
+	boards := make(map[types.BoardCoordinate]*types.BoardState, len(coords))
+	for s, shardCoords := range byShard {
+		var part map[types.BoardCoordinate]*types.BoardState
+		s.call(func() { part = s.snapshotBoards(shardCoords) })
+		for coord, board := range part {
+			boards[coord] = board
+		}
+	}
+	return boards
 }
 
+// releaseSeats frees every seat held by a disconnecting client, on this
+// node and the others
+func (h *GameHub) releaseSeats(clientID string) {
+	h.releaseLocalSeats(clientID)
+	h.releaseRemote(clientID)
+}
+
+// releaseLocalSeats frees a client's seats on the boards of this node
+func (h *GameHub) releaseLocalSeats(clientID string) {
+	for _, s := range h.shards {
+		s := s
+		s.do(func() { s.releaseSeats(clientID) })
+	}
+}
+
+// getClient returns a connected client
+func (h *GameHub) getClient(clientID string) *ClientConnection {
+	h.clientsMux.RLock()
+	defer h.clientsMux.RUnlock()
+	return h.clients[clientID]
+}
+
+// sendOutboundMessage queues a message for its recipients. It is safe to
+// call from the hub, the shards and any other goroutine.
 func (h *GameHub) sendOutboundMessage(outMsg *OutboundMessage) {
-	h.stats.MessagesSent++
+	h.stats.messagesSent.Add(1)
+	h.metrics.sent.With(typeLabel(outMsg.Message.Type)).Inc()
 	
-	if len(outMsg.Recipients) == 0 {
+	m := queuedMessage{msg: outMsg.Message, board: outMsg.Board}
+	recipients := outMsg.Recipients
+	if len(recipients) == 0 {
 		// Broadcast to all clients
 		h.clientsMux.RLock()
-		for _, client := range h.clients {
-			select {
-			case client.send <- outMsg.Message:
-			default:
-				// Client send buffer is full, disconnect them
-				h.Unregister <- client
-			}
+		for clientID := range h.clients {
+			recipients = append(recipients, clientID)
 		}
 		h.clientsMux.RUnlock()
-	} else {
-		// Send to specific recipients
-		h.clientsMux.RLock()
-		for _, clientID := range outMsg.Recipients {
-			if client, exists := h.clients[clientID]; exists {
-				select {
-				case client.send <- outMsg.Message:
-				default:
-					// Client send buffer is full, disconnect them
-					h.Unregister <- client
-				}
-			}
+	}
+	remote := make(map[cluster.NodeID][]string)
+	for _, clientID := range recipients {
+		if node, ok := h.remoteNode(clientID); ok {
+			remote[node] = append(remote[node], clientID)
+			continue
 		}
-		h.clientsMux.RUnlock()
+		h.deliver(clientID, m)
+	}
+	h.deliverRemote(remote, m)
+}
+
+// deliver queues a message for a client, or for its session while it is
+// disconnected. Clients too far behind to catch up are disconnected;
+// their read pump then unregisters them.
+func (h *GameHub) deliver(clientID string, m queuedMessage) {
+	client := h.getClient(clientID)
+	if client == nil {
+		h.queueForSession(clientID, m)
+		return
+	}
+	
+	if h.countPush(client.push(m)) == pushOverflow {
+		log.Printf("🐢 Client %s fell too far behind, disconnecting", clientID)
+		h.stats.slowDisconnects.Add(1)
 	}
 }
 
+// countPush records what became of a queued message in the stats
+func (h *GameHub) countPush(result pushResult, superseded int) pushResult {
+	h.stats.messagesCoalesced.Add(uint64(superseded))
+	if result == pushDropped || result == pushOverflow {
+		h.stats.messagesDropped.Add(1)
+	}
+	return result
+}
+
+// requestData returns the payload of a request. The codec has already
+// decoded it into the type's payload struct and validated it.
+func requestData[T any](h *GameHub, inMsg *InboundMessage) (*T, bool) {
+	req, ok := inMsg.Message.Data.(*T)
+	if !ok {
+		h.sendError(inMsg.ClientID, "INVALID_REQUEST", fmt.Sprintf("Invalid %s request", inMsg.Message.Type))
+	}
+	return req, ok
+}
+
 func (h *GameHub) sendError(clientID, code, message string) {
 	errorMsg := &types.Message{
 		ID:        uuid.New().String(),
@@ -409,24 +566,24 @@ func (h *GameHub) sendError(clientID, code, message string) {
 		},
 	}
 	
-	h.outbound <- &OutboundMessage{
+	h.sendOutboundMessage(&OutboundMessage{
 		Recipients: []string{clientID},
 		Message:    errorMsg,
-	}
+	})
 }
 
-// GetStats returns current hub statistics
+// GetStats returns current hub statistics. It is safe to call from any
+// goroutine.
 func (h *GameHub) GetStats() *HubStats {
-	h.clientsMux.RLock()
-	h.stateMux.RLock()
-	defer h.clientsMux.RUnlock()
-	defer h.stateMux.RUnlock()
-	
 	return &HubStats{
-		ConnectedClients: len(h.clients),
-		ActiveBoards:    len(h.boardStates),
-		MessagesSent:    h.stats.MessagesSent,
-		MessagesReceived: h.stats.MessagesReceived,
-		Uptime:          h.stats.Uptime,
+		ConnectedClients:    int(h.stats.connectedClients.Load()),
+		ActiveBoards:        int(h.stats.activeBoards.Load()),
+		MessagesSent:        h.stats.messagesSent.Load(),
+		MessagesReceived:    h.stats.messagesReceived.Load(),
+		ActiveSubscriptions: int(h.stats.activeSubscriptions.Load()),
+		Uptime:              h.stats.started,
+		MessagesCoalesced:   h.stats.messagesCoalesced.Load(),
+		MessagesDropped:     h.stats.messagesDropped.Load(),
+		SlowDisconnects:     h.stats.slowDisconnects.Load(),
 	}
 }
\ No newline at end of file
@@ -0,0 +1,46 @@
+package hub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+func TestRegionFetchesBounded(t *testing.T) {
+	server := startHub(t, testConfig(2))
+	c := server.connect(t)
+
+	// The fetches wait on a stalled shard and keep their slots
+	s := server.hub.shardFor(types.NewBoardCoordinate(0, 0))
+	release := make(chan struct{})
+	s.do(func() { <-release })
+	region := &types.FetchRegionData{StartX: 0, StartY: 0, Width: 2, Height: 2}
+	for i := 0; i < maxRegionFetches+1; i++ {
+		c.send(types.MsgFetchRegion, region)
+	}
+	if data := c.awaitType(types.MsgError, nil).Data.(*types.ErrorData); data.Code != "REGION_BUSY" {
+		t.Fatalf("fetch over the limit refused with %s, want REGION_BUSY", data.Code)
+	}
+
+	close(release)
+	for i := 0; i < maxRegionFetches; i++ {
+		c.awaitType(types.MsgRegionData, nil)
+	}
+
+	// Slots free once their replies are sent
+	deadline := time.Now().Add(awaitTimeout)
+	for {
+		c.send(types.MsgFetchRegion, region)
+		reply := c.await("reply to FETCH_REGION", func(msg *types.Message) bool {
+			return msg.Type == types.MsgRegionData || msg.Type == types.MsgError
+		})
+		if reply.Type == types.MsgRegionData {
+			break
+		}
+		if data := reply.Data.(*types.ErrorData); data.Code != "REGION_BUSY" || time.Now().After(deadline) {
+			t.Fatalf("fetch after the others finished refused with %s", data.Code)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,116 @@
+package hub
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/one-million-go/backend/internal/metrics"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// hubMetrics are the measurements served on /metrics. The gauges and
+// the counters also in HubStats are read from the hub when scraped; the
+// rest are kept here.
+type hubMetrics struct {
+	registry *metrics.Registry
+
+	received      *metrics.CounterVec // By message type
+	sent          *metrics.CounterVec // By message type
+	moves         *metrics.Counter
+	movesRejected *metrics.CounterVec // By error code
+
+	// Time from a request's arrival to the end of its handling, by
+	// message type
+	requestDuration *metrics.HistogramVec
+}
+
+func newHubMetrics(h *GameHub) *hubMetrics {
+	r := metrics.NewRegistry()
+	m := &hubMetrics{
+		registry:        r,
+		received:        r.CounterVec("onemillion_messages_received_total", "Messages received from clients.", "type"),
+		sent:            r.CounterVec("onemillion_messages_sent_total", "Messages sent to clients, counting a message to several clients once.", "type"),
+		moves:           r.Counter("onemillion_moves_total", "Moves and passes played on boards of this node."),
+		movesRejected:   r.CounterVec("onemillion_moves_rejected_total", "Moves and passes refused, by error code.", "code"),
+		requestDuration: r.HistogramVec("onemillion_request_duration_seconds", "Time from receiving a request to finishing handling it, including time queued.", "type", metrics.DefaultBuckets),
+	}
+
+	s := h.stats
+	r.GaugeFunc("onemillion_connected_clients", "Clients connected to this node.", func() float64 {
+		return float64(s.connectedClients.Load())
+	})
+	r.GaugeFunc("onemillion_active_boards", "Boards held in memory.", func() float64 {
+		return float64(s.activeBoards.Load())
+	})
+	r.GaugeFunc("onemillion_active_subscriptions", "Zone subscriptions of clients connected to this node.", func() float64 {
+		return float64(s.activeSubscriptions.Load())
+	})
+	r.GaugeFunc("onemillion_resumable_sessions", "Disconnected clients that may still resume their session.", func() float64 {
+		h.clientsMux.RLock()
+		defer h.clientsMux.RUnlock()
+		return float64(len(h.sessions))
+	})
+	r.CounterFunc("onemillion_messages_coalesced_total", "Board updates for slow clients replaced by a newer one before being sent.", func() float64 {
+		return float64(s.messagesCoalesced.Load())
+	})
+	r.CounterFunc("onemillion_messages_dropped_total", "Messages for slow clients dropped because their send queue was full.", func() float64 {
+		return float64(s.messagesDropped.Load())
+	})
+	r.CounterFunc("onemillion_slow_disconnects_total", "Clients disconnected for falling too far behind.", func() float64 {
+		return float64(s.slowDisconnects.Load())
+	})
+	r.GaugeFunc("onemillion_inbound_queue_depth", "Client messages waiting for the hub.", func() float64 {
+		return float64(len(h.inbound))
+	})
+	r.GaugeVecFunc("onemillion_shard_queue_depth", "Requests waiting for a shard.", "shard", func() map[string]float64 {
+		depths := make(map[string]float64, len(h.shards))
+		for _, sh := range h.shards {
+			depths[strconv.Itoa(sh.id)] = float64(len(sh.requests))
+		}
+		return depths
+	})
+	r.GaugeFunc("onemillion_start_time_seconds", "Time the hub started, in seconds since the Unix epoch.", func() float64 {
+		return float64(s.started.Unix())
+	})
+
+	if h.node != nil {
+		r.GaugeFunc("onemillion_cluster_placement_epoch", "Placement in use, raised by every rebalance.", func() float64 {
+			return float64(h.node.view.Load().epoch)
+		})
+		r.GaugeFunc("onemillion_cluster_remote_clients", "Clients of other nodes known to this node.", func() float64 {
+			h.node.remotesMux.RLock()
+			defer h.node.remotesMux.RUnlock()
+			return float64(len(h.node.remotes))
+		})
+		r.GaugeFunc("onemillion_cluster_released_zones", "Zones handed to other nodes that have not confirmed taking them.", func() float64 {
+			h.node.releasedMux.Lock()
+			defer h.node.releasedMux.Unlock()
+			return float64(len(h.node.released))
+		})
+	}
+	return m
+}
+
+// Metrics returns a handler serving the hub's metrics in the Prometheus
+// text format
+func (h *GameHub) Metrics() http.Handler {
+	return h.metrics.registry
+}
+
+// typeLabel names a message type in metrics. Types outside the protocol
+// share one label, so clients cannot add labels at will.
+func typeLabel(t types.MessageType) string {
+	if !types.KnownType(t) {
+		return "unknown"
+	}
+	return string(t)
+}
+
+// observeRequest records how long a request took since it arrived
+func (h *GameHub) observeRequest(inMsg *InboundMessage) {
+	if inMsg.received.IsZero() {
+		return
+	}
+	h.metrics.requestDuration.With(typeLabel(inMsg.Message.Type)).Observe(time.Since(inMsg.received).Seconds())
+}
@@ -0,0 +1,111 @@
+package hub
+
+import (
+	"bufio"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// scrapeMetrics reads the hub's metrics, failing the test unless every
+// sample follows the HELP and TYPE lines of its family
+func scrapeMetrics(t *testing.T, h *GameHub) map[string]float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.Metrics().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	samples := make(map[string]float64)
+	help, kind := make(map[string]bool), make(map[string]string)
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if fields := strings.Fields(line); len(fields) >= 3 && fields[0] == "#" {
+			switch fields[1] {
+			case "HELP":
+				help[fields[2]] = len(fields) > 3
+			case "TYPE":
+				kind[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, " ")
+		if !ok {
+			t.Fatalf("malformed sample %q", line)
+		}
+		family, _, _ := strings.Cut(name, "{")
+		if kind[family] == "" {
+			trimmed := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(family, "_bucket"), "_sum"), "_count")
+			if kind[trimmed] != "histogram" {
+				t.Errorf("sample %s has no TYPE line", name)
+			}
+			family = trimmed
+		}
+		if !help[family] {
+			t.Errorf("sample %s has no HELP line", name)
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("sample %s: %v", name, err)
+		}
+		samples[name] = v
+	}
+	for family, k := range kind {
+		if k != "counter" && k != "gauge" && k != "histogram" {
+			t.Errorf("%s has type %q", family, k)
+		}
+	}
+	return samples
+}
+
+func TestMetricsCountMoves(t *testing.T) {
+	server := startHub(t, testConfig(2))
+	black, white := server.connect(t), server.connect(t)
+	black.claimSeat(3, 3, "black")
+	white.claimSeat(3, 3, "white")
+
+	before := scrapeMetrics(t, server.hub)
+	for _, name := range []string{"onemillion_moves_total", "onemillion_connected_clients", "onemillion_start_time_seconds"} {
+		if _, ok := before[name]; !ok {
+			t.Errorf("no %s sample", name)
+		}
+	}
+	if clients := before["onemillion_connected_clients"]; clients != 2 {
+		t.Errorf("%g connected clients, want 2", clients)
+	}
+
+	if result := black.move(3, 3, 60); !result.Success {
+		t.Fatalf("move: %+v", result.Error)
+	}
+	rejected := white.move(3, 3, 60)
+	if rejected.Success {
+		t.Fatal("move on an occupied point succeeded")
+	}
+	// Requests are timed once handled, so a later one on the same shard
+	// waits for the moves to be
+	black.fetchBoard(3, 3)
+
+	after := scrapeMetrics(t, server.hub)
+	tests := []struct {
+		sample string
+		delta  float64
+	}{
+		{"onemillion_moves_total", 1},
+		{`onemillion_moves_rejected_total{code="` + rejected.Error.Code + `"}`, 1},
+		{`onemillion_messages_received_total{type="SEND_MOVE"}`, 2},
+		{`onemillion_messages_sent_total{type="MOVE_RESULT"}`, 2},
+		{`onemillion_request_duration_seconds_count{type="SEND_MOVE"}`, 2},
+		{`onemillion_request_duration_seconds_bucket{type="SEND_MOVE",le="+Inf"}`, 2},
+	}
+	for _, tt := range tests {
+		if got := after[tt.sample] - before[tt.sample]; got != tt.delta {
+			t.Errorf("%s rose by %g, want %g", tt.sample, got, tt.delta)
+		}
+	}
+	if after[`onemillion_messages_received_total{type="`+string(types.MsgFetchBoard)+`"}`] < 1 {
+		t.Error("FETCH_BOARD not counted")
+	}
+}
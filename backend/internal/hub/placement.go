@@ -0,0 +1,107 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/one-million-go/backend/internal/cluster"
+	"github.com/one-million-go/backend/internal/storage"
+)
+
+// How often a node tells the others its placement, so nodes that were
+// down when it changed catch up
+const announceInterval = 30 * time.Second
+
+// savedPlacement is the content of Config.PlacementFile
+type savedPlacement struct {
+	Placements [][]cluster.NodeID `json:"placements"` // Node lists of epochs 1 on
+}
+
+// loadPlacement returns the placement saved in the placement file, or
+// the startup one when none was saved. Zones on their way to or from
+// this node when it stopped are not known after a restart: they are
+// served from the store of their new owner.
+func (h *GameHub) loadPlacement(startup cluster.Placement) (*placementView, error) {
+	view := &placementView{placement: startup}
+	if h.config.PlacementFile == "" {
+		return view, nil
+	}
+	data, err := os.ReadFile(h.config.PlacementFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return view, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved savedPlacement
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", h.config.PlacementFile, err)
+	}
+	if len(saved.Placements) == 0 {
+		return view, nil
+	}
+	for i, nodes := range saved.Placements {
+		if len(nodes) == 0 {
+			return nil, fmt.Errorf("%s: placement %d lists no nodes", h.config.PlacementFile, i+1)
+		}
+	}
+	last := saved.Placements[len(saved.Placements)-1]
+	return &placementView{placement: startup.WithNodes(last), epoch: uint64(len(saved.Placements)), history: saved.Placements}, nil
+}
+
+// savePlacement writes a placement to the placement file, replacing the
+// previous one atomically
+func (h *GameHub) savePlacement(view *placementView) {
+	if h.config.PlacementFile == "" {
+		return
+	}
+	data, err := json.Marshal(&savedPlacement{Placements: view.history})
+	if err == nil {
+		err = storage.WriteFileAtomic(h.config.PlacementFile, data)
+	}
+	if err != nil {
+		log.Printf("⚠️ Failed to save placement %d: %v", view.epoch, err)
+	}
+}
+
+// maintainCluster announces the placement every announceInterval, and
+// every handoffTimeout sends unconfirmed handoffs again and stops waiting
+// for overdue ones
+func (h *GameHub) maintainCluster() {
+	announce := time.NewTicker(announceInterval)
+	defer announce.Stop()
+	resend := time.NewTicker(handoffTimeout)
+	defer resend.Stop()
+
+	h.announcePlacement()
+	for {
+		select {
+		case <-announce.C:
+			h.announcePlacement()
+		case <-resend.C:
+			h.resendHandoffs()
+			h.expireHeldZones()
+		}
+	}
+}
+
+// announcePlacement tells the other nodes the placement in use. Nodes
+// that are unreachable hear of it next time.
+func (h *GameHub) announcePlacement() {
+	view := h.node.view.Load()
+	if view.epoch == 0 {
+		return
+	}
+	data, err := json.Marshal(&frame{Kind: framePlacement, Placements: view.history})
+	if err != nil {
+		return
+	}
+	for _, id := range h.peers() {
+		h.node.bus.Send(id, data)
+	}
+}
@@ -0,0 +1,87 @@
+package hub
+
+import "github.com/one-million-go/backend/pkg/types"
+
+// pushResult tells what became of a message pushed onto a sendQueue
+type pushResult int
+
+const (
+	pushQueued    pushResult = iota // Queued behind the earlier messages
+	pushCoalesced                   // Queued in place of older updates of the same board
+	pushDropped                     // Not queued: a board update for a client that fell behind
+	pushOverflow                    // Not queued: the client is too far behind to keep
+	pushClosed                      // Not queued: the connection is closing
+)
+
+// queuedMessage is a message waiting to be written to a client. board is
+// set for board updates sent to watchers, which may be coalesced or
+// dropped since clients can resync with FETCH_BOARD sinceVersion.
+// Replies to requests are never dropped.
+type queuedMessage struct {
+	msg   *types.Message
+	board *types.BoardCoordinate
+}
+
+// sendQueue holds the messages waiting for one client, either for its
+// WritePump or for its session while it is disconnected
+type sendQueue struct {
+	messages []queuedMessage
+	dropAt   int // Board updates are dropped once this many messages wait
+	limit    int // Replies overflow the queue once this many messages wait
+}
+
+func newSendQueue(config Config) sendQueue {
+	return sendQueue{
+		dropAt: config.SlowClientDrop,
+		limit:  config.SlowClientLimit,
+	}
+}
+
+// push queues a message. A full board replaces the updates of the same
+// board still waiting, and superseded is how many it replaced.
+func (q *sendQueue) push(m queuedMessage) (result pushResult, superseded int) {
+	if m.board != nil && isFullBoard(m.msg.Type) {
+		if superseded = q.removeUpdates(*m.board); superseded > 0 {
+			q.messages = append(q.messages, m)
+			return pushCoalesced, superseded
+		}
+	}
+
+	switch {
+	case m.board != nil && len(q.messages) >= q.dropAt:
+		return pushDropped, 0
+	case len(q.messages) >= q.limit:
+		return pushOverflow, 0
+	}
+	q.messages = append(q.messages, m)
+	return pushQueued, 0
+}
+
+// removeUpdates removes the waiting full boards and deltas of a board.
+// Other updates such as GAME_PHASE carry more than the board and stay.
+func (q *sendQueue) removeUpdates(coord types.BoardCoordinate) int {
+	kept := q.messages[:0]
+	for _, m := range q.messages {
+		if m.board == nil || *m.board != coord || !(isFullBoard(m.msg.Type) || m.msg.Type == types.MsgBoardDelta) {
+			kept = append(kept, m)
+		}
+	}
+	removed := len(q.messages) - len(kept)
+	for i := len(kept); i < len(q.messages); i++ {
+		q.messages[i] = queuedMessage{} // Let the removed messages be collected
+	}
+	q.messages = kept
+	return removed
+}
+
+// take removes and returns every waiting message
+func (q *sendQueue) take() []queuedMessage {
+	messages := q.messages
+	q.messages = nil
+	return messages
+}
+
+// isFullBoard reports whether a message type carries a whole board
+func isFullBoard(t types.MessageType) bool {
+	return t == types.MsgBoardUpdate || t == types.MsgBoardState
+}
@@ -0,0 +1,131 @@
+package hub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/one-million-go/backend/pkg/protocol"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// queuedReply is a queued message that is not a board update
+func queuedReply(msgType types.MessageType) queuedMessage {
+	return queuedMessage{msg: &types.Message{Type: msgType}}
+}
+
+// queuedUpdate is a queued board update
+func queuedUpdate(msgType types.MessageType, coord types.BoardCoordinate) queuedMessage {
+	return queuedMessage{msg: &types.Message{Type: msgType}, board: &coord}
+}
+
+func TestSendQueuePush(t *testing.T) {
+	a, b, c := types.NewBoardCoordinate(1, 1), types.NewBoardCoordinate(2, 2), types.NewBoardCoordinate(3, 3)
+	type push struct {
+		m          queuedMessage
+		result     pushResult
+		superseded int
+	}
+	tests := []struct {
+		name        string
+		drop, limit int
+		pushes      []push
+		queued      []types.MessageType
+	}{
+		{"deltas coalesced per board", 10, 20, []push{
+			{queuedUpdate(types.MsgBoardDelta, a), pushQueued, 0},
+			{queuedUpdate(types.MsgBoardDelta, b), pushQueued, 0},
+			{queuedUpdate(types.MsgGamePhase, a), pushQueued, 0},
+			{queuedUpdate(types.MsgBoardDelta, a), pushQueued, 0},
+			{queuedReply(types.MsgMoveResult), pushQueued, 0},
+			{queuedUpdate(types.MsgBoardUpdate, a), pushCoalesced, 2},
+			{queuedUpdate(types.MsgBoardState, b), pushCoalesced, 1},
+			{queuedUpdate(types.MsgBoardUpdate, c), pushQueued, 0},
+		}, []types.MessageType{types.MsgGamePhase, types.MsgMoveResult, types.MsgBoardUpdate, types.MsgBoardState, types.MsgBoardUpdate}},
+		{"updates dropped before the limit", 2, 4, []push{
+			{queuedReply(types.MsgMoveResult), pushQueued, 0},
+			{queuedUpdate(types.MsgBoardDelta, a), pushQueued, 0},
+			{queuedUpdate(types.MsgBoardDelta, a), pushDropped, 0},
+			{queuedUpdate(types.MsgBoardUpdate, b), pushDropped, 0},
+			{queuedUpdate(types.MsgBoardUpdate, a), pushCoalesced, 1}, // Still replaces the delta
+			{queuedReply(types.MsgPong), pushQueued, 0},
+			{queuedReply(types.MsgPong), pushQueued, 0},
+			{queuedReply(types.MsgPong), pushOverflow, 0},
+			{queuedUpdate(types.MsgBoardDelta, b), pushDropped, 0},
+		}, []types.MessageType{types.MsgMoveResult, types.MsgBoardUpdate, types.MsgPong, types.MsgPong}},
+		{"drop 0 drops updates at the limit", 0, 3, []push{
+			{queuedUpdate(types.MsgBoardDelta, a), pushQueued, 0},
+			{queuedUpdate(types.MsgBoardDelta, b), pushQueued, 0},
+			{queuedReply(types.MsgMoveResult), pushQueued, 0},
+			{queuedUpdate(types.MsgBoardDelta, c), pushDropped, 0},
+			{queuedReply(types.MsgMoveResult), pushOverflow, 0},
+		}, []types.MessageType{types.MsgBoardDelta, types.MsgBoardDelta, types.MsgMoveResult}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(1)
+			config.SlowClientDrop, config.SlowClientLimit = tt.drop, tt.limit
+			h, err := NewGameHub(config)
+			if err != nil {
+				t.Fatalf("NewGameHub: %v", err)
+			}
+
+			q := newSendQueue(h.config)
+			for i, p := range tt.pushes {
+				if result, superseded := q.push(p.m); result != p.result || superseded != p.superseded {
+					t.Errorf("push %d (%s): result %d superseding %d, want %d superseding %d",
+						i, p.m.msg.Type, result, superseded, p.result, p.superseded)
+				}
+			}
+			var queued []types.MessageType
+			for _, m := range q.take() {
+				queued = append(queued, m.msg.Type)
+			}
+			if !reflect.DeepEqual(queued, tt.queued) {
+				t.Errorf("queued %v, want %v", queued, tt.queued)
+			}
+		})
+	}
+}
+
+func TestOverflowDisconnects(t *testing.T) {
+	config := testConfig(1)
+	config.SlowClientLimit = 2
+	h, err := NewGameHub(config)
+	if err != nil {
+		t.Fatalf("NewGameHub: %v", err)
+	}
+
+	// Without a WritePump nothing leaves the queue
+	clients := make(chan *ClientConnection, 1)
+	upgrader := websocket.Upgrader{Subprotocols: protocol.Subprotocols}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		clients <- NewClientConnection(conn, h, false)
+	}))
+	t.Cleanup(srv.Close)
+	dialer := websocket.Dialer{Subprotocols: []string{protocol.SubprotocolBinary}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	client := <-clients
+
+	for i, want := range []pushResult{pushQueued, pushQueued, pushOverflow, pushClosed} {
+		if result, _ := client.push(queuedReply(types.MsgPong)); result != want {
+			t.Errorf("push %d: result %d, want %d", i, result, want)
+		}
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, protocol.CloseTooSlow) {
+		t.Errorf("connection ended with %v, want close code %d", err, protocol.CloseTooSlow)
+	}
+}
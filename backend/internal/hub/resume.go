@@ -0,0 +1,207 @@
+package hub
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// How often sessions past their grace window are ended
+const sessionSweepInterval = 10 * time.Second
+
+// session is a disconnected client waiting to be resumed. The client
+// keeps its seats and zone subscriptions, and messages sent to it are
+// queued until it comes back or the session expires. The queue follows
+// the slow client limits of a live connection, and a session whose
+// queue overflows expires at once. Sessions are guarded by clientsMux
+// since shards queue messages for them.
+type session struct {
+	client  *ClientConnection
+	expires time.Time
+	queue   sendQueue
+}
+
+// A resume token reads clientID.issued.nonce.mac: the MAC signs the
+// client ID, the Unix time the token was issued and the nonce of the
+// client's session. Every WELCOME issues a new nonce, so a token stops
+// working once a later one was issued, and a token expires after the
+// resume grace window.
+
+// resumeToken issues a token for a client so it can prove its identity
+// on reconnect, replacing the client's previous one
+func (h *GameHub) resumeToken(client *ClientConnection) string {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		log.Printf("⚠️ Failed to generate a resume nonce for %s: %v", client.ID, err)
+		return ""
+	}
+	client.resumeNonce = base64.RawURLEncoding.EncodeToString(nonce)
+	issued := strconv.FormatInt(time.Now().Unix(), 10)
+	return client.ID + "." + issued + "." + client.resumeNonce + "." + h.resumeMAC(client.ID, issued, client.resumeNonce)
+}
+
+func (h *GameHub) resumeMAC(clientID, issued, nonce string) string {
+	mac := hmac.New(sha256.New, h.config.ResumeSecret)
+	mac.Write([]byte(clientID + "." + issued + "." + nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyResumeToken returns the client ID and session nonce a token was
+// issued with, unless it is forged or older than the grace window
+func (h *GameHub) verifyResumeToken(token string) (clientID, nonce string, ok bool) {
+	// Client IDs may hold dots, so the fields are taken from the end
+	parts := make([]string, 3)
+	rest := token
+	for i := len(parts) - 1; i >= 0; i-- {
+		dot := strings.LastIndexByte(rest, '.')
+		if dot <= 0 {
+			return "", "", false
+		}
+		rest, parts[i] = rest[:dot], rest[dot+1:]
+	}
+	clientID, issued, nonce, mac := rest, parts[0], parts[1], parts[2]
+	if !hmac.Equal([]byte(mac), []byte(h.resumeMAC(clientID, issued, nonce))) {
+		return "", "", false
+	}
+	unix, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil || time.Since(time.Unix(unix, 0)) > h.config.ResumeGrace {
+		return "", "", false
+	}
+	return clientID, nonce, true
+}
+
+// isCurrentNonce reports whether nonce is that of the last token issued
+// to a client, connected or waiting to resume
+func (h *GameHub) isCurrentNonce(clientID, nonce string) bool {
+	h.clientsMux.RLock()
+	defer h.clientsMux.RUnlock()
+
+	holder := h.clients[clientID]
+	if s, exists := h.sessions[clientID]; holder == nil && exists {
+		holder = s.client
+	}
+	return holder != nil && holder.resumeNonce != "" && hmac.Equal([]byte(holder.resumeNonce), []byte(nonce))
+}
+
+// detachClient keeps a disconnected client's state for the resume grace
+// window, along with any messages it was not sent yet
+func (h *GameHub) detachClient(client *ClientConnection) {
+	s := &session{
+		client:  client,
+		expires: time.Now().Add(h.config.ResumeGrace),
+		queue:   client.takeQueue(),
+	}
+	h.clientsMux.Lock()
+	h.sessions[client.ID] = s
+	h.clientsMux.Unlock()
+
+	log.Printf("⏸️ Client %s detached, resumable for %s", client.ID, h.config.ResumeGrace)
+}
+
+// queueForSession keeps a message for a disconnected client, if it has
+// a live session. A session whose queue overflows is left for the next
+// sweep to end.
+func (h *GameHub) queueForSession(clientID string, m queuedMessage) {
+	h.clientsMux.Lock()
+	defer h.clientsMux.Unlock()
+
+	s, exists := h.sessions[clientID]
+	if !exists || s.expires.IsZero() {
+		return
+	}
+	if h.countPush(s.queue.push(m)) == pushOverflow {
+		log.Printf("⚠️ Too many messages queued for %s, ending its session", clientID)
+		s.expires = time.Time{}
+	}
+}
+
+// takeSession removes and returns a client's session
+func (h *GameHub) takeSession(clientID string) *session {
+	h.clientsMux.Lock()
+	defer h.clientsMux.Unlock()
+
+	s := h.sessions[clientID]
+	delete(h.sessions, clientID)
+	return s
+}
+
+// endSession gives up on a disconnected client, freeing its seats and
+// subscriptions
+func (h *GameHub) endSession(clientID string) {
+	if s := h.takeSession(clientID); s != nil {
+		h.freeSession(clientID, s)
+	}
+}
+
+// freeSession releases what a taken session held
+func (h *GameHub) freeSession(clientID string, s *session) {
+	h.unsubscribeAll(s.client)
+	h.releaseSeats(clientID)
+	log.Printf("⌛ Session of %s ended", clientID)
+}
+
+// expireSessions ends every session past its grace window
+func (h *GameHub) expireSessions() {
+	now := time.Now()
+	expired := make([]string, 0)
+	h.clientsMux.RLock()
+	for clientID, s := range h.sessions {
+		if now.After(s.expires) {
+			expired = append(expired, clientID)
+		}
+	}
+	h.clientsMux.RUnlock()
+
+	for _, clientID := range expired {
+		h.endSession(clientID)
+	}
+}
+
+// resumeSession moves a previous identity onto a new connection and
+// returns the messages queued while the client was away. A client that
+// reconnects before its old connection was noticed to be gone takes that
+// connection over. nonce must be that of the last token issued to the
+// identity.
+func (h *GameHub) resumeSession(client *ClientConnection, clientID, nonce string) ([]queuedMessage, bool) {
+	if !h.isCurrentNonce(clientID, nonce) {
+		return nil, false
+	}
+	if old := h.getClient(clientID); old != nil && old != client {
+		h.clientsMux.Lock()
+		delete(h.clients, clientID)
+		h.clientsMux.Unlock()
+		h.detachClient(old)
+		old.close(closeFrame{code: websocket.CloseNormalClosure, text: "session resumed elsewhere"}, false)
+		h.stats.connectedClients.Add(-1)
+	}
+
+	s := h.takeSession(clientID)
+	if s == nil {
+		return nil, false
+	}
+	if !time.Now().Before(s.expires) {
+		// Expired but not swept yet: its queue is incomplete
+		h.freeSession(clientID, s)
+		return nil, false
+	}
+
+	// Seats are held by client ID on the shards and carry over as is
+	client.position = s.client.position
+	client.subscribedZones = s.client.subscribedZones
+	h.clientsMux.Lock()
+	delete(h.clients, client.ID)
+	client.setID(clientID)
+	h.clients[clientID] = client
+	h.clientsMux.Unlock()
+
+	queued := s.queue.take()
+	log.Printf("▶️ Client %s resumed, %d queued messages", clientID, len(queued))
+	return queued, true
+}
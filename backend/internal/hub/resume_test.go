@@ -0,0 +1,132 @@
+package hub
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/one-million-go/backend/pkg/protocol"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// resumeConfig returns test settings with resuming enabled
+func resumeConfig() Config {
+	config := testConfig(2)
+	config.ResumeGrace = time.Minute
+	return config
+}
+
+// hello is a HELLO presenting a resume token
+func hello(token string) *types.HelloData {
+	return &types.HelloData{ProtocolVersion: protocol.Version, Features: []string{protocol.FeatureDeltas}, ResumeToken: token}
+}
+
+// awaitSession waits until a disconnected client waits to resume
+func awaitSession(t *testing.T, h *GameHub, clientID string) {
+	t.Helper()
+	deadline := time.Now().Add(awaitTimeout)
+	for {
+		h.clientsMux.RLock()
+		_, detached := h.sessions[clientID]
+		h.clientsMux.RUnlock()
+		if detached {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("client %s never detached", clientID)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestResumeWithinGrace(t *testing.T) {
+	server := startHub(t, resumeConfig())
+	black, welcome := server.dial(t, hello(""))
+	white := server.connect(t)
+	black.claimSeat(3, 3, "black")
+	white.claimSeat(3, 3, "white")
+
+	black.conn.Close()
+	awaitSession(t, server.hub, black.id)
+	if result := white.move(3, 3, 60); result.Success {
+		t.Fatal("white moved out of turn")
+	}
+
+	// The resumed client keeps its identity and seat
+	resumed, again := server.dial(t, hello(welcome.ResumeToken))
+	if !again.Resumed || again.ClientID != welcome.ClientID {
+		t.Fatalf("WELCOME %+v, want %s resumed", again, welcome.ClientID)
+	}
+	if again.ResumeToken == welcome.ResumeToken {
+		t.Error("resume token not replaced on WELCOME")
+	}
+	if result := resumed.move(3, 3, 60); !result.Success {
+		t.Fatalf("move after resuming: %+v", result.Error)
+	}
+
+	// The first token is spent once a later one was issued
+	resumed.conn.Close()
+	awaitSession(t, server.hub, welcome.ClientID)
+	if _, replayed := server.dial(t, hello(welcome.ResumeToken)); replayed.Resumed || replayed.ClientID == welcome.ClientID {
+		t.Errorf("replayed token resumed %s", replayed.ClientID)
+	}
+	if _, last := server.dial(t, hello(again.ResumeToken)); !last.Resumed {
+		t.Error("latest token did not resume the session")
+	}
+}
+
+func TestExpiredResumeTokenRejected(t *testing.T) {
+	server := startHub(t, resumeConfig())
+	client, welcome := server.dial(t, hello(""))
+	client.conn.Close()
+	awaitSession(t, server.hub, welcome.ClientID)
+
+	// The same token, signed as issued longer ago than the grace window
+	h := server.hub
+	parts := strings.Split(welcome.ResumeToken, ".")
+	nonce := parts[len(parts)-2]
+	issued := strconv.FormatInt(time.Now().Add(-h.config.ResumeGrace-time.Second).Unix(), 10)
+	expired := welcome.ClientID + "." + issued + "." + nonce + "." + h.resumeMAC(welcome.ClientID, issued, nonce)
+
+	if _, again := server.dial(t, hello(expired)); again.Resumed || again.ClientID == welcome.ClientID {
+		t.Errorf("expired token resumed %s", again.ClientID)
+	}
+	if _, forged := server.dial(t, hello(welcome.ResumeToken+"x")); forged.Resumed {
+		t.Error("forged token resumed the session")
+	}
+}
+
+func TestResumeTakesOverConnection(t *testing.T) {
+	server := startHub(t, resumeConfig())
+	old, welcome := server.dial(t, hello(""))
+	white := server.connect(t)
+	old.claimSeat(3, 3, "black")
+	white.claimSeat(3, 3, "white")
+
+	// A client reconnecting before its old connection was noticed to be
+	// gone takes it over
+	current, again := server.dial(t, hello(welcome.ResumeToken))
+	if !again.Resumed || again.ClientID != welcome.ClientID {
+		t.Fatalf("WELCOME %+v, want %s resumed", again, welcome.ClientID)
+	}
+	deadline := time.Now().Add(awaitTimeout)
+	for {
+		old.mu.Lock()
+		closed := old.closed
+		old.mu.Unlock()
+		if closed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("old connection left open")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if result := current.move(3, 3, 60); !result.Success {
+		t.Fatalf("move after the takeover: %+v", result.Error)
+	}
+	if got := server.hub.stats.connectedClients.Load(); got != 2 {
+		t.Errorf("%d clients connected, want 2", got)
+	}
+}
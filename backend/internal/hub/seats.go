@@ -0,0 +1,143 @@
+package hub
+
+import (
+	"log"
+	"time"
+
+	"github.com/one-million-go/backend/pkg/rules"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// Errors returned when a client moves without the right seat
+var (
+	errNotSeated = &rules.MoveError{Code: "NOT_SEATED", Message: "Claim a seat on this board before playing"}
+	errNotTurn   = &rules.MoveError{Code: "NOT_YOUR_TURN", Message: "It is the other player's turn"}
+)
+
+// seatHolder returns a pointer to the board field holding the given seat
+func seatHolder(boardState *types.BoardState, player byte) *string {
+	if player == 0 {
+		return &boardState.BlackPlayer
+	}
+	return &boardState.WhitePlayer
+}
+
+// checkTurn verifies that clientID holds the seat of the player to move.
+// An empty seat holds the empty ID, which therefore never has a turn.
+func checkTurn(boardState *types.BoardState, clientID string) error {
+	if clientID == "" {
+		return errNotSeated
+	}
+	if *seatHolder(boardState, boardState.CurrentPlayer) == clientID {
+		return nil
+	}
+	if boardState.BlackPlayer == clientID || boardState.WhitePlayer == clientID {
+		return errNotTurn
+	}
+	return errNotSeated
+}
+
+func (s *shard) handleClaimSeat(inMsg *InboundMessage) {
+	req, coord, player, ok := s.parseSeatRequest(inMsg)
+	if !ok {
+		return
+	}
+
+	boardState := s.getOrCreateBoardState(coord)
+	holder := seatHolder(boardState, player)
+	if *holder != "" && *holder != inMsg.ClientID {
+		s.hub.sendError(inMsg.ClientID, "SEAT_TAKEN", "Seat is already occupied")
+		return
+	}
+	// A client plays one color per board, which is what its seats record
+	if *seatHolder(boardState, 1-player) == inMsg.ClientID {
+		s.hub.sendError(inMsg.ClientID, "ALREADY_SEATED", "Client already holds the other seat on this board")
+		return
+	}
+
+	*holder = inMsg.ClientID
+	s.takeSeat(inMsg.ClientID, coord, player)
+
+	s.sendBoardState(inMsg, boardState)
+	log.Printf("🪑 %s seated as %s on (%d,%d)", inMsg.ClientID, req.Color, req.BoardX, req.BoardY)
+}
+
+func (s *shard) handleLeaveSeat(inMsg *InboundMessage) {
+	req, coord, player, ok := s.parseSeatRequest(inMsg)
+	if !ok {
+		return
+	}
+
+	boardState := s.viewBoardState(coord)
+	holder := seatHolder(boardState, player)
+	if *holder != inMsg.ClientID {
+		s.hub.sendError(inMsg.ClientID, "NOT_SEATED", "Client does not hold this seat")
+		return
+	}
+
+	*holder = ""
+	s.leaveSeat(inMsg.ClientID, coord)
+
+	s.sendBoardState(inMsg, boardState)
+	log.Printf("🚪 %s left %s seat on (%d,%d)", inMsg.ClientID, req.Color, req.BoardX, req.BoardY)
+}
+
+// parseSeatRequest decodes a CLAIM_SEAT/LEAVE_SEAT payload, replying with
+// an error when it is invalid
+func (s *shard) parseSeatRequest(inMsg *InboundMessage) (*types.SeatData, types.BoardCoordinate, byte, bool) {
+	req, ok := requestData[types.SeatData](s.hub, inMsg)
+	if !ok {
+		return nil, 0, 0, false
+	}
+
+	color, _ := rules.ParseColor(req.Color) // Checked when decoding
+	return req, types.NewBoardCoordinate(req.BoardX, req.BoardY), byte(color - 1), true
+}
+
+// takeSeat records that a client plays the given color on a board
+func (s *shard) takeSeat(clientID string, coord types.BoardCoordinate, player byte) {
+	seats, exists := s.seats[clientID]
+	if !exists {
+		seats = make(map[types.BoardCoordinate]byte)
+		s.seats[clientID] = seats
+	}
+	seats[coord] = player
+}
+
+// leaveSeat forgets a client's seat on a board
+func (s *shard) leaveSeat(clientID string, coord types.BoardCoordinate) {
+	if seats, exists := s.seats[clientID]; exists {
+		delete(seats, coord)
+		if len(seats) == 0 {
+			delete(s.seats, clientID)
+		}
+	}
+}
+
+// releaseSeats frees every seat a disconnecting client holds on the
+// shard's boards
+func (s *shard) releaseSeats(clientID string) {
+	for coord, player := range s.seats[clientID] {
+		if boardState, exists := s.boardStates[coord]; exists {
+			if holder := seatHolder(boardState, player); *holder == clientID {
+				*holder = ""
+			}
+		}
+	}
+	delete(s.seats, clientID)
+}
+
+// sendBoardState replies to a request with the current board state
+func (s *shard) sendBoardState(inMsg *InboundMessage, boardState *types.BoardState) {
+	response := &types.Message{
+		ID:        inMsg.Message.ID,
+		Type:      types.MsgBoardState,
+		Timestamp: time.Now().Unix(),
+		Data:      boardState.Snapshot(),
+	}
+
+	s.hub.sendOutboundMessage(&OutboundMessage{
+		Recipients: []string{inMsg.ClientID},
+		Message:    response,
+	})
+}
@@ -0,0 +1,99 @@
+package hub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+func TestCheckTurn(t *testing.T) {
+	board := &types.BoardState{BlackPlayer: "black", CurrentPlayer: 0}
+	if err := checkTurn(board, "black"); err != nil {
+		t.Errorf("player to move: %v", err)
+	}
+	if err := checkTurn(board, ""); err != errNotSeated {
+		t.Errorf("empty client ID on the empty white seat: got %v, want errNotSeated", err)
+	}
+	board.CurrentPlayer = 1
+	if err := checkTurn(board, "black"); err != errNotTurn {
+		t.Errorf("player out of turn: got %v, want errNotTurn", err)
+	}
+}
+
+// claimSeatReply claims a seat and returns the reply, a BOARD_STATE or
+// an ERROR
+func (c *testClient) claimSeatReply(x, y uint16, color string) *types.Message {
+	id := c.send(types.MsgClaimSeat, &types.SeatData{BoardX: x, BoardY: y, Color: color})
+	return c.await("reply to CLAIM_SEAT", func(msg *types.Message) bool {
+		return msg.ID == id || msg.Type == types.MsgError
+	})
+}
+
+func TestClaimSeat(t *testing.T) {
+	server := startHub(t, testConfig(2))
+	black, white, other := server.connect(t), server.connect(t), server.connect(t)
+	black.claimSeat(3, 3, "black")
+
+	tests := []struct {
+		name   string
+		client *testClient
+		color  string
+		code   string // Error code, empty when the seat is granted
+	}{
+		{"seat taken", other, "black", "SEAT_TAKEN"},
+		{"both colors", black, "white", "ALREADY_SEATED"},
+		{"seat held again", black, "black", ""},
+		{"free seat", white, "white", ""},
+		{"seat taken by the first claim", black, "white", "SEAT_TAKEN"},
+	}
+	for _, tt := range tests {
+		reply := tt.client.claimSeatReply(3, 3, tt.color)
+		switch data := reply.Data.(type) {
+		case *types.ErrorData:
+			if data.Code != tt.code {
+				t.Errorf("%s: error %s, want %q", tt.name, data.Code, tt.code)
+			}
+		case *types.BoardState:
+			if tt.code != "" {
+				t.Errorf("%s: seat granted, want %s", tt.name, tt.code)
+			}
+		}
+	}
+
+	board := other.fetchBoard(3, 3)
+	if board.BlackPlayer != black.id || board.WhitePlayer != white.id {
+		t.Errorf("seats held by %q and %q, want %q and %q", board.BlackPlayer, board.WhitePlayer, black.id, white.id)
+	}
+}
+
+func TestSeatReleasedOnDisconnect(t *testing.T) {
+	server := startHub(t, testConfig(2))
+	black, white := server.connect(t), server.connect(t)
+	black.claimSeat(3, 3, "black")
+	white.claimSeat(3, 3, "white")
+
+	// Without a resume grace window the seat frees as soon as the
+	// disconnect is noticed
+	black.conn.Close()
+	other := server.connect(t)
+	deadline := time.Now().Add(awaitTimeout)
+	for {
+		reply := other.claimSeatReply(3, 3, "black")
+		if _, granted := reply.Data.(*types.BoardState); granted {
+			break
+		}
+		if data := reply.Data.(*types.ErrorData); data.Code != "SEAT_TAKEN" || time.Now().After(deadline) {
+			t.Fatalf("black seat not released: %s", data.Code)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	board := white.fetchBoard(3, 3)
+	if board.BlackPlayer != other.id || board.WhitePlayer != white.id {
+		t.Errorf("seats held by %q and %q, want %q and %q", board.BlackPlayer, board.WhitePlayer, other.id, white.id)
+	}
+	if result := other.move(3, 3, 60); !result.Success {
+		t.Errorf("move by the new black player: %+v", result.Error)
+	}
+}
@@ -0,0 +1,200 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/one-million-go/backend/pkg/rules"
+	"github.com/one-million-go/backend/pkg/sgf"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// ExportSGF returns a board's game as SGF. It reports false for boards
+// that have never been touched, or whose node could not be reached.
+func (h *GameHub) ExportSGF(coord types.BoardCoordinate) ([]byte, bool) {
+	if owner, remote := h.remoteOwner(types.ZoneFor(coord)); remote {
+		r, err := h.callNode(owner, &frame{Kind: frameExportSGF, Board: &coord})
+		if err != nil {
+			log.Printf("⚠️ SGF export failed on node %s: %v", owner, err)
+			return nil, false
+		}
+		return r.Data, r.Found
+	}
+	return h.exportSGF(coord)
+}
+
+// exportSGF exports a board of this node
+func (h *GameHub) exportSGF(coord types.BoardCoordinate) ([]byte, bool) {
+	var data []byte
+	var exists bool
+	s := h.shardFor(coord)
+	s.call(func() {
+		if !s.settled(types.ZoneFor(coord)) {
+			return
+		}
+		if boardState := s.findBoardState(coord); boardState != nil {
+			data, exists = sgf.Encode(boardState), true
+		}
+	})
+	return data, exists
+}
+
+// ImportSGF replaces a board with a game read from SGF. Every move is
+// replayed through the rules, so an invalid game leaves the board as it
+// was.
+func (h *GameHub) ImportSGF(coord types.BoardCoordinate, data []byte) error {
+	if owner, remote := h.remoteOwner(types.ZoneFor(coord)); remote {
+		_, err := h.callNode(owner, &frame{Kind: frameImportSGF, Board: &coord, Data: data})
+		return err
+	}
+	return h.importSGF(coord, data)
+}
+
+// errBoardMoving is returned for imports into a board on its way to this
+// node
+var errBoardMoving = errors.New("board is moving between nodes, try again")
+
+// importSGF imports a game into a board of this node
+func (h *GameHub) importSGF(coord types.BoardCoordinate, data []byte) error {
+	game, err := sgf.Parse(data)
+	if err != nil {
+		return err
+	}
+
+	var importErr error
+	s := h.shardFor(coord)
+	s.call(func() {
+		if !s.settled(types.ZoneFor(coord)) {
+			importErr = errBoardMoving
+			return
+		}
+		boardState := h.newBoardState()
+		if importErr = replayGame(boardState, game); importErr != nil {
+			return
+		}
+
+		// The imported game continues the old board's versions so clients
+		// holding the old board see that it changed
+		old := s.findBoardState(coord)
+		if old != nil {
+			boardState.Version = old.Version
+		} else {
+			h.stats.activeBoards.Add(1)
+		}
+		bumpVersion(boardState)
+
+		s.boardStates[coord] = boardState
+		s.saveBoard(coord, boardState)
+
+		// Seats on the replaced game are void; their holders see the new
+		// board along with the zone's subscribers
+		watchers := s.zoneSubscribers(coord)
+		if old != nil {
+			watchers = s.boardWatchers(coord, old)
+			for _, id := range []string{old.BlackPlayer, old.WhitePlayer} {
+				if id != "" {
+					s.leaveSeat(id, coord)
+				}
+			}
+		}
+		s.broadcastBoardState(coord, boardState, watchers)
+
+		x, y := coord.Unpack()
+		log.Printf("📥 Imported SGF into (%d,%d): %d moves", x, y, len(boardState.Moves))
+	})
+	return importErr
+}
+
+// replayGame applies an SGF game to an empty board
+func replayGame(boardState *types.BoardState, game *sgf.Game) error {
+	if koRule, ok := rules.ParseKoRule(game.KoRule); ok {
+		boardState.KoRule = string(koRule)
+	}
+	if scoring := sgf.ScoringFor(game.Rules); scoring != "" {
+		boardState.Scoring = scoring
+	}
+	if game.Komi != nil {
+		boardState.Komi = *game.Komi
+	}
+
+	if len(game.BlackSetup) > 0 || len(game.WhiteSetup) > 0 {
+		if err := placeSetup(boardState, game.BlackSetup, game.WhiteSetup); err != nil {
+			return err
+		}
+		boardState.SetupBlack = game.BlackSetup
+		boardState.SetupWhite = game.WhiteSetup
+
+		// With handicap stones white plays first
+		if len(game.WhiteSetup) == 0 {
+			boardState.CurrentPlayer = 1
+		}
+		boardState.History = []types.PositionHash{{
+			Hash:   rules.Hash(boardState),
+			Player: 1 - boardState.CurrentPlayer,
+		}}
+	}
+
+	for i, move := range game.Moves {
+		// SGF allows the same color to move twice, e.g. after handicap
+		boardState.CurrentPlayer = move.Player
+
+		if move.IsPass() {
+			playPass(boardState)
+			continue
+		}
+
+		result, err := rules.Play(boardState, int(move.Position), rules.Color(move.Player+1))
+		if err != nil {
+			return fmt.Errorf("move %d: %w", i+1, err)
+		}
+		if move.Player == 0 {
+			boardState.BlackCaptures += uint16(len(result.Captured))
+		} else {
+			boardState.WhiteCaptures += uint16(len(result.Captured))
+		}
+		boardState.Passes = 0
+		endTurn(boardState, move.Position, len(result.Captured))
+	}
+
+	switch {
+	case game.Result != "":
+		boardState.Result = game.Result
+		boardState.GamePhase = types.PhaseFinished
+	case boardState.Passes >= 2:
+		updateScore(boardState)
+		boardState.GamePhase = types.PhaseScoring
+	}
+	return nil
+}
+
+// placeSetup puts AB/AW stones on an empty board. Like a position reached
+// by play, no two stones may share a point and every group needs a
+// liberty.
+func placeSetup(boardState *types.BoardState, black, white []uint16) error {
+	for _, setup := range []struct {
+		stones []uint16
+		color  byte
+	}{{black, types.PointBlack}, {white, types.PointWhite}} {
+		for _, pos := range setup.stones {
+			if boardState.PointAt(int(pos)) != types.PointEmpty {
+				return fmt.Errorf("setup stones overlap at %s", pointName(pos))
+			}
+			boardState.SetPoint(int(pos), setup.color)
+		}
+	}
+
+	for _, stones := range [][]uint16{black, white} {
+		for _, pos := range stones {
+			if boardState.Liberties(boardState.GroupAt(int(pos))) == 0 {
+				return fmt.Errorf("setup stone at %s has no liberties", pointName(pos))
+			}
+		}
+	}
+	return nil
+}
+
+// pointName formats a board position as x,y for error messages
+func pointName(pos uint16) string {
+	return fmt.Sprintf("%d,%d", pos%types.BoardSize, pos/types.BoardSize)
+}
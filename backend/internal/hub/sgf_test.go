@@ -0,0 +1,78 @@
+package hub
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/one-million-go/backend/pkg/sgf"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+func TestReplayGame(t *testing.T) {
+	h := &GameHub{config: DefaultConfig()}
+
+	tests := []struct {
+		name    string
+		sgf     string
+		err     string
+		komi    float64
+		player  byte
+		black   int
+		white   int
+		history int
+	}{
+		{name: "default komi", sgf: "(;GM[1]SZ[19];B[dd];W[pp])", komi: 7.5, black: 1, white: 1, history: 3},
+		{name: "komi", sgf: "(;GM[1]SZ[19]KM[0.5];B[dd])", komi: 0.5, player: 1, black: 1, history: 2},
+		{name: "zero komi", sgf: "(;GM[1]SZ[19]KM[0])", komi: 0, history: 1},
+		{name: "handicap", sgf: "(;GM[1]SZ[19]AB[dd][pp])", komi: 7.5, player: 1, black: 2, history: 1},
+		{name: "handicap then move", sgf: "(;GM[1]SZ[19]AB[dd][pp];W[dp])", komi: 7.5, black: 2, white: 1, history: 2},
+		{name: "overlapping colors", sgf: "(;GM[1]SZ[19]AB[dd]AW[dd])", err: "overlap at 3,3"},
+		{name: "repeated stone", sgf: "(;GM[1]SZ[19]AB[dd][dd])", err: "overlap at 3,3"},
+		{name: "captured setup stone", sgf: "(;GM[1]SZ[19]AB[aa]AW[ba][ab])", err: "0,0 has no liberties"},
+		{name: "captured setup group", sgf: "(;GM[1]SZ[19]AW[aa][ba]AB[ca][ab][bb])", err: "has no liberties"},
+		{name: "illegal move", sgf: "(;GM[1]SZ[19];B[dd];W[dd])", err: "move 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			game, err := sgf.Parse([]byte(tt.sgf))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+
+			boardState := h.newBoardState()
+			err = replayGame(boardState, game)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("replayGame error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("replayGame: %v", err)
+			}
+
+			if boardState.Komi != tt.komi {
+				t.Errorf("komi = %g, want %g", boardState.Komi, tt.komi)
+			}
+			if boardState.CurrentPlayer != tt.player {
+				t.Errorf("current player = %d, want %d", boardState.CurrentPlayer, tt.player)
+			}
+			var black, white int
+			for pos := 0; pos < types.BoardSize*types.BoardSize; pos++ {
+				switch boardState.PointAt(pos) {
+				case types.PointBlack:
+					black++
+				case types.PointWhite:
+					white++
+				}
+			}
+			if black != tt.black || white != tt.white {
+				t.Errorf("stones black %d, white %d, want %d and %d", black, white, tt.black, tt.white)
+			}
+			if len(boardState.History) != tt.history {
+				t.Errorf("history holds %d positions, want %d", len(boardState.History), tt.history)
+			}
+		})
+	}
+}
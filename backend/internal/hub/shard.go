@@ -0,0 +1,425 @@
+package hub
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/one-million-go/backend/internal/cluster"
+	"github.com/one-million-go/backend/pkg/rules"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// shard owns the boards of some zones along with the zones' subscribers.
+// Its goroutine runs every request on those boards, so each board's
+// changes are serialized without locks while other shards run in
+// parallel. Shards never wait on the router: replies and broadcasts go
+// straight to the clients' send queues.
+type shard struct {
+	hub *GameHub
+	id  int
+
+	// Work run on the shard: board requests routed here by the hub and
+	// subscription changes share one queue, so they stay in the order
+	// each client sent them
+	requests chan func()
+
+	// Boards held in memory (sparse - only active boards)
+	boardStates map[types.BoardCoordinate]*types.BoardState
+
+	// Boards of this shard known to be in the store, so a board that was
+	// never played on is told apart without reading the store
+	stored map[types.BoardCoordinate]bool
+
+	// Zone subscriptions: ZoneID → Set of ClientIDs
+	zoneSubscriptions map[types.ZoneID]map[string]bool
+
+	// Seats held on this shard's boards: ClientID → board → player
+	seats map[string]map[types.BoardCoordinate]byte
+
+	// Zones handed to this node whose boards have not arrived yet, with
+	// the work waiting for them
+	incoming map[types.ZoneID]*heldZone
+}
+
+func newShard(h *GameHub, id int) *shard {
+	return &shard{
+		hub:               h,
+		id:                id,
+		requests:          make(chan func(), 1000),
+		boardStates:       make(map[types.BoardCoordinate]*types.BoardState),
+		stored:            make(map[types.BoardCoordinate]bool),
+		zoneSubscriptions: make(map[types.ZoneID]map[string]bool),
+		seats:             make(map[string]map[types.BoardCoordinate]byte),
+		incoming:          make(map[types.ZoneID]*heldZone),
+	}
+}
+
+// run is the shard's event loop
+func (s *shard) run() {
+	var evictTick <-chan time.Time
+	if s.hub.config.EvictAfter > 0 {
+		ticker := time.NewTicker(evictInterval(s.hub.config.EvictAfter))
+		defer ticker.Stop()
+		evictTick = ticker.C
+	}
+
+	for {
+		select {
+		case fn := <-s.requests:
+			fn()
+
+		case <-evictTick:
+			s.evictIdleBoards()
+		}
+	}
+}
+
+func (s *shard) processInboundMessage(inMsg *InboundMessage) {
+	if req, ok := inMsg.Message.Data.(types.BoardRequest); ok {
+		retry := func() { s.processInboundMessage(inMsg) }
+		forward := func(owner cluster.NodeID) { s.hub.forwardRequest(owner, inMsg) }
+		refuse := func() { s.hub.sendError(inMsg.ClientID, "ZONE_BUSY", "Too many requests wait for this board's zone") }
+		if !s.admit(types.ZoneFor(req.Board()), retry, forward, refuse) {
+			return
+		}
+	}
+	defer s.hub.observeRequest(inMsg)
+
+	switch inMsg.Message.Type {
+	case types.MsgFetchBoard:
+		s.handleFetchBoard(inMsg)
+
+	case types.MsgSendMove:
+		s.handleSendMove(inMsg)
+
+	case types.MsgSetRuleset:
+		s.handleSetRuleset(inMsg)
+
+	case types.MsgClaimSeat:
+		s.handleClaimSeat(inMsg)
+
+	case types.MsgLeaveSeat:
+		s.handleLeaveSeat(inMsg)
+
+	case types.MsgPass:
+		s.handlePass(inMsg)
+
+	case types.MsgResign:
+		s.handleResign(inMsg)
+
+	case types.MsgAcceptResult:
+		s.handleAcceptResult(inMsg)
+
+	case types.MsgMarkDead:
+		s.handleMarkDead(inMsg)
+
+	case types.MsgFetchHistory:
+		s.handleFetchHistory(inMsg)
+
+	default:
+		log.Printf("⚠️ Shard %d got a message it does not handle from %s: %s", s.id, inMsg.ClientID, inMsg.Message.Type)
+		s.hub.sendError(inMsg.ClientID, "UNKNOWN_MESSAGE_TYPE", "Unknown message type")
+	}
+}
+
+// do runs fn on the shard goroutine without waiting for it
+func (s *shard) do(fn func()) {
+	s.requests <- fn
+}
+
+// call runs fn on the shard goroutine and waits for it to finish
+func (s *shard) call(fn func()) {
+	done := make(chan struct{})
+	s.requests <- func() {
+		fn()
+		close(done)
+	}
+	<-done
+}
+
+// admit reports whether work on a zone may run now. The zone may have
+// moved since the work was queued: work for a zone of another node is
+// handed to forward, and work for a zone whose boards are still on their
+// way here waits for them and runs as retry. Once maxHeldWork requests
+// wait for the zone, more are passed to refuse; work with a nil refuse
+// always waits.
+func (s *shard) admit(zoneID types.ZoneID, retry func(), forward func(owner cluster.NodeID), refuse func()) bool {
+	if owner, remote := s.hub.remoteOwner(zoneID); remote {
+		forward(owner)
+		return false
+	}
+	if held, waiting := s.incoming[zoneID]; waiting {
+		if refuse != nil && len(held.work) >= maxHeldWork {
+			refuse()
+			return false
+		}
+		held.work = append(held.work, retry)
+		return false
+	}
+	return true
+}
+
+// subscribe adds a client to a zone's subscribers, passing the
+// subscription on if the zone has moved. remote is nil for clients of
+// this node.
+func (s *shard) subscribe(zoneID types.ZoneID, clientID string, remote *remoteClient) {
+	retry := func() { s.subscribe(zoneID, clientID, remote) }
+	forward := func(owner cluster.NodeID) { s.hub.subscribeRemote(owner, clientID, remote, zoneID) }
+	refuse := func() { s.hub.sendError(clientID, "ZONE_BUSY", "Too many requests wait for this zone") }
+	if s.admit(zoneID, retry, forward, refuse) {
+		s.addSubscriber(zoneID, clientID)
+	}
+}
+
+// unsubscribe removes a client from a zone's subscribers, passing it on
+// if the zone has moved. It is never refused, so no subscription is left
+// behind.
+func (s *shard) unsubscribe(zoneID types.ZoneID, clientID string) {
+	retry := func() { s.unsubscribe(zoneID, clientID) }
+	forward := func(owner cluster.NodeID) { s.hub.unsubscribeRemote(owner, clientID, zoneID) }
+	if s.admit(zoneID, retry, forward, nil) {
+		s.removeSubscriber(zoneID, clientID)
+	}
+}
+
+// addSubscriber adds a client to a zone's subscribers
+func (s *shard) addSubscriber(zoneID types.ZoneID, clientID string) {
+	clientSet, exists := s.zoneSubscriptions[zoneID]
+	if !exists {
+		clientSet = make(map[string]bool)
+		s.zoneSubscriptions[zoneID] = clientSet
+	}
+	clientSet[clientID] = true
+}
+
+// removeSubscriber removes a client from a zone's subscribers
+func (s *shard) removeSubscriber(zoneID types.ZoneID, clientID string) {
+	if clientSet, exists := s.zoneSubscriptions[zoneID]; exists {
+		delete(clientSet, clientID)
+		if len(clientSet) == 0 {
+			delete(s.zoneSubscriptions, zoneID)
+		}
+	}
+}
+
+// forgetClients frees the seats and subscriptions of clients that are
+// gone without saying so
+func (s *shard) forgetClients(clientIDs []string) {
+	for _, clientID := range clientIDs {
+		s.releaseSeats(clientID)
+		for zoneID := range s.zoneSubscriptions {
+			s.removeSubscriber(zoneID, clientID)
+		}
+	}
+}
+
+// zoneSubscribers returns the clients watching the zone of a board
+func (s *shard) zoneSubscribers(coord types.BoardCoordinate) []string {
+	clientSet := s.zoneSubscriptions[types.ZoneFor(coord)]
+	subscribers := make([]string, 0, len(clientSet))
+	for clientID := range clientSet {
+		subscribers = append(subscribers, clientID)
+	}
+	return subscribers
+}
+
+// snapshotBoards returns copies of boards owned by the shard. Untouched
+// boards all share the hub's empty board, which is never modified.
+func (s *shard) snapshotBoards(coords []types.BoardCoordinate) map[types.BoardCoordinate]*types.BoardState {
+	boards := make(map[types.BoardCoordinate]*types.BoardState, len(coords))
+	for _, coord := range coords {
+		boardState := s.viewBoardState(coord)
+		if boardState != s.hub.emptyBoard {
+			boardState = boardState.Snapshot()
+		}
+		boards[coord] = boardState
+	}
+	return boards
+}
+
+func (s *shard) handleFetchBoard(inMsg *InboundMessage) {
+	req, ok := requestData[types.FetchBoardData](s.hub, inMsg)
+	if !ok {
+		return
+	}
+
+	// Get or create board state
+	coord := types.NewBoardCoordinate(req.BoardX, req.BoardY)
+	boardState := s.viewBoardState(coord)
+
+	// A client that knows an earlier version only needs what changed since
+	if req.SinceVersion != nil {
+		if deltas, ok := deltasSince(boardState, *req.SinceVersion); ok {
+			s.hub.sendOutboundMessage(&OutboundMessage{
+				Recipients: []string{inMsg.ClientID},
+				Message: &types.Message{
+					ID:        uuid.New().String(),
+					Type:      types.MsgBoardDeltas,
+					Timestamp: time.Now().Unix(),
+					Data: &types.BoardDeltasData{
+						BoardX:  req.BoardX,
+						BoardY:  req.BoardY,
+						Version: boardState.Version,
+						Deltas:  deltas,
+					},
+				},
+			})
+			log.Printf("📋 %d board deltas sent to %s: (%d,%d)", len(deltas), inMsg.ClientID, req.BoardX, req.BoardY)
+			return
+		}
+	}
+
+	// Send response
+	response := &types.Message{
+		ID:        uuid.New().String(),
+		Type:      types.MsgBoardState,
+		Timestamp: time.Now().Unix(),
+		Data:      boardState.Snapshot(),
+	}
+
+	s.hub.sendOutboundMessage(&OutboundMessage{
+		Recipients: []string{inMsg.ClientID},
+		Message:    response,
+	})
+
+	log.Printf("📋 Board state sent to %s: (%d,%d)", inMsg.ClientID, req.BoardX, req.BoardY)
+}
+
+func (s *shard) handleSendMove(inMsg *InboundMessage) {
+	req, ok := requestData[types.MoveRequestData](s.hub, inMsg)
+	if !ok {
+		return
+	}
+
+	coord := types.NewBoardCoordinate(req.BoardX, req.BoardY)
+	boardState := s.viewBoardState(coord)
+
+	if boardState.GamePhase != types.PhasePlaying {
+		s.sendMoveRejected(inMsg, errNotPlaying)
+		return
+	}
+
+	// Only the client seated as the player to move may play
+	if err := checkTurn(boardState, inMsg.ClientID); err != nil {
+		s.sendMoveRejected(inMsg, err)
+		return
+	}
+
+	color := rules.Color(boardState.CurrentPlayer + 1)
+	if req.Player != "" && req.Player != color.String() {
+		s.sendMoveRejected(inMsg, errNotTurn)
+		return
+	}
+
+	// Validate the move against the rules and apply captures
+	moveResult, err := rules.Play(boardState, int(req.Position), color)
+	if err != nil {
+		s.sendMoveRejected(inMsg, err)
+		return
+	}
+
+	if color == rules.Black {
+		boardState.BlackCaptures += uint16(len(moveResult.Captured))
+	} else {
+		boardState.WhiteCaptures += uint16(len(moveResult.Captured))
+	}
+	boardState.Passes = 0
+	endTurn(boardState, req.Position, len(moveResult.Captured))
+	delta := recordDelta(coord, boardState, moveResult.Captured, true)
+	s.saveBoard(coord, boardState)
+
+	s.sendMoveAccepted(inMsg, boardState, delta)
+	s.broadcastDelta(coord, boardState, delta, inMsg.ClientID)
+
+	log.Printf("♟️ Move processed for %s: (%d,%d) pos=%d captured=%d", inMsg.ClientID, req.BoardX, req.BoardY, req.Position, len(moveResult.Captured))
+}
+
+// sendMoveRejected replies to a SEND_MOVE with a failed MOVE_RESULT
+func (s *shard) sendMoveRejected(inMsg *InboundMessage, err error) {
+	errData := &types.ErrorData{
+		Code:    "ILLEGAL_MOVE",
+		Message: err.Error(),
+	}
+	var moveErr *rules.MoveError
+	if errors.As(err, &moveErr) {
+		errData.Code = moveErr.Code
+		errData.Message = moveErr.Message
+	}
+	s.hub.metrics.movesRejected.With(errData.Code).Inc()
+
+	response := &types.Message{
+		ID:        inMsg.Message.ID,
+		Type:      types.MsgMoveResult,
+		Timestamp: time.Now().Unix(),
+		Data: &types.MoveResultData{
+			Success: false,
+			Error:   errData,
+		},
+	}
+
+	s.hub.sendOutboundMessage(&OutboundMessage{
+		Recipients: []string{inMsg.ClientID},
+		Message:    response,
+	})
+
+	log.Printf("🚫 Move rejected for %s: %s", inMsg.ClientID, errData.Code)
+}
+
+func (s *shard) handleSetRuleset(inMsg *InboundMessage) {
+	// Parse request data
+	req, ok := requestData[types.SetRulesetData](s.hub, inMsg)
+	if !ok {
+		return
+	}
+
+	koRule, ok := rules.ParseKoRule(req.KoRule)
+	if !ok {
+		s.hub.sendError(inMsg.ClientID, "INVALID_KO_RULE", "Unknown ko rule: "+req.KoRule)
+		return
+	}
+
+	coord := types.NewBoardCoordinate(req.BoardX, req.BoardY)
+	boardState := s.getOrCreateBoardState(coord)
+	if boardState.MoveCount > 0 {
+		s.hub.sendError(inMsg.ClientID, "GAME_IN_PROGRESS", "Ruleset can only be changed before the first move")
+		return
+	}
+	boardState.KoRule = string(koRule)
+	bumpVersion(boardState)
+	s.saveBoard(coord, boardState)
+
+	s.sendBoardState(inMsg, boardState)
+
+	log.Printf("📐 Ko rule for (%d,%d) set to %s by %s", req.BoardX, req.BoardY, koRule, inMsg.ClientID)
+}
+
+func (s *shard) getOrCreateBoardState(coord types.BoardCoordinate) *types.BoardState {
+	if state := s.findBoardState(coord); state != nil {
+		return state
+	}
+
+	// Create new board state
+	state := s.hub.newBoardState()
+	s.boardStates[coord] = state
+	s.hub.stats.activeBoards.Add(1)
+
+	x, y := coord.Unpack()
+	log.Printf("🆕 Created new board state: (%d,%d)", x, y)
+
+	return state
+}
+
+// saveBoard persists a board after a change. Failures are logged; the
+// game continues in memory.
+func (s *shard) saveBoard(coord types.BoardCoordinate, state *types.BoardState) {
+	if s.hub.config.Store == nil {
+		return
+	}
+	if err := s.hub.config.Store.Save(coord, state); err != nil {
+		log.Printf("⚠️ Failed to save board %s: %v", coord, err)
+		return
+	}
+	s.stored[coord] = true
+}
@@ -0,0 +1,126 @@
+package hub
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/one-million-go/backend/pkg/rules"
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// checkBoard fails the test unless a board snapshot counts every move it
+// holds once, and never goes back to an earlier version
+func checkBoard(t *testing.T, what string, coord types.BoardCoordinate, board *types.BoardState, last map[types.BoardCoordinate]uint32) {
+	t.Helper()
+	if board.Version != uint32(board.MoveCount) {
+		t.Errorf("%s (%s): version %d after %d moves", what, coord, board.Version, board.MoveCount)
+	}
+	if board.Version < last[coord] {
+		t.Errorf("%s (%s): version %d after version %d", what, coord, board.Version, last[coord])
+	}
+	last[coord] = board.Version
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	const movesPerPlayer = 8
+	server := startHub(t, testConfig(4))
+
+	// Boards in zones 0 to 3 lie on each of the four shards; (0,0) and
+	// (1,0) share zone 0 and so their shard
+	coords := []types.BoardCoordinate{
+		types.NewBoardCoordinate(0, 0),
+		types.NewBoardCoordinate(1, 0),
+		types.NewBoardCoordinate(16, 0),
+		types.NewBoardCoordinate(32, 0),
+		types.NewBoardCoordinate(48, 0),
+	}
+	var players sync.WaitGroup
+	for _, coord := range coords {
+		coord := coord
+		x, y := coord.Unpack()
+		black, white := server.connect(t), server.connect(t)
+		black.claimSeat(x, y, "black")
+		white.claimSeat(x, y, "white")
+
+		// Black plays along the top line and white along the bottom one,
+		// so no stone is ever captured. A player keeps its move until the
+		// other one has played.
+		for i, player := range []*testClient{black, white} {
+			row := uint16(i * (rules.BoardSize - 1))
+			player := player
+			players.Add(1)
+			go func() {
+				defer players.Done()
+				for move := uint16(0); move < movesPerPlayer; {
+					result := player.move(x, y, row*rules.BoardSize+move)
+					switch {
+					case result.Success:
+						move++
+					case result.Error != nil && result.Error.Code == errNotTurn.Code:
+						time.Sleep(time.Millisecond)
+					default:
+						t.Errorf("move on (%s): %+v", coord, result.Error)
+						return
+					}
+				}
+			}()
+		}
+	}
+
+	// Readers fetch the boards, alone and in a region over every shard,
+	// while the games go on
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for _, coord := range coords {
+		coord, reader := coord, server.connect(t)
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			x, y := coord.Unpack()
+			last := make(map[types.BoardCoordinate]uint32)
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				checkBoard(t, "FETCH_BOARD", coord, reader.fetchBoard(x, y), last)
+			}
+		}()
+	}
+	regionReader := server.connect(t)
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		last := make(map[types.BoardCoordinate]uint32)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for coord, board := range regionReader.fetchRegion(0, 0, 49, 1).Boards {
+				checkBoard(t, "FETCH_REGION", coord, board, last)
+			}
+		}
+	}()
+
+	players.Wait()
+	close(done)
+	readers.Wait()
+
+	reader := server.connect(t)
+	region := reader.fetchRegion(0, 0, 49, 1)
+	for _, coord := range coords {
+		x, y := coord.Unpack()
+		for what, board := range map[string]*types.BoardState{"FETCH_BOARD": reader.fetchBoard(x, y), "FETCH_REGION": region.Boards[coord]} {
+			if board == nil || board.MoveCount != 2*movesPerPlayer || board.Version != 2*movesPerPlayer {
+				t.Errorf("%s (%s): %+v, want %d moves", what, coord, board, 2*movesPerPlayer)
+			}
+		}
+	}
+	if got, want := server.hub.stats.activeBoards.Load(), int64(len(coords)); got != want {
+		t.Errorf("%d active boards, want %d", got, want)
+	}
+}
@@ -0,0 +1,152 @@
+package hub
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+const (
+	// Viewport size in boards assumed when SUBSCRIBE_REGION has none
+	defaultViewportSize = types.ZoneSize
+
+	// Most zones a single client may be subscribed to at once
+	maxSubscribedZones = 64
+)
+
+func (h *GameHub) handleSubscribeRegion(inMsg *InboundMessage) {
+	req, ok := requestData[types.SubscribeRegionData](h, inMsg)
+	if !ok {
+		return
+	}
+
+	client := h.getClient(inMsg.ClientID)
+	if client == nil {
+		return
+	}
+
+	centerX, centerY := req.CenterX, req.CenterY
+	width, height := uint16(defaultViewportSize), uint16(defaultViewportSize)
+	if vp := req.Viewport; vp != nil {
+		centerX, centerY = vp.CenterX, vp.CenterY
+		if vp.ViewportWidth > 0 && vp.ViewportHeight > 0 {
+			width, height = vp.ViewportWidth, vp.ViewportHeight
+		}
+	}
+
+	startX, startY := uint16(0), uint16(0)
+	if centerX > width/2 {
+		startX = centerX - width/2
+	}
+	if centerY > height/2 {
+		startY = centerY - height/2
+	}
+
+	zones := types.ZonesInRect(startX, startY, width, height)
+	if len(zones) > maxSubscribedZones {
+		h.sendError(inMsg.ClientID, "REGION_TOO_LARGE", "Viewport covers too many zones")
+		return
+	}
+
+	// The new viewport replaces the previous one
+	wanted := make(map[types.ZoneID]bool, len(zones))
+	for _, zoneID := range zones {
+		wanted[zoneID] = true
+	}
+	for _, zoneID := range client.GetSubscribedZones() {
+		if !wanted[zoneID] {
+			h.unsubscribeZone(client, zoneID)
+		}
+	}
+	for _, zoneID := range zones {
+		h.subscribeZone(client, zoneID)
+	}
+	client.UpdatePosition(centerX, centerY)
+
+	h.sendSubscribed(inMsg, client)
+	log.Printf("📡 %s subscribed to %d zones around (%d,%d)", client.ID, len(zones), centerX, centerY)
+}
+
+func (h *GameHub) handleUnsubscribeRegion(inMsg *InboundMessage) {
+	req, ok := requestData[types.UnsubscribeRegionData](h, inMsg)
+	if !ok {
+		return
+	}
+
+	client := h.getClient(inMsg.ClientID)
+	if client == nil {
+		return
+	}
+
+	zones := req.Zones
+	if len(zones) == 0 {
+		zones = client.GetSubscribedZones()
+	}
+	for _, zoneID := range zones {
+		h.unsubscribeZone(client, zoneID)
+	}
+
+	h.sendSubscribed(inMsg, client)
+}
+
+// subscribeZone adds a client to a zone's subscribers, which the shard
+// owning the zone keeps, on whichever node owns it
+func (h *GameHub) subscribeZone(client *ClientConnection, zoneID types.ZoneID) {
+	if client.IsSubscribedTo(zoneID) {
+		return
+	}
+	client.Subscribe(zoneID)
+
+	clientID := client.ID
+	if owner, remote := h.remoteOwner(zoneID); remote {
+		h.subscribeRemote(owner, clientID, nil, zoneID)
+	} else {
+		s := h.shardForZone(zoneID)
+		s.do(func() { s.subscribe(zoneID, clientID, nil) })
+	}
+	h.stats.activeSubscriptions.Add(1)
+}
+
+// unsubscribeZone removes a client from a zone's subscribers
+func (h *GameHub) unsubscribeZone(client *ClientConnection, zoneID types.ZoneID) {
+	if !client.IsSubscribedTo(zoneID) {
+		return
+	}
+	client.Unsubscribe(zoneID)
+
+	clientID := client.ID
+	if owner, remote := h.remoteOwner(zoneID); remote {
+		h.unsubscribeRemote(owner, clientID, zoneID)
+	} else {
+		s := h.shardForZone(zoneID)
+		s.do(func() { s.unsubscribe(zoneID, clientID) })
+	}
+	h.stats.activeSubscriptions.Add(-1)
+}
+
+// unsubscribeAll removes a disconnecting client from every zone
+func (h *GameHub) unsubscribeAll(client *ClientConnection) {
+	for _, zoneID := range client.GetSubscribedZones() {
+		h.unsubscribeZone(client, zoneID)
+	}
+}
+
+// sendSubscribed tells a client which zones it is subscribed to
+func (h *GameHub) sendSubscribed(inMsg *InboundMessage, client *ClientConnection) {
+	zones := client.GetSubscribedZones()
+	sort.Slice(zones, func(i, j int) bool { return zones[i] < zones[j] })
+
+	response := &types.Message{
+		ID:        inMsg.Message.ID,
+		Type:      types.MsgSubscribed,
+		Timestamp: time.Now().Unix(),
+		Data:      &types.SubscribedData{Zones: zones},
+	}
+
+	h.sendOutboundMessage(&OutboundMessage{
+		Recipients: []string{inMsg.ClientID},
+		Message:    response,
+	})
+}
@@ -0,0 +1,320 @@
+// Package metrics keeps counters, gauges and histograms and serves them in
+// the Prometheus text exposition format. Updates are lock-free atomics,
+// so they are cheap enough for every message the server handles.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are histogram upper bounds in seconds suited to request
+// handling, from 100µs to 2.5s
+var DefaultBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// Registry holds metrics in the order they were registered
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// metric is a registered metric family
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+}
+
+// ServeHTTP writes every metric in the text exposition format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	bw.Flush()
+}
+
+// desc names a metric family
+type desc struct {
+	name, help, kind string
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.kind)
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	value atomic.Uint64
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() { c.value.Add(1) }
+
+// Add adds n to the counter
+func (c *Counter) Add(n uint64) { c.value.Add(n) }
+
+type counterMetric struct {
+	desc
+	counter *Counter
+}
+
+func (m *counterMetric) write(w *bufio.Writer) {
+	m.writeHeader(w)
+	writeSample(w, m.name, "", float64(m.counter.value.Load()))
+}
+
+// Counter registers a counter
+func (r *Registry) Counter(name, help string) *Counter {
+	m := &counterMetric{desc: desc{name, help, "counter"}, counter: &Counter{}}
+	r.register(m)
+	return m.counter
+}
+
+// CounterVec is a counter split by the values of one label
+type CounterVec struct {
+	label    string
+	mu       sync.RWMutex
+	counters map[string]*Counter
+}
+
+// With returns the counter for a label value, creating it at zero
+func (v *CounterVec) With(value string) *Counter {
+	v.mu.RLock()
+	c := v.counters[value]
+	v.mu.RUnlock()
+	if c != nil {
+		return c
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c = v.counters[value]; c == nil {
+		c = &Counter{}
+		v.counters[value] = c
+	}
+	return c
+}
+
+type counterVecMetric struct {
+	desc
+	vec *CounterVec
+}
+
+func (m *counterVecMetric) write(w *bufio.Writer) {
+	m.writeHeader(w)
+	m.vec.mu.RLock()
+	values := sortedKeys(m.vec.counters)
+	counts := make([]uint64, len(values))
+	for i, value := range values {
+		counts[i] = m.vec.counters[value].value.Load()
+	}
+	m.vec.mu.RUnlock()
+
+	for i, value := range values {
+		writeSample(w, m.name, labelPair(m.vec.label, value), float64(counts[i]))
+	}
+}
+
+// CounterVec registers a counter split by one label
+func (r *Registry) CounterVec(name, help, label string) *CounterVec {
+	m := &counterVecMetric{desc: desc{name, help, "counter"}, vec: &CounterVec{label: label, counters: make(map[string]*Counter)}}
+	r.register(m)
+	return m.vec
+}
+
+type funcMetric struct {
+	desc
+	label string
+	fn    func() map[string]float64
+}
+
+func (m *funcMetric) write(w *bufio.Writer) {
+	m.writeHeader(w)
+	samples := m.fn()
+	for _, value := range sortedKeys(samples) {
+		labels := ""
+		if m.label != "" {
+			labels = labelPair(m.label, value)
+		}
+		writeSample(w, m.name, labels, samples[value])
+	}
+}
+
+// CounterFunc registers a counter whose value is read from fn, for
+// counters kept elsewhere
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{desc: desc{name, help, "counter"}, fn: single(fn)})
+}
+
+// GaugeFunc registers a gauge whose value is read from fn
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{desc: desc{name, help, "gauge"}, fn: single(fn)})
+}
+
+// GaugeVecFunc registers a gauge split by one label, whose values by
+// label value are read from fn
+func (r *Registry) GaugeVecFunc(name, help, label string, fn func() map[string]float64) {
+	r.register(&funcMetric{desc: desc{name, help, "gauge"}, label: label, fn: fn})
+}
+
+func single(fn func() float64) func() map[string]float64 {
+	return func() map[string]float64 { return map[string]float64{"": fn()} }
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	upper  []float64
+	counts []atomic.Uint64 // Per bucket, plus one for +Inf
+	count  atomic.Uint64
+	sum    atomic.Uint64 // float64 bits
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{upper: buckets, counts: make([]atomic.Uint64, len(buckets)+1)}
+}
+
+// Observe records a value
+func (h *Histogram) Observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.upper, v)].Add(1)
+	h.count.Add(1)
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (h *Histogram) write(w *bufio.Writer, name, labels string) {
+	prefix := labels
+	if prefix != "" {
+		prefix += ","
+	}
+	var cumulative uint64
+	for i, upper := range h.upper {
+		cumulative += h.counts[i].Load()
+		writeSample(w, name+"_bucket", prefix+labelPair("le", formatFloat(upper)), float64(cumulative))
+	}
+	cumulative += h.counts[len(h.upper)].Load()
+	writeSample(w, name+"_bucket", prefix+labelPair("le", "+Inf"), float64(cumulative))
+	writeSample(w, name+"_sum", labels, math.Float64frombits(h.sum.Load()))
+	writeSample(w, name+"_count", labels, float64(cumulative))
+}
+
+// HistogramVec is a histogram split by the values of one label
+type HistogramVec struct {
+	label      string
+	buckets    []float64
+	mu         sync.RWMutex
+	histograms map[string]*Histogram
+}
+
+// With returns the histogram for a label value, creating it empty
+func (v *HistogramVec) With(value string) *Histogram {
+	v.mu.RLock()
+	h := v.histograms[value]
+	v.mu.RUnlock()
+	if h != nil {
+		return h
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if h = v.histograms[value]; h == nil {
+		h = newHistogram(v.buckets)
+		v.histograms[value] = h
+	}
+	return h
+}
+
+type histogramVecMetric struct {
+	desc
+	vec *HistogramVec
+}
+
+func (m *histogramVecMetric) write(w *bufio.Writer) {
+	m.writeHeader(w)
+	m.vec.mu.RLock()
+	values := sortedKeys(m.vec.histograms)
+	histograms := make([]*Histogram, len(values))
+	for i, value := range values {
+		histograms[i] = m.vec.histograms[value]
+	}
+	m.vec.mu.RUnlock()
+
+	for i, value := range values {
+		histograms[i].write(w, m.name, labelPair(m.vec.label, value))
+	}
+}
+
+// HistogramVec registers a histogram split by one label. buckets are
+// the ascending upper bounds; +Inf is added.
+func (r *Registry) HistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	m := &histogramVecMetric{
+		desc: desc{name, help, "histogram"},
+		vec:  &HistogramVec{label: label, buckets: buckets, histograms: make(map[string]*Histogram)},
+	}
+	r.register(m)
+	return m.vec
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func labelPair(name, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return name + `="` + value + `"`
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
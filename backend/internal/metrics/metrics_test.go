@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns the text exposition of a registry
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	return rec.Body.String()
+}
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	moves := r.Counter("test_moves_total", "Moves played.")
+	received := r.CounterVec("test_received_total", "Messages by type,\nwith a \\ in the help.", "type")
+	r.GaugeFunc("test_clients", "Clients connected.", func() float64 { return 3 })
+	r.GaugeVecFunc("test_depth", "Queue depth by shard.", "shard", func() map[string]float64 {
+		return map[string]float64{"1": 4, "0": 0.5}
+	})
+	duration := r.HistogramVec("test_duration_seconds", "Request time.", "type", []float64{0.1, 1})
+
+	moves.Inc()
+	moves.Add(2)
+	received.With("PING").Inc()
+	received.With(`a"b`).Add(5)
+	duration.With("PING").Observe(0.05)
+	duration.With("PING").Observe(0.5)
+	duration.With("PING").Observe(3)
+
+	want := `# HELP test_moves_total Moves played.
+# TYPE test_moves_total counter
+test_moves_total 3
+# HELP test_received_total Messages by type,\nwith a \\ in the help.
+# TYPE test_received_total counter
+test_received_total{type="PING"} 1
+test_received_total{type="a\"b"} 5
+# HELP test_clients Clients connected.
+# TYPE test_clients gauge
+test_clients 3
+# HELP test_depth Queue depth by shard.
+# TYPE test_depth gauge
+test_depth{shard="0"} 0.5
+test_depth{shard="1"} 4
+# HELP test_duration_seconds Request time.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{type="PING",le="0.1"} 1
+test_duration_seconds_bucket{type="PING",le="1"} 2
+test_duration_seconds_bucket{type="PING",le="+Inf"} 3
+test_duration_seconds_sum{type="PING"} 3.55
+test_duration_seconds_count{type="PING"} 3
+`
+	if got := scrape(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Each scrape reads the counters afresh
+	moves.Inc()
+	received.With("PING").Inc()
+	got := scrape(t, r)
+	for _, sample := range []string{"\ntest_moves_total 4\n", "\ntest_received_total{type=\"PING\"} 2\n"} {
+		if !strings.Contains(got, sample) {
+			t.Errorf("second scrape lacks %q:\n%s", sample, got)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// FileStore keeps one JSON file per board under a directory, laid out as
+// boards/{x}/{y}.json. Files are replaced atomically, so a crash leaves
+// either the old or the new state of a board, never a torn one.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore opens (creating if needed) a file store rooted at dir
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "boards"), 0o755); err != nil {
+		return nil, fmt.Errorf("create storage directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) boardPath(coord types.BoardCoordinate) string {
+	x, y := coord.Unpack()
+	return filepath.Join(s.dir, "boards", strconv.Itoa(int(x)), strconv.Itoa(int(y))+".json")
+}
+
+// Save writes the board to a temporary file, syncs it and renames it over
+// the previous version
+func (s *FileStore) Save(coord types.BoardCoordinate, state *types.BoardState) error {
+	data, err := json.Marshal(newRecord(state))
+	if err != nil {
+		return fmt.Errorf("encode board %s: %w", coord, err)
+	}
+
+	path := s.boardPath(coord)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create board directory: %w", err)
+	}
+	return WriteFileAtomic(path, data)
+}
+
+// Load reads a single board file
+func (s *FileStore) Load(coord types.BoardCoordinate) (*types.BoardState, error) {
+	data, err := os.ReadFile(s.boardPath(coord))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("decode board %s: %w", coord, err)
+	}
+	return rec.board(), nil
+}
+
+// LoadAll reads every board file. Temporary files left by a crash during
+// Save are removed.
+func (s *FileStore) LoadAll() (map[types.BoardCoordinate]*types.BoardState, error) {
+	boards := make(map[types.BoardCoordinate]*types.BoardState)
+
+	root := filepath.Join(s.dir, "boards")
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasSuffix(path, ".tmp") {
+			return os.Remove(path)
+		}
+
+		coord, ok := parseBoardPath(root, path)
+		if !ok {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var rec record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return fmt.Errorf("decode %s: %w", path, err)
+		}
+		boards[coord] = rec.board()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return boards, nil
+}
+
+// Coords lists the board files by their paths
+func (s *FileStore) Coords() ([]types.BoardCoordinate, error) {
+	coords := make([]types.BoardCoordinate, 0)
+	root := filepath.Join(s.dir, "boards")
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if coord, ok := parseBoardPath(root, path); ok {
+			coords = append(coords, coord)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return coords, nil
+}
+
+// Close is a no-op; every Save is already durable
+func (s *FileStore) Close() error {
+	return nil
+}
+
+// parseBoardPath extracts the coordinate from a boards/{x}/{y}.json path
+func parseBoardPath(root, path string) (types.BoardCoordinate, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return 0, false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) != 2 || !strings.HasSuffix(parts[1], ".json") {
+		return 0, false
+	}
+	x, errX := strconv.ParseUint(parts[0], 10, 16)
+	y, errY := strconv.ParseUint(strings.TrimSuffix(parts[1], ".json"), 10, 16)
+	if errX != nil || errY != nil {
+		return 0, false
+	}
+	return types.NewBoardCoordinate(uint16(x), uint16(y)), true
+}
+
+// WriteFileAtomic replaces path with data so that it survives a crash at
+// any point: the data is synced before the rename, and the directory is
+// synced after it
+func WriteFileAtomic(path string, data []byte) error {
+	if err := writeTemp(path, data); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// writeTemp writes and syncs data to path's temporary file, ready to be
+// renamed over path
+func writeTemp(path string, data []byte) error {
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// testBoard returns a board with a few moves, seats and a ko history
+func testBoard(version uint32) *types.BoardState {
+	state := &types.BoardState{
+		Version:       version,
+		MoveCount:     2,
+		CurrentPlayer: 0,
+		KoRule:        "simple",
+		Komi:          6.5,
+		Scoring:       "area",
+		BlackPlayer:   "alice",
+		History:       []types.PositionHash{{Hash: 0, Player: 1}, {Hash: 11, Player: 0}, {Hash: 22, Player: 1}},
+		Moves: []types.Move{
+			{Position: 60, Player: 0, MoveNum: 1, X: 3, Y: 3},
+			{Position: 72, Player: 1, MoveNum: 2, X: 15, Y: 3},
+		},
+	}
+	state.SetPoint(60, types.PointBlack)
+	state.SetPoint(72, types.PointWhite)
+	return state
+}
+
+func assertSameBoard(t *testing.T, got, want *types.BoardState) {
+	t.Helper()
+	if got == nil {
+		t.Fatal("board not found")
+	}
+	if got.BlackStones != want.BlackStones || got.WhiteStones != want.WhiteStones {
+		t.Error("stones differ")
+	}
+	if got.Version != want.Version || got.MoveCount != want.MoveCount || got.Komi != want.Komi || got.BlackPlayer != want.BlackPlayer {
+		t.Errorf("metadata = %+v, want %+v", got, want)
+	}
+	if !reflect.DeepEqual(got.Moves, want.Moves) {
+		t.Errorf("moves = %v, want %v", got.Moves, want.Moves)
+	}
+	if !reflect.DeepEqual(got.History, want.History) {
+		t.Errorf("history = %v, want %v", got.History, want.History)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	tests := []struct {
+		name  string
+		saves []types.BoardCoordinate
+		load  types.BoardCoordinate
+		found bool
+	}{
+		{name: "saved board", saves: []types.BoardCoordinate{types.NewBoardCoordinate(3, 4)}, load: types.NewBoardCoordinate(3, 4), found: true},
+		{name: "origin", saves: []types.BoardCoordinate{types.NewBoardCoordinate(0, 0)}, load: types.NewBoardCoordinate(0, 0), found: true},
+		{name: "far corner", saves: []types.BoardCoordinate{types.NewBoardCoordinate(999, 999)}, load: types.NewBoardCoordinate(999, 999), found: true},
+		{name: "never saved", saves: []types.BoardCoordinate{types.NewBoardCoordinate(3, 4)}, load: types.NewBoardCoordinate(4, 3), found: false},
+		{name: "empty store", load: types.NewBoardCoordinate(1, 1), found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewFileStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, coord := range tt.saves {
+				if err := store.Save(coord, testBoard(1)); err != nil {
+					t.Fatalf("Save %s: %v", coord, err)
+				}
+			}
+
+			got, err := store.Load(tt.load)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if !tt.found {
+				if got != nil {
+					t.Fatalf("Load %s = %+v, want nil", tt.load, got)
+				}
+				return
+			}
+			assertSameBoard(t, got, testBoard(1))
+			if got.Moves == nil {
+				t.Error("Moves is nil, want an empty or filled slice")
+			}
+		})
+	}
+}
+
+func TestFileStoreOverwriteAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := types.NewBoardCoordinate(10, 20), types.NewBoardCoordinate(20, 10)
+	for version := uint32(1); version <= 3; version++ {
+		if err := store.Save(a, testBoard(version)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Save(b, testBoard(7)); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	reopened, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boards, err := reopened.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(boards) != 2 {
+		t.Fatalf("LoadAll returned %d boards, want 2", len(boards))
+	}
+	assertSameBoard(t, boards[a], testBoard(3))
+	assertSameBoard(t, boards[b], testBoard(7))
+}
+
+// LoadAll skips stray files and removes temporary files left by a crash
+// in the middle of a Save
+func TestFileStoreLoadAllCleansUp(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coord := types.NewBoardCoordinate(5, 6)
+	if err := store.Save(coord, testBoard(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	tmp := filepath.Join(dir, "boards", "5", "7.json.tmp")
+	stray := []string{
+		filepath.Join(dir, "boards", "5", "notes.txt"),
+		filepath.Join(dir, "boards", "x", "1.json"),
+		filepath.Join(dir, "boards", "top.json"),
+	}
+	for _, path := range append(stray, tmp) {
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte("{torn"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	boards, err := store.LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll: %v", err)
+	}
+	if len(boards) != 1 {
+		t.Fatalf("LoadAll returned %d boards, want 1", len(boards))
+	}
+	assertSameBoard(t, boards[coord], testBoard(2))
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("temporary file still present: %v", err)
+	}
+
+	coords, err := store.Coords()
+	if err != nil {
+		t.Fatalf("Coords: %v", err)
+	}
+	if len(coords) != 1 || coords[0] != coord {
+		t.Errorf("Coords = %v, want [%s]", coords, coord)
+	}
+}
+
+func TestParseBoardPath(t *testing.T) {
+	root := filepath.Join("data", "boards")
+	tests := []struct {
+		path  string
+		coord types.BoardCoordinate
+		ok    bool
+	}{
+		{filepath.Join(root, "12", "34.json"), types.NewBoardCoordinate(12, 34), true},
+		{filepath.Join(root, "0", "0.json"), types.NewBoardCoordinate(0, 0), true},
+		{filepath.Join(root, "12", "34.txt"), 0, false},
+		{filepath.Join(root, "12.json"), 0, false},
+		{filepath.Join(root, "a", "34.json"), 0, false},
+		{filepath.Join(root, "1", "2", "3.json"), 0, false},
+		{filepath.Join(root, "70000", "1.json"), 0, false},
+	}
+
+	for _, tt := range tests {
+		coord, ok := parseBoardPath(root, tt.path)
+		if ok != tt.ok || coord != tt.coord {
+			t.Errorf("parseBoardPath(%q) = %v, %v, want %v, %v", tt.path, coord, ok, tt.coord, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,706 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// DefaultSnapshotEvery is the number of journal records between snapshots
+const DefaultSnapshotEvery = 10000
+
+// Each journal record is framed as a little-endian uint32 payload length,
+// a CRC-32 (IEEE) of the payload, then the payload itself
+const recordHeaderSize = 8
+
+// maxRecordSize guards against reading a garbage length from a torn header
+const maxRecordSize = 16 << 20
+
+// JournalStore appends every board change to a write-ahead journal and
+// periodically compacts all boards into a snapshot. Records only carry
+// the moves added since the previous record of the same board and point
+// back to it, so the journal doubles as an audit trail of every move
+// played. The first record of a board in each journal holds the whole
+// board, so a board is always read from a single file.
+//
+// Only where each board's latest record lies is kept in memory; Load
+// reads the board back from disk.
+//
+// Files in the directory:
+//
+//	snapshot.json       generation of the latest snapshot
+//	snapshot-{gen}.dat  every board as of the start of journal {gen}
+//	journal-{gen}.log   records written from generation {gen} on
+//
+// Recovery reads the latest snapshot and replays the journals from its
+// generation on. A snapshot is written in the background after the
+// journal of its generation is started, and replaces the older ones once
+// it is complete. Journals are kept as the audit trail.
+//
+// Saves append under one lock but share their fsyncs: a Save waits for
+// the first sync started after its record was written, which also covers
+// the records of every Save that arrived meanwhile. Shards saving at the
+// same time thus pay for one sync instead of queueing for one each.
+type JournalStore struct {
+	mu sync.Mutex
+
+	dir           string
+	generation    uint64
+	journal       *os.File
+	offset        int64 // End of the current journal
+	records       int   // Records in the current journal
+	snapshotEvery int
+	compacting    bool // A snapshot is being written
+
+	// Records are numbered as written; synced is the last one known to be
+	// on disk. syncMu lets one Save at a time sync for everyone waiting.
+	written uint64
+	synced  atomic.Uint64
+	syncMu  sync.Mutex
+
+	// Where the latest record of every board lies
+	index map[types.BoardCoordinate]boardIndex
+
+	// Files records are read from. They are added under mu; filesMu is
+	// held while reading so none is closed under a reader.
+	files   map[recordFile]*os.File
+	filesMu sync.RWMutex
+
+	compactions sync.WaitGroup
+}
+
+// recordFile names a file holding records
+type recordFile struct {
+	generation uint64
+	snapshot   bool // The snapshot of the generation rather than its journal
+}
+
+// recordRef locates a record
+type recordRef struct {
+	file   recordFile
+	offset int64
+}
+
+// boardIndex is what the store keeps in memory of a board: its latest
+// record, and enough of its move list and position history to tell
+// whether a Save continues the board or replaces it
+type boardIndex struct {
+	latest       recordRef
+	moves        int
+	history      int
+	lastMove     types.Move
+	lastPosition types.PositionHash
+}
+
+// journalEntry is the payload of a journal record
+type journalEntry struct {
+	Coord types.BoardCoordinate `json:"coord"`
+
+	// Board without its move list and position history
+	State *types.BoardState `json:"state"`
+
+	// Moves and positions added since the record at offset Prev of the
+	// same journal, or the full lists when Reset is set: in the first
+	// record of a board in a journal or snapshot, or after an SGF import
+	Moves   []types.Move         `json:"moves,omitempty"`
+	History []types.PositionHash `json:"history,omitempty"`
+	Reset   bool                 `json:"reset,omitempty"`
+	Prev    int64                `json:"prev"`
+}
+
+// manifest is the content of snapshot.json
+type manifest struct {
+	Generation uint64 `json:"generation"`
+}
+
+// compaction is a snapshot to write: its generation and the boards as
+// the journal of that generation was started
+type compaction struct {
+	generation uint64
+	boards     map[types.BoardCoordinate]boardIndex
+}
+
+// NewJournalStore opens (creating if needed) a journal store in dir.
+// snapshotEvery <= 0 selects DefaultSnapshotEvery.
+func NewJournalStore(dir string, snapshotEvery int) (*JournalStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create storage directory: %w", err)
+	}
+	if snapshotEvery <= 0 {
+		snapshotEvery = DefaultSnapshotEvery
+	}
+
+	s := &JournalStore{
+		dir:           dir,
+		snapshotEvery: snapshotEvery,
+		index:         make(map[types.BoardCoordinate]boardIndex),
+		files:         make(map[recordFile]*os.File),
+	}
+	if err := s.recover(); err != nil {
+		s.closeFiles()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *JournalStore) snapshotPath() string {
+	return filepath.Join(s.dir, "snapshot.json")
+}
+
+func (s *JournalStore) snapshotDataPath(generation uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("snapshot-%d.dat", generation))
+}
+
+func (s *JournalStore) journalPath(generation uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("journal-%d.log", generation))
+}
+
+// recover indexes the latest snapshot and replays the journals written
+// from its generation on. A torn final record of the last journal is cut
+// off so new records follow the last complete one.
+func (s *JournalStore) recover() error {
+	data, err := os.ReadFile(s.snapshotPath())
+	switch {
+	case err == nil:
+		var m manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("decode snapshot: %w", err)
+		}
+		s.generation = m.Generation
+
+		f, err := os.Open(s.snapshotDataPath(m.Generation))
+		if err != nil {
+			return fmt.Errorf("open snapshot: %w", err)
+		}
+		file := recordFile{generation: m.Generation, snapshot: true}
+		s.files[file] = f
+		if _, _, err := s.replay(f, file); err != nil {
+			return err
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+
+	for {
+		f, err := os.OpenFile(s.journalPath(s.generation), os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return fmt.Errorf("open journal: %w", err)
+		}
+		s.files[recordFile{generation: s.generation}] = f
+
+		valid, records, err := s.replay(f, recordFile{generation: s.generation})
+		if err != nil {
+			return err
+		}
+		// A snapshot that did not complete leaves the journals started
+		// after it
+		if info, err := os.Stat(s.journalPath(s.generation + 1)); err == nil && info.Mode().IsRegular() {
+			s.generation++
+			continue
+		}
+
+		if info, err := f.Stat(); err == nil && info.Size() > valid {
+			log.Printf("⚠️ Journal %s: discarding %d bytes after the last complete record", f.Name(), info.Size()-valid)
+			if err := f.Truncate(valid); err != nil {
+				return fmt.Errorf("truncate journal: %w", err)
+			}
+		}
+		if _, err := f.Seek(valid, io.SeekStart); err != nil {
+			return fmt.Errorf("seek journal: %w", err)
+		}
+		s.journal, s.offset, s.records = f, valid, records
+		return nil
+	}
+}
+
+// replay indexes every complete record of a file and returns the offset
+// just after the last one with the number of records
+func (s *JournalStore) replay(f *os.File, file recordFile) (int64, int, error) {
+	r := bufio.NewReader(io.NewSectionReader(f, 0, 1<<62))
+	var offset int64
+	records := 0
+	header := make([]byte, recordHeaderSize)
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return offset, records, nil
+		}
+		size := binary.LittleEndian.Uint32(header[0:4])
+		sum := binary.LittleEndian.Uint32(header[4:8])
+		if size > maxRecordSize {
+			return offset, records, nil
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return offset, records, nil
+		}
+		if crc32.ChecksumIEEE(payload) != sum {
+			return offset, records, nil
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(payload, &entry); err != nil {
+			return offset, records, fmt.Errorf("decode record at offset %d of %s: %w", offset, f.Name(), err)
+		}
+		s.index[entry.Coord] = s.index[entry.Coord].advance(recordRef{file: file, offset: offset}, &entry)
+
+		offset += recordHeaderSize + int64(size)
+		records++
+	}
+}
+
+// advance returns the index of a board after entry, written at ref
+func (idx boardIndex) advance(ref recordRef, entry *journalEntry) boardIndex {
+	if entry.Reset {
+		idx = boardIndex{}
+	}
+	idx.latest = ref
+	idx.moves += len(entry.Moves)
+	idx.history += len(entry.History)
+	if n := len(entry.Moves); n > 0 {
+		idx.lastMove = entry.Moves[n-1]
+	}
+	if n := len(entry.History); n > 0 {
+		idx.lastPosition = entry.History[n-1]
+	}
+	return idx
+}
+
+// continuedBy reports whether state carries on the board as last
+// recorded rather than replacing it with another game. Games are told
+// apart by the last move and position recorded, which another game
+// shares only by replaying the same moves.
+func (idx boardIndex) continuedBy(state *types.BoardState) bool {
+	if len(state.Moves) < idx.moves || len(state.History) < idx.history {
+		return false
+	}
+	return (idx.moves == 0 || state.Moves[idx.moves-1] == idx.lastMove) &&
+		(idx.history == 0 || state.History[idx.history-1] == idx.lastPosition)
+}
+
+// LoadAll reads back every board saved so far
+func (s *JournalStore) LoadAll() (map[types.BoardCoordinate]*types.BoardState, error) {
+	coords, err := s.Coords()
+	if err != nil {
+		return nil, err
+	}
+	boards := make(map[types.BoardCoordinate]*types.BoardState, len(coords))
+	for _, coord := range coords {
+		state, err := s.Load(coord)
+		if err != nil {
+			return nil, err
+		}
+		if state != nil {
+			boards[coord] = state
+		}
+	}
+	return boards, nil
+}
+
+// Coords lists the boards saved so far
+func (s *JournalStore) Coords() ([]types.BoardCoordinate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	coords := make([]types.BoardCoordinate, 0, len(s.index))
+	for coord := range s.index {
+		coords = append(coords, coord)
+	}
+	return coords, nil
+}
+
+// Load reads a single board back from its records
+func (s *JournalStore) Load(coord types.BoardCoordinate) (*types.BoardState, error) {
+	s.filesMu.RLock()
+	defer s.filesMu.RUnlock()
+
+	s.mu.Lock()
+	idx, ok := s.index[coord]
+	f := s.files[idx.latest.file]
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	state, err := readBoard(f, idx.latest.offset)
+	if err != nil {
+		return nil, fmt.Errorf("read board %s: %w", coord, err)
+	}
+	return state, nil
+}
+
+// readBoard reads a board from its latest record in f, following the
+// records back to the one holding the whole board. The caller holds
+// filesMu.
+func readBoard(f *os.File, offset int64) (*types.BoardState, error) {
+	if f == nil {
+		return nil, errors.New("record file is closed")
+	}
+	var chain []*journalEntry
+	for {
+		entry, err := readRecord(f, offset)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, entry)
+		if entry.Reset {
+			break
+		}
+		if entry.Prev >= offset {
+			return nil, fmt.Errorf("record at offset %d of %s points forward", offset, f.Name())
+		}
+		offset = entry.Prev
+	}
+
+	full := chain[len(chain)-1]
+	moves, history := full.Moves, full.History
+	for i := len(chain) - 2; i >= 0; i-- {
+		moves = append(moves, chain[i].Moves...)
+		history = append(history, chain[i].History...)
+	}
+	rec := &record{State: chain[0].State, History: history}
+	if rec.State != nil {
+		rec.State.Moves = moves
+	}
+	return rec.board(), nil
+}
+
+// readRecord reads and checks the record at offset
+func readRecord(f *os.File, offset int64) (*journalEntry, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return nil, fmt.Errorf("read record at offset %d of %s: %w", offset, f.Name(), err)
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("record at offset %d of %s is too large", offset, f.Name())
+	}
+	payload := make([]byte, size)
+	if _, err := f.ReadAt(payload, offset+recordHeaderSize); err != nil {
+		return nil, fmt.Errorf("read record at offset %d of %s: %w", offset, f.Name(), err)
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, fmt.Errorf("record at offset %d of %s is corrupt", offset, f.Name())
+	}
+
+	var entry journalEntry
+	if err := json.Unmarshal(payload, &entry); err != nil {
+		return nil, fmt.Errorf("decode record at offset %d of %s: %w", offset, f.Name(), err)
+	}
+	return &entry, nil
+}
+
+// Save appends a record with the board's changes since its previous
+// record and returns once the record is synced to disk
+func (s *JournalStore) Save(coord types.BoardCoordinate, state *types.BoardState) error {
+	seq, err := s.write(coord, state)
+	if err != nil {
+		return err
+	}
+	return s.waitSynced(seq)
+}
+
+// write appends the record of a Save and returns its number
+func (s *JournalStore) write(coord types.BoardCoordinate, state *types.BoardState) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	header := *state
+	header.Moves = nil
+	header.History = nil
+	entry := &journalEntry{Coord: coord, State: &header}
+
+	// Only the tail of the move list and history is new, unless the board
+	// was replaced by a different game or has no record in this journal
+	idx, ok := s.index[coord]
+	if ok && idx.latest.file == (recordFile{generation: s.generation}) && idx.continuedBy(state) {
+		entry.Moves = state.Moves[idx.moves:]
+		entry.History = state.History[idx.history:]
+		entry.Prev = idx.latest.offset
+	} else {
+		entry.Moves, entry.History, entry.Reset = state.Moves, state.History, true
+	}
+
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return 0, fmt.Errorf("encode journal record: %w", err)
+	}
+	offset := s.offset
+	if err := s.append(payload); err != nil {
+		return 0, err
+	}
+	s.index[coord] = idx.advance(recordRef{file: recordFile{generation: s.generation}, offset: offset}, entry)
+	seq := s.written
+
+	// The record is already in the journal, so a failed rotation is only
+	// retried with the next record
+	if s.records >= s.snapshotEvery && !s.compacting {
+		c, err := s.rotate()
+		if err != nil {
+			log.Printf("⚠️ Journal rotation failed, staying on generation %d: %v", s.generation, err)
+		} else {
+			s.compactions.Add(1)
+			go func() {
+				defer s.compactions.Done()
+				s.compact(c)
+			}()
+		}
+	}
+	return seq, nil
+}
+
+// waitSynced returns once record seq is on disk, syncing the journal
+// unless a sync started after the record was written already covers it
+func (s *JournalStore) waitSynced(seq uint64) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	if s.synced.Load() >= seq {
+		return nil
+	}
+
+	s.mu.Lock()
+	journal, written := s.journal, s.written
+	s.mu.Unlock()
+
+	if err := journal.Sync(); err != nil {
+		// A rotation may have synced the journal's records before moving
+		// on to the next one
+		if s.synced.Load() >= seq {
+			return nil
+		}
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	s.markSynced(written)
+	return nil
+}
+
+// markSynced records that every record up to seq is on disk
+func (s *JournalStore) markSynced(seq uint64) {
+	for {
+		synced := s.synced.Load()
+		if synced >= seq || s.synced.CompareAndSwap(synced, seq) {
+			return
+		}
+	}
+}
+
+// append writes one framed record at the end of the journal. It reaches
+// the disk with the next sync.
+func (s *JournalStore) append(payload []byte) error {
+	n, err := writeRecord(s.journal, payload)
+	if err != nil {
+		return fmt.Errorf("append journal: %w", err)
+	}
+	s.offset += n
+	s.records++
+	s.written++
+	return nil
+}
+
+// writeRecord frames payload onto w and returns the bytes written
+func writeRecord(w io.Writer, payload []byte) (int64, error) {
+	buf := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[recordHeaderSize:], payload)
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// rotate starts the journal of the next generation and returns the
+// snapshot to write for it. The current journal is synced first, as
+// Saves waiting for its records sync the next one. The caller holds mu.
+func (s *JournalStore) rotate() (*compaction, error) {
+	next := s.generation + 1
+	f, err := os.OpenFile(s.journalPath(next), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	if err := s.journal.Sync(); err != nil {
+		f.Close()
+		os.Remove(s.journalPath(next))
+		return nil, fmt.Errorf("sync journal: %w", err)
+	}
+	s.markSynced(s.written)
+
+	// The old journal stays open for reads until the snapshot is written
+	s.files[recordFile{generation: next}] = f
+	s.journal, s.generation, s.offset, s.records = f, next, 0, 0
+	s.compacting = true
+
+	boards := make(map[types.BoardCoordinate]boardIndex, len(s.index))
+	for coord, idx := range s.index {
+		boards[coord] = idx
+	}
+	return &compaction{generation: next, boards: boards}, nil
+}
+
+// compact writes the snapshot of a compaction, logging a failure: the
+// journals it would replace are replayed on recovery meanwhile
+func (s *JournalStore) compact(c *compaction) {
+	err := s.writeSnapshot(c)
+	s.mu.Lock()
+	s.compacting = false
+	s.mu.Unlock()
+	if err != nil {
+		log.Printf("⚠️ Journal snapshot %d failed: %v", c.generation, err)
+	}
+}
+
+// writeSnapshot writes every board of a compaction to the snapshot of its
+// generation, then points the boards unchanged since to it and drops the
+// files no board lies in anymore
+func (s *JournalStore) writeSnapshot(c *compaction) error {
+	path := s.snapshotDataPath(c.generation)
+	offsets, err := s.writeBoards(path+".tmp", c.boards)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open snapshot: %w", err)
+	}
+	data, err := json.Marshal(&manifest{Generation: c.generation})
+	if err == nil {
+		err = WriteFileAtomic(s.snapshotPath(), data)
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+
+	file := recordFile{generation: c.generation, snapshot: true}
+	var stale []recordFile
+	s.mu.Lock()
+	for coord, offset := range offsets {
+		if idx := s.index[coord]; idx.latest == c.boards[coord].latest {
+			idx.latest = recordRef{file: file, offset: offset}
+			s.index[coord] = idx
+		}
+	}
+	s.files[file] = f
+	for old := range s.files {
+		if old.generation < c.generation {
+			stale = append(stale, old)
+		}
+	}
+	s.mu.Unlock()
+
+	s.filesMu.Lock()
+	for _, old := range stale {
+		s.mu.Lock()
+		f := s.files[old]
+		delete(s.files, old)
+		s.mu.Unlock()
+		f.Close()
+		if old.snapshot {
+			os.Remove(s.snapshotDataPath(old.generation))
+		}
+	}
+	s.filesMu.Unlock()
+
+	log.Printf("💾 Snapshot %d written: %d boards", c.generation, len(offsets))
+	return nil
+}
+
+// writeBoards reads back the boards of a compaction and writes each as
+// one record to path, synced. It returns the offset of every board.
+func (s *JournalStore) writeBoards(path string, boards map[types.BoardCoordinate]boardIndex) (map[types.BoardCoordinate]int64, error) {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+
+	offsets := make(map[types.BoardCoordinate]int64, len(boards))
+	var offset int64
+	for coord, idx := range boards {
+		state, err := s.readAt(idx.latest)
+		if err != nil {
+			return nil, fmt.Errorf("read board %s: %w", coord, err)
+		}
+		header := *state
+		header.Moves = nil
+		header.History = nil
+		payload, err := json.Marshal(&journalEntry{Coord: coord, State: &header, Moves: state.Moves, History: state.History, Reset: true})
+		if err != nil {
+			return nil, fmt.Errorf("encode board %s: %w", coord, err)
+		}
+		n, err := writeRecord(w, payload)
+		if err != nil {
+			return nil, err
+		}
+		offsets[coord] = offset
+		offset += n
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return offsets, out.Sync()
+}
+
+// readAt reads a board from its latest record
+func (s *JournalStore) readAt(ref recordRef) (*types.BoardState, error) {
+	s.filesMu.RLock()
+	defer s.filesMu.RUnlock()
+	s.mu.Lock()
+	f := s.files[ref.file]
+	s.mu.Unlock()
+	return readBoard(f, ref.offset)
+}
+
+// Close writes a final snapshot and closes the files
+func (s *JournalStore) Close() error {
+	s.compactions.Wait()
+
+	var err error
+	s.mu.Lock()
+	var c *compaction
+	if s.records > 0 {
+		c, err = s.rotate()
+	}
+	s.mu.Unlock()
+	if c != nil {
+		err = s.writeSnapshot(c)
+	}
+
+	if closeErr := s.closeFiles(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// closeFiles closes every file records are read from, the journal
+// included
+func (s *JournalStore) closeFiles() error {
+	s.filesMu.Lock()
+	defer s.filesMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for file, f := range s.files {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		delete(s.files, file)
+	}
+	return err
+}
@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// crash drops a journal store without the final snapshot of Close, as if
+// the process died
+func crash(s *JournalStore) {
+	s.compactions.Wait()
+	s.mu.Lock()
+	s.journal.Close()
+	s.mu.Unlock()
+}
+
+func openJournal(t *testing.T, dir string, snapshotEvery int) *JournalStore {
+	t.Helper()
+	s, err := NewJournalStore(dir, snapshotEvery)
+	if err != nil {
+		t.Fatalf("NewJournalStore: %v", err)
+	}
+	return s
+}
+
+// playMove adds a move and a position to a board, as the hub does
+func playMove(state *types.BoardState, pos uint16) {
+	state.Version++
+	state.MoveCount++
+	state.Moves = append(state.Moves, types.Move{Position: pos, MoveNum: state.MoveCount})
+	state.History = append(state.History, types.PositionHash{Hash: uint64(pos) + 1})
+	state.SetPoint(int(pos), types.PointBlack)
+}
+
+func TestJournalStoreRecovery(t *testing.T) {
+	dir := t.TempDir()
+	s := openJournal(t, dir, 100)
+
+	a, b := types.NewBoardCoordinate(1, 2), types.NewBoardCoordinate(500, 600)
+	boardA, boardB := testBoard(1), testBoard(1)
+	for i := uint16(0); i < 5; i++ {
+		playMove(boardA, 100+i)
+		if err := s.Save(a, boardA); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Save(b, boardB); err != nil {
+		t.Fatal(err)
+	}
+
+	// A replaced game is journaled in full
+	replaced := testBoard(9)
+	if err := s.Save(b, replaced); err != nil {
+		t.Fatal(err)
+	}
+	crash(s)
+
+	s = openJournal(t, dir, 100)
+	defer s.Close()
+	boards, err := s.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(boards) != 2 {
+		t.Fatalf("recovered %d boards, want 2", len(boards))
+	}
+	assertSameBoard(t, boards[a], boardA)
+	assertSameBoard(t, boards[b], replaced)
+
+	coords, err := s.Coords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(coords) != 2 || coords[0] == coords[1] || boards[coords[0]] == nil || boards[coords[1]] == nil {
+		t.Errorf("Coords = %v, want %s and %s", coords, a, b)
+	}
+}
+
+// A crash in the middle of an append leaves a torn final record. Recovery
+// keeps every complete record, cuts the rest off and appends after it.
+func TestJournalStoreTornFinalRecord(t *testing.T) {
+	tests := []struct {
+		name string
+		torn func(complete []byte) []byte
+	}{
+		{"partial header", func(complete []byte) []byte { return complete[:5] }},
+		{"partial payload", func(complete []byte) []byte { return complete[:len(complete)-3] }},
+		{"bad checksum", func(complete []byte) []byte {
+			torn := append([]byte(nil), complete...)
+			torn[len(torn)-1] ^= 0xff
+			return torn
+		}},
+		{"garbage length", func(complete []byte) []byte {
+			torn := append([]byte(nil), complete...)
+			binary.LittleEndian.PutUint32(torn, maxRecordSize+1)
+			return torn
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := openJournal(t, dir, 100)
+			coord := types.NewBoardCoordinate(7, 7)
+			board := testBoard(1)
+			for i := uint16(0); i < 3; i++ {
+				playMove(board, 200+i)
+				if err := s.Save(coord, board); err != nil {
+					t.Fatal(err)
+				}
+			}
+			path := s.journalPath(s.generation)
+			crash(s)
+
+			// Frame the next record as Save would, then tear it
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lost := testBoard(1)
+			*lost = *board
+			playMove(lost, 300)
+			s = openJournal(t, dir, 100)
+			if err := s.Save(coord, lost); err != nil {
+				t.Fatal(err)
+			}
+			crash(s)
+			after, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			torn := append(before, tt.torn(after[len(before):])...)
+			if err := os.WriteFile(path, torn, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			s = openJournal(t, dir, 100)
+			got, err := s.Load(coord)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSameBoard(t, got, board)
+			if info, err := os.Stat(path); err != nil || info.Size() != int64(len(before)) {
+				t.Fatalf("journal not cut back to its complete records: %v", err)
+			}
+
+			// New records follow the last complete one
+			playMove(board, 301)
+			if err := s.Save(coord, board); err != nil {
+				t.Fatal(err)
+			}
+			crash(s)
+			s = openJournal(t, dir, 100)
+			defer s.Close()
+			got, err = s.Load(coord)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertSameBoard(t, got, board)
+		})
+	}
+}
+
+func TestJournalStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s := openJournal(t, dir, 3)
+	coord := types.NewBoardCoordinate(3, 3)
+	board := testBoard(1)
+	for i := uint16(0); i < 7; i++ {
+		playMove(board, i)
+		if err := s.Save(coord, board); err != nil {
+			t.Fatal(err)
+		}
+		s.compactions.Wait()
+	}
+	if s.generation != 2 || s.records != 1 {
+		t.Fatalf("generation %d with %d records, want 2 with 1", s.generation, s.records)
+	}
+	crash(s)
+
+	s = openJournal(t, dir, 3)
+	defer s.Close()
+	got, err := s.Load(coord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameBoard(t, got, board)
+}
+
+// When the next journal cannot be created the snapshot is not written and
+// the store keeps appending to the current journal
+func TestJournalStoreSnapshotOpenFails(t *testing.T) {
+	dir := t.TempDir()
+	s := openJournal(t, dir, 2)
+	if err := os.Mkdir(s.journalPath(1), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	coord := types.NewBoardCoordinate(4, 4)
+	board := testBoard(1)
+	for i := uint16(0); i < 5; i++ {
+		playMove(board, i)
+		if err := s.Save(coord, board); err != nil {
+			t.Fatalf("Save %d: %v", i, err)
+		}
+	}
+	if s.generation != 0 {
+		t.Fatalf("generation %d, want 0", s.generation)
+	}
+	if _, err := os.Stat(s.snapshotPath()); !os.IsNotExist(err) {
+		t.Fatalf("snapshot written without its journal: %v", err)
+	}
+	crash(s)
+
+	s = openJournal(t, dir, 2)
+	defer s.Close()
+	got, err := s.Load(coord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameBoard(t, got, board)
+}
+
+// Concurrent saves share syncs and every one of them survives a crash
+func TestJournalStoreConcurrentSaves(t *testing.T) {
+	dir := t.TempDir()
+	s := openJournal(t, dir, 50)
+
+	const boards, moves = 8, 20
+	var wg sync.WaitGroup
+	want := make([]*types.BoardState, boards)
+	for i := range want {
+		want[i] = testBoard(1)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			coord := types.NewBoardCoordinate(uint16(i), 0)
+			for m := uint16(0); m < moves; m++ {
+				playMove(want[i], m)
+				if err := s.Save(coord, want[i]); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	crash(s)
+
+	s = openJournal(t, dir, 50)
+	defer s.Close()
+	got, err := s.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, board := range want {
+		assertSameBoard(t, got[types.NewBoardCoordinate(uint16(i), 0)], board)
+	}
+}
+
+// Saves go on while snapshots are written in the background. Each board
+// is read back from disk, and each snapshot replaces the one before.
+func TestJournalStoreCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s := openJournal(t, dir, 4)
+
+	want := make(map[types.BoardCoordinate]*types.BoardState)
+	for i := uint16(0); i < 30; i++ {
+		coord := types.NewBoardCoordinate(i%3, 9)
+		if want[coord] == nil {
+			want[coord] = testBoard(1)
+		}
+		playMove(want[coord], i)
+		if err := s.Save(coord, want[coord]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.compactions.Wait()
+	for coord, board := range want {
+		got, err := s.Load(coord)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertSameBoard(t, got, board)
+	}
+	if snapshots, _ := filepath.Glob(filepath.Join(dir, "snapshot-*.dat")); len(snapshots) != 1 {
+		t.Errorf("snapshot files %v, want only the latest", snapshots)
+	}
+	crash(s)
+
+	s = openJournal(t, dir, 4)
+	defer s.Close()
+	boards, err := s.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for coord, board := range want {
+		assertSameBoard(t, boards[coord], board)
+	}
+}
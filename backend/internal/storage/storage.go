@@ -0,0 +1,51 @@
+// Package storage persists board states so games survive restarts and
+// crashes.
+package storage
+
+import (
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// Store saves and restores board states. Implementations must make Save
+// durable before returning: once it succeeds the board must survive a
+// crash of the process.
+type Store interface {
+	// LoadAll returns every board saved so far
+	LoadAll() (map[types.BoardCoordinate]*types.BoardState, error)
+
+	// Coords lists the boards saved so far, without loading them
+	Coords() ([]types.BoardCoordinate, error)
+
+	// Load returns a single saved board, or nil if it was never saved
+	Load(coord types.BoardCoordinate) (*types.BoardState, error)
+
+	// Save durably records the current state of a board
+	Save(coord types.BoardCoordinate, state *types.BoardState) error
+
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// record is the persisted form of a board. The position history is not
+// part of the client JSON, so it is stored alongside.
+type record struct {
+	State   *types.BoardState    `json:"state"`
+	History []types.PositionHash `json:"history"`
+}
+
+func newRecord(state *types.BoardState) *record {
+	return &record{State: state, History: state.History}
+}
+
+// board restores the BoardState held by a decoded record
+func (r *record) board() *types.BoardState {
+	state := r.State
+	if state == nil {
+		state = &types.BoardState{}
+	}
+	state.History = r.History
+	if state.Moves == nil {
+		state.Moves = make([]types.Move, 0)
+	}
+	return state
+}
@@ -2,20 +2,33 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/one-million-go/backend/internal/cluster"
 	"github.com/one-million-go/backend/internal/hub"
+	"github.com/one-million-go/backend/internal/storage"
+	"github.com/one-million-go/backend/pkg/protocol"
+	"github.com/one-million-go/backend/pkg/rules"
+	"github.com/one-million-go/backend/pkg/types"
 
 	"github.com/gorilla/websocket"
 )
 
 var upgrader = websocket.Upgrader{
+	Subprotocols:      protocol.Subprotocols,
+	EnableCompression: true,
 	CheckOrigin: func(r *http.Request) bool {
 		// Allow connections from frontend (in production, restrict this)
 		return true
@@ -23,8 +36,82 @@ var upgrader = websocket.Upgrader{
 }
 
 func main() {
+	config := hub.DefaultConfig()
+	addr := flag.String("addr", ":8080", "address to serve HTTP and WebSocket clients on")
+	koRule := flag.String("ko-rule", string(config.KoRule), "ko rule for new boards: simple, positional-superko, situational-superko or natural-situational-superko")
+	scoring := flag.String("scoring", string(config.Scoring), "scoring for new boards: area or territory")
+	flag.Float64Var(&config.Komi, "komi", config.Komi, "komi for new boards")
+	dataDir := flag.String("data-dir", "data", "directory for persisted boards (empty keeps boards in memory only)")
+	storageKind := flag.String("storage", "journal", "storage format: journal (move journal with snapshots) or files (one file per board)")
+	snapshotEvery := flag.Int("snapshot-every", storage.DefaultSnapshotEvery, "journal records between snapshots")
+	flag.DurationVar(&config.EvictAfter, "evict-after", config.EvictAfter, "drop boards without moves for this long from memory (0 keeps all boards)")
+	flag.DurationVar(&config.ResumeGrace, "resume-grace", config.ResumeGrace, "how long a disconnected client may reconnect and keep its seats and subscriptions (0 disables resuming)")
+	flag.IntVar(&config.SlowClientDrop, "slow-client-drop", config.SlowClientDrop, "messages waiting for a client before board updates to it are dropped (0 drops them only at -slow-client-limit)")
+	flag.IntVar(&config.SlowClientLimit, "slow-client-limit", config.SlowClientLimit, "messages waiting for a client before it is disconnected")
+	flag.IntVar(&config.Shards, "shards", config.Shards, "goroutines sharing the boards and zone subscriptions between them")
+	nodeID := flag.String("node", "", "ID of this node in -cluster")
+	clusterSpec := flag.String("cluster", "", "every node of the cluster as id=host:port for the cluster bus, comma separated (empty runs a single node)")
+	members := flag.String("members", "", "IDs of the -cluster nodes owning the grid at first startup, comma separated (empty for all); POST /admin/cluster changes them while running, kept in -data-dir across restarts")
+	clusterSecret := flag.String("cluster-secret", os.Getenv("CLUSTER_SECRET"), "secret shared by every -cluster node, which peers must prove they know when connecting")
+	placement := flag.String("placement", "ring", "how zones are split between nodes: ring (consistent hashing, moves few zones when nodes change) or ranges (one band of rows per node)")
+	adminToken := flag.String("admin-token", os.Getenv("ADMIN_TOKEN"), "bearer token for /admin endpoints (disabled when empty)")
+	flag.Parse()
+
+	var ok bool
+	if config.KoRule, ok = rules.ParseKoRule(*koRule); !ok {
+		log.Fatalf("Unknown ko rule: %s", *koRule)
+	}
+	if config.Scoring, ok = rules.ParseScoring(*scoring); !ok {
+		log.Fatalf("Unknown scoring: %s", *scoring)
+	}
+
+	if *dataDir != "" {
+		store, err := openStore(*storageKind, *dataDir, *snapshotEvery)
+		if err != nil {
+			log.Fatalf("Failed to open storage: %v", err)
+		}
+		defer store.Close()
+		config.Store = store
+	}
+
+	var clusterAddrs map[cluster.NodeID]string
+	if *clusterSpec != "" {
+		nodes, addrs, err := cluster.ParseNodes(*clusterSpec)
+		if err != nil {
+			log.Fatalf("Invalid -cluster: %v", err)
+		}
+		bus, err := cluster.ListenTCP(cluster.NodeID(*nodeID), addrs, []byte(*clusterSecret))
+		if err != nil {
+			log.Fatalf("Failed to join cluster: %v", err)
+		}
+		defer bus.Close()
+		config.Bus = bus
+		clusterAddrs = addrs
+		if *dataDir != "" {
+			// Rebalances outlive a restart, which -members alone would undo
+			config.PlacementFile = filepath.Join(*dataDir, "placement.json")
+		}
+
+		if *members != "" {
+			if nodes, err = parseMembers(*members, addrs); err != nil {
+				log.Fatalf("Invalid -members: %v", err)
+			}
+		}
+		switch *placement {
+		case "ring":
+			config.Placement = cluster.NewRing(nodes)
+		case "ranges":
+			config.Placement = cluster.NewRanges(nodes)
+		default:
+			log.Fatalf("Unknown placement: %s", *placement)
+		}
+	}
+
 	// Initialize the game hub
-	gameHub := hub.NewGameHub()
+	gameHub, err := hub.NewGameHub(config)
+	if err != nil {
+		log.Fatalf("Failed to start game hub: %v", err)
+	}
 	go gameHub.Run()
 
 	// Setup HTTP routes
@@ -32,6 +119,20 @@ func main() {
 		handleWebSocket(gameHub, w, r)
 	})
 
+	http.HandleFunc("/boards/", func(w http.ResponseWriter, r *http.Request) {
+		handleExportSGF(gameHub, w, r)
+	})
+
+	http.HandleFunc("/admin/boards/", func(w http.ResponseWriter, r *http.Request) {
+		handleImportSGF(gameHub, *adminToken, w, r)
+	})
+
+	http.HandleFunc("/admin/cluster", func(w http.ResponseWriter, r *http.Request) {
+		handleRebalance(gameHub, clusterAddrs, *adminToken, w, r)
+	})
+
+	http.Handle("/metrics", gameHub.Metrics())
+
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Fprintf(w, `{"status":"ok","timestamp":%d}`, time.Now().Unix())
@@ -39,7 +140,7 @@ func main() {
 
 	// Create server
 	server := &http.Server{
-		Addr:         ":8080",
+		Addr:         *addr,
 		Handler:      nil,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
@@ -48,9 +149,14 @@ func main() {
 
 	// Start server in goroutine
 	go func() {
-		log.Printf("🚀 One Million Go backend starting on :8080")
-		log.Printf("WebSocket endpoint: ws://localhost:8080/ws")
-		log.Printf("Health check: http://localhost:8080/health")
+		host := *addr
+		if strings.HasPrefix(host, ":") {
+			host = "localhost" + host
+		}
+		log.Printf("🚀 One Million Go backend starting on %s", *addr)
+		log.Printf("WebSocket endpoint: ws://%s/ws", host)
+		log.Printf("Health check: http://%s/health", host)
+		log.Printf("Metrics: http://%s/metrics", host)
 
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed to start: %v", err)
@@ -75,6 +181,17 @@ func main() {
 	log.Println("✅ Server shutdown complete")
 }
 
+// openStore opens the board storage selected on the command line
+func openStore(kind, dir string, snapshotEvery int) (storage.Store, error) {
+	switch kind {
+	case "journal":
+		return storage.NewJournalStore(dir, snapshotEvery)
+	case "files":
+		return storage.NewFileStore(dir)
+	}
+	return nil, fmt.Errorf("unknown storage %q", kind)
+}
+
 func handleWebSocket(gameHub *hub.GameHub, w http.ResponseWriter, r *http.Request) {
 	// Upgrade HTTP connection to WebSocket
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -84,10 +201,145 @@ func handleWebSocket(gameHub *hub.GameHub, w http.ResponseWriter, r *http.Reques
 	}
 
 	// Create new client connection and register with hub
-	client := hub.NewClientConnection(conn, gameHub)
+	client := hub.NewClientConnection(conn, gameHub, offersCompression(r))
 	gameHub.Register <- client
 
 	// Start goroutines for reading and writing
 	go client.WritePump()
 	go client.ReadPump()
 }
+
+// offersCompression reports whether the client offered permessage-deflate,
+// which the upgrader then accepts
+func offersCompression(r *http.Request) bool {
+	for _, ext := range r.Header.Values("Sec-WebSocket-Extensions") {
+		if strings.Contains(ext, "permessage-deflate") {
+			return true
+		}
+	}
+	return false
+}
+
+// Largest SGF file accepted by the import endpoint
+const maxSGFSize = 1 << 20
+
+// parseBoardPath extracts the coordinate from a "{x}/{y}.sgf" path suffix
+func parseBoardPath(path, prefix string) (types.BoardCoordinate, bool) {
+	rest := strings.TrimPrefix(path, prefix)
+	if rest == path || !strings.HasSuffix(rest, ".sgf") {
+		return 0, false
+	}
+
+	parts := strings.Split(strings.TrimSuffix(rest, ".sgf"), "/")
+	if len(parts) != 2 {
+		return 0, false
+	}
+	x, errX := strconv.ParseUint(parts[0], 10, 16)
+	y, errY := strconv.ParseUint(parts[1], 10, 16)
+	if errX != nil || errY != nil || x >= types.GridSize || y >= types.GridSize {
+		return 0, false
+	}
+	return types.NewBoardCoordinate(uint16(x), uint16(y)), true
+}
+
+// handleExportSGF serves GET /boards/{x}/{y}.sgf
+func handleExportSGF(gameHub *hub.GameHub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	coord, ok := parseBoardPath(r.URL.Path, "/boards/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, exists := gameHub.ExportSGF(coord)
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-go-sgf")
+	w.Write(data)
+}
+
+// handleImportSGF serves POST /admin/boards/{x}/{y}.sgf
+func handleImportSGF(gameHub *hub.GameHub, adminToken string, w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(adminToken, w, r) {
+		return
+	}
+
+	coord, ok := parseBoardPath(r.URL.Path, "/admin/boards/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxSGFSize))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	if err := gameHub.ImportSGF(coord, data); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRebalance serves POST /admin/cluster, whose body lists the node
+// IDs of -cluster that should own the grid, comma separated
+func handleRebalance(gameHub *hub.GameHub, addrs map[cluster.NodeID]string, adminToken string, w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(adminToken, w, r) {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	nodes, err := parseMembers(strings.TrimSpace(string(body)), addrs)
+	if err == nil {
+		err = gameHub.Rebalance(nodes)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeAdmin checks the bearer token and method of an /admin request,
+// answering it when they are wrong
+func authorizeAdmin(adminToken string, w http.ResponseWriter, r *http.Request) bool {
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if adminToken == "" || subtle.ConstantTimeCompare([]byte(auth), []byte(adminToken)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// parseMembers reads the -members list, which must name nodes of the
+// cluster
+func parseMembers(spec string, addrs map[cluster.NodeID]string) ([]cluster.NodeID, error) {
+	nodes := make([]cluster.NodeID, 0)
+	for _, id := range strings.Split(spec, ",") {
+		id := cluster.NodeID(strings.TrimSpace(id))
+		if _, ok := addrs[id]; !ok {
+			return nil, fmt.Errorf("node %q is not part of -cluster", id)
+		}
+		nodes = append(nodes, id)
+	}
+	return nodes, nil
+}
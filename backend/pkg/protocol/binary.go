@@ -0,0 +1,463 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// Binary frames hold, integers little endian:
+//
+//	type     uint8   index of the message type in messageTypes
+//	id       string
+//	time     varint  Unix seconds
+//	payload          the type's payload struct from types.NewPayload
+//
+// Payload fields are written in declaration order by kind: unsigned
+// integers at their fixed size, signed integers as zig-zag varints,
+// floats as IEEE 754 bits, bools as one byte, strings and slices behind a
+// uvarint length, byte arrays and slices raw, pointers behind a presence
+// byte and maps as a uvarint count of key/value pairs in key order.
+// Fields tagged `wire:"-"` are left out.
+//
+// A BoardX field directly followed by BoardY is written as their packed
+// BoardCoordinate, a uint32. Positions take two bytes and stone bitfields
+// their 46 raw bytes.
+
+// messageTypes numbers the message types on the wire. Only append to it;
+// the index is the type's code.
+var messageTypes = []types.MessageType{
+	types.MsgSendMove,
+	types.MsgFetchBoard,
+	types.MsgFetchRegion,
+	types.MsgSubscribeRegion,
+	types.MsgUnsubscribe,
+	types.MsgSetRuleset,
+	types.MsgClaimSeat,
+	types.MsgLeaveSeat,
+	types.MsgPass,
+	types.MsgResign,
+	types.MsgAcceptResult,
+	types.MsgMarkDead,
+	types.MsgFetchHistory,
+	types.MsgPing,
+	types.MsgWelcome,
+	types.MsgMoveResult,
+	types.MsgBoardState,
+	types.MsgBoardUpdate,
+	types.MsgBoardDelta,
+	types.MsgBoardDeltas,
+	types.MsgRegionData,
+	types.MsgGamePhase,
+	types.MsgSubscribed,
+	types.MsgHistory,
+	types.MsgError,
+	types.MsgPong,
+	types.MsgHello,
+}
+
+var messageCodes = func() map[types.MessageType]byte {
+	codes := make(map[types.MessageType]byte, len(messageTypes))
+	for i, t := range messageTypes {
+		codes[t] = byte(i)
+	}
+	return codes
+}()
+
+var errShortFrame = errors.New("binary frame truncated")
+
+// BinaryCodec sends messages as compact binary frames
+type BinaryCodec struct{}
+
+func (BinaryCodec) Binary() bool { return true }
+
+func (BinaryCodec) Encode(msg *types.Message) ([]byte, error) {
+	code, ok := messageCodes[msg.Type]
+	if !ok {
+		return nil, fmt.Errorf("message type %q has no binary code", msg.Type)
+	}
+
+	e := &encoder{buf: make([]byte, 0, 64)}
+	e.buf = append(e.buf, code)
+	e.string(msg.ID)
+	e.buf = binary.AppendVarint(e.buf, msg.Timestamp)
+
+	payload := types.NewPayload(msg.Type)
+	if payload == nil {
+		return e.buf, nil
+	}
+
+	want := reflect.TypeOf(payload).Elem()
+	v := reflect.ValueOf(msg.Data)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil, fmt.Errorf("%s message without payload", msg.Type)
+	}
+	if v.Type() != want {
+		return nil, fmt.Errorf("%s payload is %s, want %s", msg.Type, v.Type(), want)
+	}
+	if err := e.value(v); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// Decode reads a message and its typed, validated payload
+func (BinaryCodec) Decode(data []byte) (*types.Message, error) {
+	d := &decoder{buf: data}
+	code, err := d.byte()
+	if err != nil {
+		return nil, &RequestError{Err: err}
+	}
+	if int(code) >= len(messageTypes) {
+		return nil, &RequestError{Err: fmt.Errorf("unknown message code %d", code)}
+	}
+
+	msg := &types.Message{Type: messageTypes[code]}
+	if msg.ID, err = d.string(); err != nil {
+		return nil, &RequestError{Err: err}
+	}
+	if msg.Timestamp, err = d.varint(); err != nil {
+		return nil, &RequestError{Err: err}
+	}
+
+	if payload := types.NewPayload(msg.Type); payload != nil {
+		if err := d.value(reflect.ValueOf(payload).Elem()); err != nil {
+			return nil, &RequestError{ID: msg.ID, Type: msg.Type, Err: err}
+		}
+		msg.Data = payload
+	}
+	if len(d.buf) > 0 {
+		err := fmt.Errorf("%d trailing bytes after payload", len(d.buf))
+		return nil, &RequestError{ID: msg.ID, Type: msg.Type, Err: err}
+	}
+
+	if err := validate(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// skipField reports whether a struct field stays off the wire
+func skipField(f reflect.StructField) bool {
+	return !f.IsExported() || f.Tag.Get("wire") == "-"
+}
+
+// coordinatePair reports whether field i and the next are a BoardX/BoardY
+// pair sent as one packed BoardCoordinate
+func coordinatePair(t reflect.Type, i int) bool {
+	if i+1 >= t.NumField() {
+		return false
+	}
+	x, y := t.Field(i), t.Field(i+1)
+	return x.Name == "BoardX" && y.Name == "BoardY" &&
+		x.Type.Kind() == reflect.Uint16 && y.Type.Kind() == reflect.Uint16 &&
+		!skipField(x) && !skipField(y)
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) string(s string) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) value(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	case reflect.Uint8:
+		e.buf = append(e.buf, byte(v.Uint()))
+	case reflect.Uint16:
+		e.buf = binary.LittleEndian.AppendUint16(e.buf, uint16(v.Uint()))
+	case reflect.Uint32:
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(v.Uint()))
+	case reflect.Uint64:
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, v.Uint())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.buf = binary.AppendVarint(e.buf, v.Int())
+	case reflect.Float64:
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.string(v.String())
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			for i := 0; i < v.Len(); i++ {
+				e.buf = append(e.buf, byte(v.Index(i).Uint()))
+			}
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := e.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		e.buf = binary.AppendUvarint(e.buf, uint64(v.Len()))
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.buf = append(e.buf, v.Bytes()...)
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := e.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0)
+			return nil
+		}
+		e.buf = append(e.buf, 1)
+		return e.value(v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if skipField(t.Field(i)) {
+				continue
+			}
+			if coordinatePair(t, i) {
+				coord := types.NewBoardCoordinate(uint16(v.Field(i).Uint()), uint16(v.Field(i+1).Uint()))
+				e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(coord))
+				i++
+				continue
+			}
+			if err := e.value(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		if err := sortKeys(keys); err != nil {
+			return err
+		}
+		e.buf = binary.AppendUvarint(e.buf, uint64(len(keys)))
+		for _, key := range keys {
+			if err := e.value(key); err != nil {
+				return err
+			}
+			if err := e.value(v.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cannot encode %s", v.Type())
+	}
+	return nil
+}
+
+// sortKeys orders map keys so equal maps encode to equal bytes
+func sortKeys(keys []reflect.Value) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	switch keys[0].Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Uint() < keys[j].Uint() })
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Int() < keys[j].Int() })
+	case reflect.String:
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	default:
+		return fmt.Errorf("cannot encode map key %s", keys[0].Type())
+	}
+	return nil
+}
+
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || n > len(d.buf) {
+		return nil, errShortFrame
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+func (d *decoder) byte() (byte, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (d *decoder) varint() (int64, error) {
+	x, n := binary.Varint(d.buf)
+	if n <= 0 {
+		return 0, errShortFrame
+	}
+	d.buf = d.buf[n:]
+	return x, nil
+}
+
+// length reads a uvarint length. Every element takes at least one byte,
+// so lengths beyond the rest of the frame are rejected before allocating.
+func (d *decoder) length() (int, error) {
+	x, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		return 0, errShortFrame
+	}
+	d.buf = d.buf[n:]
+	if x > uint64(len(d.buf)) {
+		return 0, errShortFrame
+	}
+	return int(x), nil
+}
+
+func (d *decoder) string() (string, error) {
+	n, err := d.length()
+	if err != nil {
+		return "", err
+	}
+	b, err := d.next(n)
+	return string(b), err
+}
+
+func (d *decoder) value(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := d.byte()
+		if err != nil {
+			return err
+		}
+		v.SetBool(b != 0)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		b, err := d.next(int(v.Type().Size()))
+		if err != nil {
+			return err
+		}
+		switch len(b) {
+		case 1:
+			v.SetUint(uint64(b[0]))
+		case 2:
+			v.SetUint(uint64(binary.LittleEndian.Uint16(b)))
+		case 4:
+			v.SetUint(uint64(binary.LittleEndian.Uint32(b)))
+		default:
+			v.SetUint(binary.LittleEndian.Uint64(b))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := d.varint()
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(x) {
+			return fmt.Errorf("%d overflows %s", x, v.Type())
+		}
+		v.SetInt(x)
+	case reflect.Float64:
+		b, err := d.next(8)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+	case reflect.String:
+		s, err := d.string()
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := d.next(v.Len())
+			if err != nil {
+				return err
+			}
+			reflect.Copy(v, reflect.ValueOf(b))
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := d.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := d.next(n)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		for i := 0; i < n; i++ {
+			if err := d.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr:
+		present, err := d.byte()
+		if err != nil {
+			return err
+		}
+		if present == 0 {
+			return nil
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+		return d.value(v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if skipField(t.Field(i)) {
+				continue
+			}
+			if coordinatePair(t, i) {
+				b, err := d.next(4)
+				if err != nil {
+					return err
+				}
+				x, y := types.BoardCoordinate(binary.LittleEndian.Uint32(b)).Unpack()
+				v.Field(i).SetUint(uint64(x))
+				v.Field(i + 1).SetUint(uint64(y))
+				i++
+				continue
+			}
+			if err := d.value(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		t := v.Type()
+		v.Set(reflect.MakeMapWithSize(t, n))
+		for i := 0; i < n; i++ {
+			key := reflect.New(t.Key()).Elem()
+			if err := d.value(key); err != nil {
+				return err
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := d.value(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+	default:
+		return fmt.Errorf("cannot decode %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// fullBoard returns a board with every wire field set, slices non-empty
+// since the decoder cannot tell a nil slice from an empty one
+func fullBoard() *types.BoardState {
+	state := &types.BoardState{
+		Version:       42,
+		MoveCount:     3,
+		LastMove:      1700000000,
+		CurrentPlayer: 1,
+		GamePhase:     types.PhaseScoring,
+		Activity:      9,
+		BlackCaptures: 2,
+		WhiteCaptures: 1,
+		KoRule:        "positional-superko",
+		Passes:        2,
+		SetupBlack:    []uint16{60, 300},
+		SetupWhite:    []uint16{72},
+		Komi:          6.5,
+		Scoring:       "territory",
+		Result:        "B+3.5",
+		Score:         &types.ScoreData{AreaBlack: 181, AreaWhite: 186.5, TerritoryBlack: 70, TerritoryWhite: 66.5},
+		DeadStones:    []uint16{5, 6},
+		BlackAccepted: true,
+		BlackPlayer:   "alice",
+		WhitePlayer:   "bob",
+		Moves: []types.Move{
+			{Position: 10, Player: 0, MoveNum: 1, X: 10, Y: 0},
+			{Position: types.PassPosition, Player: 1, MoveNum: 2},
+			{Position: 360, Player: 0, MoveNum: 3, X: 18, Y: 18, Captures: 4},
+		},
+	}
+	state.SetPoint(10, types.PointBlack)
+	state.SetPoint(360, types.PointBlack)
+	state.SetPoint(72, types.PointWhite)
+	return state
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	since := uint32(17)
+	tests := []*types.Message{
+		{ID: "m1", Type: types.MsgSendMove, Timestamp: 1700000000, Data: &types.MoveRequestData{BoardX: 999, BoardY: 1, Position: 180, Player: "black"}},
+		{ID: "f1", Type: types.MsgFetchBoard, Timestamp: -5, Data: &types.FetchBoardData{BoardX: 3, BoardY: 4, SinceVersion: &since}},
+		{ID: "f2", Type: types.MsgFetchBoard, Data: &types.FetchBoardData{BoardX: 3, BoardY: 4}},
+		{ID: "h1", Type: types.MsgFetchHistory, Data: &types.FetchHistoryData{BoardX: 1, BoardY: 2, Offset: 40, Limit: 20}},
+		{ID: "r1", Type: types.MsgFetchRegion, Data: &types.FetchRegionData{StartX: 100, StartY: 200, Width: 10, Height: 5}},
+		{ID: "u1", Type: types.MsgUnsubscribe, Data: &types.UnsubscribeRegionData{Zones: []types.ZoneID{0, 7, 3968}}},
+		{ID: "p1", Type: types.MsgPing},
+		{Type: types.MsgBoardState, Data: fullBoard()},
+		{Type: types.MsgBoardDelta, Data: &types.BoardDelta{
+			BoardX: 5, BoardY: 6, Version: 8, Move: &types.Move{Position: 3, MoveNum: 8, X: 3}, Captured: []uint16{4, 22},
+			MoveCount: 8, CurrentPlayer: 1,
+		}},
+		{Type: types.MsgRegionData, Data: &types.RegionDataResponse{
+			StartX: 5, StartY: 6, Width: 2, Height: 1,
+			Boards: map[types.BoardCoordinate]*types.BoardState{
+				types.NewBoardCoordinate(5, 6): fullBoard(),
+				types.NewBoardCoordinate(6, 6): fullBoard(),
+			},
+		}},
+		{Type: types.MsgError, Data: &types.ErrorData{Code: "INVALID_REQUEST", Message: "bad", Fields: []types.FieldError{{Field: "width", Message: "too wide"}}}},
+	}
+
+	var codec BinaryCodec
+	for _, msg := range tests {
+		t.Run(string(msg.Type), func(t *testing.T) {
+			data, err := codec.Encode(msg)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			got, err := codec.Decode(data)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, msg) {
+				t.Errorf("round trip = %+v, want %+v", got, msg)
+			}
+		})
+	}
+}
+
+// A BoardX/BoardY pair goes on the wire as the packed BoardCoordinate
+func TestBinaryPackedCoordinate(t *testing.T) {
+	msg := &types.Message{Type: types.MsgSendMove, Data: &types.MoveRequestData{BoardX: 0x0102, BoardY: 0x0304, Position: 5}}
+	data, err := BinaryCodec{}.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Code, empty ID, zero timestamp, then the payload
+	payload := data[3:]
+	if got, want := binary.LittleEndian.Uint32(payload), uint32(types.NewBoardCoordinate(0x0102, 0x0304)); got != want {
+		t.Errorf("coordinate on the wire = %#x, want %#x", got, want)
+	}
+	if got := binary.LittleEndian.Uint16(payload[4:]); got != 5 {
+		t.Errorf("position on the wire = %d, want 5", got)
+	}
+}
+
+func TestBinaryDecodeErrors(t *testing.T) {
+	valid, err := BinaryCodec{}.Encode(&types.Message{ID: "m", Type: types.MsgSendMove, Data: &types.MoveRequestData{BoardX: 1, BoardY: 2, Position: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"unknown code", []byte{255, 0, 0}},
+		{"truncated payload", valid[:len(valid)-1]},
+		{"trailing bytes", append(append([]byte(nil), valid...), 0)},
+		{"string longer than frame", []byte{0, 50, 'a'}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := BinaryCodec{}.Decode(tt.data)
+			var reqErr *RequestError
+			if !errors.As(err, &reqErr) {
+				t.Fatalf("Decode error = %v, want a RequestError", err)
+			}
+		})
+	}
+}
+
+func TestBinaryEncodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *types.Message
+	}{
+		{"unknown type", &types.Message{Type: "NOPE"}},
+		{"missing payload", &types.Message{Type: types.MsgSendMove}},
+		{"wrong payload", &types.Message{Type: types.MsgSendMove, Data: &types.FetchBoardData{}}},
+	}
+
+	for _, tt := range tests {
+		if _, err := (BinaryCodec{}).Encode(tt.msg); err == nil {
+			t.Errorf("%s: Encode succeeded", tt.name)
+		}
+	}
+}
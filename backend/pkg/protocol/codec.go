@@ -0,0 +1,137 @@
+// Package protocol encodes WebSocket messages. Clients choose an encoding
+// with the WebSocket subprotocol; both encode the payload structs of
+// package types.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// WebSocket subprotocols selecting an encoding. Clients that request
+// none get JSON. The binary subprotocol's version goes up with every
+// change to the binary layout, so clients built for another layout fall
+// back to JSON; TestBinaryLayout fails until it does.
+const (
+	SubprotocolJSON   = "omg.json.v1"
+	SubprotocolBinary = "omg.binary.v2"
+)
+
+// Subprotocols lists the supported subprotocols in order of preference
+var Subprotocols = []string{SubprotocolBinary, SubprotocolJSON}
+
+// Codec converts messages to and from WebSocket frames
+type Codec interface {
+	Encode(msg *types.Message) ([]byte, error)
+	Decode(data []byte) (*types.Message, error)
+
+	// Binary reports whether frames are sent as binary rather than text
+	Binary() bool
+}
+
+// ForSubprotocol returns the codec for a negotiated subprotocol
+func ForSubprotocol(subprotocol string) Codec {
+	if subprotocol == SubprotocolBinary {
+		return BinaryCodec{}
+	}
+	return JSONCodec{}
+}
+
+// RequestError reports a message that could not be decoded or whose
+// payload failed validation. ID and Type are set once the envelope has
+// been read.
+type RequestError struct {
+	ID     string
+	Type   types.MessageType
+	Fields []types.FieldError
+	Err    error
+}
+
+func (e *RequestError) Error() string {
+	if e.Type == "" {
+		return "invalid message: " + e.Err.Error()
+	}
+	return fmt.Sprintf("invalid %s request: %v", e.Type, e.Err)
+}
+
+func (e *RequestError) Unwrap() error { return e.Err }
+
+// validate runs the checks of a decoded payload
+func validate(msg *types.Message) error {
+	v, ok := msg.Data.(types.Validator)
+	if !ok {
+		return nil
+	}
+	if err := v.Validate(); err != nil {
+		reqErr := &RequestError{ID: msg.ID, Type: msg.Type, Err: err}
+		var fields types.ValidationError
+		if errors.As(err, &fields) {
+			reqErr.Fields = fields
+		}
+		return reqErr
+	}
+	return nil
+}
+
+// envelope is a JSON message whose payload is decoded once its type is
+// known
+type envelope struct {
+	ID        string            `json:"id"`
+	Type      types.MessageType `json:"type"`
+	Timestamp int64             `json:"timestamp"`
+	Data      json.RawMessage   `json:"data"`
+}
+
+// JSONCodec sends messages as JSON text frames
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(msg *types.Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+// Decode reads a message and its typed, validated payload. Messages of
+// unknown types are returned without payload; those of a type with a
+// payload must carry one.
+func (JSONCodec) Decode(data []byte) (*types.Message, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, &RequestError{Err: err}
+	}
+
+	msg := &types.Message{ID: env.ID, Type: env.Type, Timestamp: env.Timestamp}
+	payload := types.NewPayload(env.Type)
+	if payload == nil {
+		return msg, nil
+	}
+
+	if len(env.Data) == 0 || string(env.Data) == "null" {
+		return nil, &RequestError{
+			ID:     env.ID,
+			Type:   env.Type,
+			Fields: []types.FieldError{{Field: "data", Message: "is required"}},
+			Err:    errors.New("data is required"),
+		}
+	}
+	if err := json.Unmarshal(env.Data, payload); err != nil {
+		reqErr := &RequestError{ID: env.ID, Type: env.Type, Err: err}
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			reqErr.Fields = []types.FieldError{{
+				Field:   typeErr.Field,
+				Message: fmt.Sprintf("got %s, want %s", typeErr.Value, typeErr.Type),
+			}}
+		}
+		return nil, reqErr
+	}
+	msg.Data = payload
+
+	if err := validate(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (JSONCodec) Binary() bool { return false }
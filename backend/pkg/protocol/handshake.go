@@ -0,0 +1,22 @@
+package protocol
+
+// Protocol versions. Clients announce theirs in HELLO; clients that send
+// anything else first speak LegacyVersion.
+const (
+	Version       = 2
+	MinVersion    = 1
+	LegacyVersion = 1
+)
+
+// Features a client can declare in HELLO
+const (
+	FeatureBinary      = "binary"      // Binary frames, see SubprotocolBinary
+	FeatureCompression = "compression" // permessage-deflate compressed frames
+	FeatureDeltas      = "deltas"      // BOARD_DELTA instead of BOARD_UPDATE with the full board
+)
+
+// WebSocket close codes sent when a connection is refused or dropped
+const (
+	CloseUnsupportedVersion = 4001
+	CloseTooSlow            = 4002 // Too many messages waited for the client
+)
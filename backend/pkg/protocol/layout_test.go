@@ -0,0 +1,126 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+var writeLayout = flag.Bool("write-layout", false, "write the golden frames of a new SubprotocolBinary")
+
+// fill sets every wire field of v to a value of its own, with two
+// elements in each slice and map, so no field goes unnoticed on the wire
+func fill(v reflect.Value, next *int) {
+	*next++
+	n := *next
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(n))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(-n))
+	case reflect.Float64:
+		v.SetFloat(float64(n) + 0.5)
+	case reflect.String:
+		v.SetString(fmt.Sprintf("s%d", n))
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fill(v.Index(i), next)
+		}
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 2, 2))
+		for i := 0; i < v.Len(); i++ {
+			fill(v.Index(i), next)
+		}
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem(), next)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !skipField(v.Type().Field(i)) {
+				fill(v.Field(i), next)
+			}
+		}
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		for i := 0; i < 2; i++ {
+			key, value := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+			fill(key, next)
+			fill(value, next)
+			v.SetMapIndex(key, value)
+		}
+	}
+}
+
+// goldenFrames encodes a filled message of every type
+func goldenFrames(t *testing.T) map[types.MessageType]string {
+	frames := make(map[types.MessageType]string, len(messageTypes))
+	for _, msgType := range messageTypes {
+		msg := &types.Message{ID: "id", Type: msgType, Timestamp: 1700000000}
+		if payload := types.NewPayload(msgType); payload != nil {
+			next := 0
+			fill(reflect.ValueOf(payload).Elem(), &next)
+			msg.Data = payload
+		}
+		data, err := BinaryCodec{}.Encode(msg)
+		if err != nil {
+			t.Fatalf("Encode %s: %v", msgType, err)
+		}
+		frames[msgType] = hex.EncodeToString(data)
+	}
+	return frames
+}
+
+// TestBinaryLayout fails on any change to the bytes a message of any type
+// is encoded to. Such a change needs a new SubprotocolBinary, whose
+// frames -write-layout then writes to testdata; the frames of a version
+// once written are never rewritten.
+func TestBinaryLayout(t *testing.T) {
+	frames := goldenFrames(t)
+	path := filepath.Join("testdata", SubprotocolBinary+".golden")
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && *writeLayout {
+		var b strings.Builder
+		for _, msgType := range messageTypes {
+			fmt.Fprintf(&b, "%s %s\n", msgType, frames[msgType])
+		}
+		if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("no golden frames for %s: %v", SubprotocolBinary, err)
+	}
+	defer f.Close()
+
+	golden := make(map[types.MessageType]string)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if msgType, frame, ok := strings.Cut(scanner.Text(), " "); ok {
+			golden[types.MessageType(msgType)] = frame
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msgType := range messageTypes {
+		if frames[msgType] != golden[msgType] {
+			t.Errorf("%s frame changed under %s: bump SubprotocolBinary and run the test with -write-layout\n got %s\nwant %s",
+				msgType, SubprotocolBinary, frames[msgType], golden[msgType])
+		}
+	}
+}
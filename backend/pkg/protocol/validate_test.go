@@ -0,0 +1,73 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+func TestJSONDecodeRequiresPayload(t *testing.T) {
+	for _, msgType := range messageTypes {
+		if types.NewPayload(msgType) == nil {
+			continue
+		}
+		for name, data := range map[string]string{
+			"missing": fmt.Sprintf(`{"id":"m","type":%q}`, msgType),
+			"null":    fmt.Sprintf(`{"id":"m","type":%q,"data":null}`, msgType),
+		} {
+			t.Run(string(msgType)+"/"+name, func(t *testing.T) {
+				_, err := JSONCodec{}.Decode([]byte(data))
+				var reqErr *RequestError
+				if !errors.As(err, &reqErr) {
+					t.Fatalf("Decode error = %v, want a RequestError", err)
+				}
+				if reqErr.ID != "m" || reqErr.Type != msgType {
+					t.Errorf("error for %q %s, want m %s", reqErr.ID, reqErr.Type, msgType)
+				}
+				if len(reqErr.Fields) != 1 || reqErr.Fields[0].Field != "data" {
+					t.Errorf("invalid fields %+v, want data", reqErr.Fields)
+				}
+			})
+		}
+	}
+}
+
+func TestJSONDecodeValidates(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		field string // Invalid field, empty when the message is valid
+	}{
+		{"ping without payload", `{"id":"p","type":"PING"}`, ""},
+		{"empty payload", `{"id":"f","type":"FETCH_BOARD","data":{}}`, ""},
+		{"valid move", `{"id":"m","type":"SEND_MOVE","data":{"boardX":1,"boardY":2,"position":60}}`, ""},
+		{"position off the board", `{"id":"m","type":"SEND_MOVE","data":{"boardX":1,"boardY":2,"position":361}}`, "position"},
+		{"wrong field type", `{"id":"m","type":"SEND_MOVE","data":{"boardX":"one"}}`, "boardX"},
+		{"hello without version", `{"id":"h","type":"HELLO","data":{"features":[]}}`, "protocolVersion"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := JSONCodec{}.Decode([]byte(tt.data))
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("Decode: %v", err)
+				}
+				if types.NewPayload(msg.Type) != nil && msg.Data == nil {
+					t.Error("payload not decoded")
+				}
+				return
+			}
+
+			var reqErr *RequestError
+			if !errors.As(err, &reqErr) {
+				t.Fatalf("Decode error = %v, want a RequestError", err)
+			}
+			if len(reqErr.Fields) == 0 || reqErr.Fields[0].Field != tt.field {
+				t.Errorf("invalid fields %+v, want %s", reqErr.Fields, tt.field)
+			}
+		})
+	}
+}
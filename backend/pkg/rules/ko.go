@@ -0,0 +1,74 @@
+package rules
+
+import "github.com/one-million-go/backend/pkg/types"
+
+// KoRule selects how repeated positions are forbidden. The values match
+// the frontend Ruleset so games behave the same on both sides.
+type KoRule string
+
+const (
+	KoSimple                    KoRule = "simple"
+	KoPositionalSuperko         KoRule = "positional-superko"
+	KoSituationalSuperko        KoRule = "situational-superko"
+	KoNaturalSituationalSuperko KoRule = "natural-situational-superko"
+)
+
+// DefaultKoRule is used when no ko rule is configured
+const DefaultKoRule = KoSimple
+
+// ErrKo is returned when a move would repeat a forbidden position
+var ErrKo = &MoveError{Code: "KO", Message: "Move would repeat a previous position"}
+
+// ParseKoRule validates a ko rule name
+func ParseKoRule(s string) (KoRule, bool) {
+	switch rule := KoRule(s); rule {
+	case KoSimple, KoPositionalSuperko, KoSituationalSuperko, KoNaturalSituationalSuperko:
+		return rule, true
+	}
+	return "", false
+}
+
+// InitialHistory returns the position history of an empty board. The
+// empty position counts as having been produced by white so that black
+// is the player to move.
+func InitialHistory() []types.PositionHash {
+	return []types.PositionHash{{Hash: 0, Player: 1}}
+}
+
+// CheckKo reports whether reaching a position with hash newHash by a move
+// of color mover violates the ko rule, given the positions reached so far
+func (r KoRule) CheckKo(newHash uint64, mover Color, history []types.PositionHash) error {
+	player := byte(mover - 1)
+
+	switch r {
+	case KoPositionalSuperko:
+		for _, p := range history {
+			if p.Hash == newHash {
+				return ErrKo
+			}
+		}
+
+	case KoSituationalSuperko:
+		for _, p := range history {
+			if p.Hash == newHash && p.Player == player {
+				return ErrKo
+			}
+		}
+
+	case KoNaturalSituationalSuperko:
+		for _, p := range history {
+			if p.Hash == newHash && p.Player == player && !p.Pass {
+				return ErrKo
+			}
+		}
+
+	default:
+		// Simple ko only forbids recreating the position before the
+		// opponent's last move
+		if len(history) >= 2 && history[len(history)-2].Hash == newHash {
+			return ErrKo
+		}
+	}
+
+	return nil
+}
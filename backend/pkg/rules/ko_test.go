@@ -0,0 +1,152 @@
+package rules
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+func TestCheckKo(t *testing.T) {
+	// Black is to move after white's last move reached 500
+	history := []types.PositionHash{
+		{Hash: 100, Player: 1},
+		{Hash: 200, Player: 0},
+		{Hash: 300, Player: 1},
+		{Hash: 400, Player: 0, Pass: true},
+		{Hash: 500, Player: 1},
+	}
+
+	tests := []struct {
+		rule    KoRule
+		newHash uint64
+		ko      bool
+	}{
+		{KoSimple, 400, true},
+		{KoSimple, 100, false},
+		{KoSimple, 200, false},
+		{KoSimple, 999, false},
+
+		{KoPositionalSuperko, 100, true},
+		{KoPositionalSuperko, 200, true},
+		{KoPositionalSuperko, 400, true},
+		{KoPositionalSuperko, 999, false},
+
+		// Only positions black produced count against black
+		{KoSituationalSuperko, 200, true},
+		{KoSituationalSuperko, 400, true},
+		{KoSituationalSuperko, 100, false},
+		{KoSituationalSuperko, 300, false},
+
+		// Positions black produced by passing do not count either
+		{KoNaturalSituationalSuperko, 200, true},
+		{KoNaturalSituationalSuperko, 400, false},
+		{KoNaturalSituationalSuperko, 100, false},
+	}
+
+	for _, tt := range tests {
+		err := tt.rule.CheckKo(tt.newHash, Black, history)
+		if got := errors.Is(err, ErrKo); got != tt.ko || (err != nil && !got) {
+			t.Errorf("%s: CheckKo(%d) = %v, want ko %v", tt.rule, tt.newHash, err, tt.ko)
+		}
+	}
+}
+
+func TestCheckKoShortHistory(t *testing.T) {
+	for _, rule := range []KoRule{KoSimple, KoPositionalSuperko, KoSituationalSuperko, KoNaturalSituationalSuperko} {
+		if err := rule.CheckKo(1, Black, InitialHistory()); err != nil {
+			t.Errorf("%s: first move rejected: %v", rule, err)
+		}
+		if err := rule.CheckKo(1, Black, nil); err != nil {
+			t.Errorf("%s: move without history rejected: %v", rule, err)
+		}
+	}
+}
+
+// Black takes a ko, then white tries to take back at once
+func TestPlayKoRecapture(t *testing.T) {
+	for _, rule := range []KoRule{KoSimple, KoPositionalSuperko, KoSituationalSuperko, KoNaturalSituationalSuperko} {
+		t.Run(string(rule), func(t *testing.T) {
+			state := boardFrom(rule,
+				".XO.",
+				"XO.O",
+				".XO.",
+			)
+
+			result, err := Play(state, at(2, 1), Black)
+			if err != nil {
+				t.Fatalf("taking the ko: %v", err)
+			}
+			if len(result.Captured) != 1 || result.Captured[0] != at(1, 1) {
+				t.Fatalf("captured %v, want [%d]", result.Captured, at(1, 1))
+			}
+
+			if _, err := Play(state, at(1, 1), White); !errors.Is(err, ErrKo) {
+				t.Fatalf("immediate recapture error = %v, want %v", err, ErrKo)
+			}
+			if state.PointAt(at(1, 1)) != types.PointEmpty || len(state.History) != 2 {
+				t.Fatal("rejected recapture changed the board")
+			}
+
+			// After an exchange elsewhere the recapture makes a new
+			// position
+			if _, err := Play(state, at(10, 10), White); err != nil {
+				t.Fatalf("white threat: %v", err)
+			}
+			if _, err := Play(state, at(10, 11), Black); err != nil {
+				t.Fatalf("black answer: %v", err)
+			}
+			_, err = Play(state, at(1, 1), White)
+			if err != nil {
+				t.Fatalf("recapture after threat: %v", err)
+			}
+		})
+	}
+}
+
+// A position white reached only by passing forbids white's recapture
+// under every rule but natural situational superko
+func TestPlayKoAfterPass(t *testing.T) {
+	tests := []struct {
+		rule KoRule
+		ko   bool
+	}{
+		{KoSimple, true},
+		{KoPositionalSuperko, true},
+		{KoSituationalSuperko, true},
+		{KoNaturalSituationalSuperko, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.rule), func(t *testing.T) {
+			state := boardFrom(tt.rule,
+				".XO.",
+				"XO.O",
+				".XO.",
+			)
+			if _, err := Play(state, at(10, 10), Black); err != nil {
+				t.Fatalf("black elsewhere: %v", err)
+			}
+			state.History = append(state.History, types.PositionHash{Hash: Hash(state), Player: 1, Pass: true})
+			if _, err := Play(state, at(2, 1), Black); err != nil {
+				t.Fatalf("taking the ko: %v", err)
+			}
+
+			_, err := Play(state, at(1, 1), White)
+			if got := errors.Is(err, ErrKo); got != tt.ko {
+				t.Fatalf("recapture error = %v, want ko %v", err, tt.ko)
+			}
+		})
+	}
+}
+
+func TestParseKoRule(t *testing.T) {
+	for _, rule := range []KoRule{KoSimple, KoPositionalSuperko, KoSituationalSuperko, KoNaturalSituationalSuperko} {
+		if got, ok := ParseKoRule(string(rule)); !ok || got != rule {
+			t.Errorf("ParseKoRule(%q) = %q, %v", rule, got, ok)
+		}
+	}
+	if _, ok := ParseKoRule("superko"); ok {
+		t.Error("ParseKoRule accepted an unknown rule")
+	}
+}
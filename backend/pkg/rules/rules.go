@@ -0,0 +1,142 @@
+// Package rules implements the rules of Go for a single 19x19 board.
+// The hub uses it to validate every move before it is applied, so the
+// server never has to trust what a client claims happened on the board.
+package rules
+
+import (
+	"fmt"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+const (
+	// BoardSize is the number of lines on each side of a board
+	BoardSize = types.BoardSize
+
+	// NumPoints is the number of intersections on a board
+	NumPoints = types.BoardPoints
+)
+
+// Color is the content of a single intersection, matching the values
+// returned by BoardState.PointAt
+type Color byte
+
+const (
+	Empty = Color(types.PointEmpty)
+	Black = Color(types.PointBlack)
+	White = Color(types.PointWhite)
+)
+
+// ParseColor converts a "black"/"white" string into a Color
+func ParseColor(s string) (Color, bool) {
+	switch s {
+	case "black":
+		return Black, true
+	case "white":
+		return White, true
+	}
+	return Empty, false
+}
+
+// Opponent returns the other player's color
+func (c Color) Opponent() Color {
+	switch c {
+	case Black:
+		return White
+	case White:
+		return Black
+	}
+	return Empty
+}
+
+// String returns the color as used in JSON messages
+func (c Color) String() string {
+	switch c {
+	case Black:
+		return "black"
+	case White:
+		return "white"
+	}
+	return "empty"
+}
+
+// MoveError describes why a move was rejected
+type MoveError struct {
+	Code    string
+	Message string
+}
+
+func (e *MoveError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Errors returned by Board.Play
+var (
+	ErrOutOfBounds  = &MoveError{Code: "OUT_OF_BOUNDS", Message: "Position is outside the board"}
+	ErrInvalidColor = &MoveError{Code: "INVALID_PLAYER", Message: "Player must be black or white"}
+	ErrOccupied     = &MoveError{Code: "POINT_OCCUPIED", Message: "Intersection is already occupied"}
+	ErrSuicide      = &MoveError{Code: "SUICIDE", Message: "Move would capture the player's own group"}
+)
+
+// Result describes the effect of a legal move
+type Result struct {
+	Captured []int // Points of the opponent stones removed by the move
+	Hash     uint64
+}
+
+// Play places a stone of color c at pos on the board, removing any
+// captured opponent groups, and records the new position in the board's
+// history. The board is left untouched if the move is illegal.
+func Play(state *types.BoardState, pos int, c Color) (*Result, error) {
+	if pos < 0 || pos >= NumPoints {
+		return nil, ErrOutOfBounds
+	}
+	if c != Black && c != White {
+		return nil, ErrInvalidColor
+	}
+	if state.PointAt(pos) != types.PointEmpty {
+		return nil, ErrOccupied
+	}
+
+	black, white := state.BlackStones, state.WhiteStones
+	restore := func() {
+		state.BlackStones, state.WhiteStones = black, white
+	}
+
+	state.SetPoint(pos, byte(c))
+
+	result := &Result{}
+	opponent := byte(c.Opponent())
+	for _, n := range types.Neighbors(pos) {
+		if state.PointAt(n) != opponent {
+			continue
+		}
+		group := state.GroupAt(n)
+		if state.Liberties(group) > 0 {
+			continue
+		}
+		for _, p := range group {
+			state.SetPoint(p, types.PointEmpty)
+		}
+		result.Captured = append(result.Captured, group...)
+	}
+
+	if len(result.Captured) == 0 && state.Liberties(state.GroupAt(pos)) == 0 {
+		restore()
+		return nil, ErrSuicide
+	}
+
+	// Enforce the board's ko rule against every position reached so far
+	result.Hash = Hash(state)
+	if err := KoRule(state.KoRule).CheckKo(result.Hash, c, state.History); err != nil {
+		restore()
+		return nil, err
+	}
+
+	state.History = append(state.History, types.PositionHash{
+		Hash:   result.Hash,
+		Player: byte(c - 1),
+	})
+
+	return result, nil
+}
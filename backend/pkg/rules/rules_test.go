@@ -0,0 +1,157 @@
+package rules
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// boardFrom builds a board from rows drawn from the top left corner, with
+// X for black, O for white and . for empty points. The drawn position
+// counts as reached by white, so black moves next.
+func boardFrom(koRule KoRule, rows ...string) *types.BoardState {
+	state := &types.BoardState{KoRule: string(koRule)}
+	for y, row := range rows {
+		for x, point := range row {
+			switch point {
+			case 'X':
+				state.SetPoint(at(x, y), types.PointBlack)
+			case 'O':
+				state.SetPoint(at(x, y), types.PointWhite)
+			}
+		}
+	}
+	state.History = []types.PositionHash{{Hash: Hash(state), Player: 1}}
+	return state
+}
+
+func at(x, y int) int {
+	return y*BoardSize + x
+}
+
+func TestPlay(t *testing.T) {
+	tests := []struct {
+		name     string
+		rows     []string
+		pos      int
+		color    Color
+		err      error
+		captured []int
+	}{
+		{name: "empty point", pos: at(3, 3), color: Black},
+		{name: "out of bounds low", pos: -1, color: Black, err: ErrOutOfBounds},
+		{name: "out of bounds high", pos: NumPoints, color: White, err: ErrOutOfBounds},
+		{name: "invalid color", pos: 0, color: Empty, err: ErrInvalidColor},
+		{name: "occupied", rows: []string{"X"}, pos: 0, color: White, err: ErrOccupied},
+		{
+			name:     "captures corner stone",
+			rows:     []string{"OX"},
+			pos:      at(0, 1),
+			color:    Black,
+			captured: []int{0},
+		},
+		{
+			name: "captures group",
+			rows: []string{
+				"OO.",
+				"XX.",
+			},
+			pos:      at(2, 0),
+			color:    Black,
+			captured: []int{at(0, 0), at(1, 0)},
+		},
+		{name: "suicide", rows: []string{".X", "X."}, pos: 0, color: White, err: ErrSuicide},
+		{
+			name: "group suicide",
+			rows: []string{
+				"O.X",
+				"XX.",
+			},
+			pos:   at(1, 0),
+			color: White,
+			err:   ErrSuicide,
+		},
+		{
+			name: "capture is not suicide",
+			rows: []string{
+				".XO",
+				"XO.",
+				"O..",
+			},
+			pos:      0,
+			color:    White,
+			captured: []int{at(1, 0), at(0, 1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := boardFrom(KoSimple, tt.rows...)
+			before := *state
+
+			result, err := Play(state, tt.pos, tt.color)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Play error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				if state.BlackStones != before.BlackStones || state.WhiteStones != before.WhiteStones || len(state.History) != len(before.History) {
+					t.Fatal("illegal move changed the board")
+				}
+				return
+			}
+
+			if state.PointAt(tt.pos) != byte(tt.color) {
+				t.Errorf("point %d = %d, want %d", tt.pos, state.PointAt(tt.pos), tt.color)
+			}
+			if !sameSet(result.Captured, tt.captured) {
+				t.Errorf("captured %v, want %v", result.Captured, tt.captured)
+			}
+			for _, pos := range tt.captured {
+				if state.PointAt(pos) != types.PointEmpty {
+					t.Errorf("captured point %d still holds a stone", pos)
+				}
+			}
+			if len(state.History) != 2 || state.History[1].Hash != Hash(state) || state.History[1].Player != byte(tt.color-1) {
+				t.Errorf("history = %+v, want the new position appended", state.History)
+			}
+		})
+	}
+}
+
+func sameSet(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[int]int)
+	for _, v := range a {
+		seen[v]++
+	}
+	for _, v := range b {
+		seen[v]--
+	}
+	for _, n := range seen {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHashFollowsStones(t *testing.T) {
+	state := boardFrom(KoSimple)
+	if Hash(state) != 0 {
+		t.Fatalf("empty board hash = %x, want 0", Hash(state))
+	}
+
+	state.SetPoint(at(3, 3), types.PointBlack)
+	black := Hash(state)
+	state.SetPoint(at(3, 3), types.PointWhite)
+	if white := Hash(state); white == black || white == 0 {
+		t.Errorf("hashes of different positions collide: black %x, white %x", black, white)
+	}
+	state.SetPoint(at(3, 3), types.PointEmpty)
+	if Hash(state) != 0 {
+		t.Errorf("hash after removing the stone = %x, want 0", Hash(state))
+	}
+}
@@ -0,0 +1,414 @@
+package rules
+
+import (
+	"fmt"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// Scoring selects how the final score of a board is counted
+type Scoring string
+
+const (
+	// ScoringArea counts stones plus surrounded empty points (Chinese)
+	ScoringArea Scoring = "area"
+
+	// ScoringTerritory counts surrounded empty points plus prisoners (Japanese)
+	ScoringTerritory Scoring = "territory"
+)
+
+// DefaultScoring and DefaultKomi are used when nothing is configured
+const (
+	DefaultScoring = ScoringArea
+	DefaultKomi    = 7.5
+)
+
+// ParseScoring validates a scoring method name
+func ParseScoring(s string) (Scoring, bool) {
+	switch scoring := Scoring(s); scoring {
+	case ScoringArea, ScoringTerritory:
+		return scoring, true
+	}
+	return "", false
+}
+
+// Territory returns the empty points surrounded by a single color once
+// the dead stones are removed, counted as the frontend's scorer counts
+// them. Points of dead stones become territory of the opponent, and
+// regions touching both colors are neutral. Territory scoring also
+// leaves out false eyes next to stones in atari, and seki: regions whose
+// groups hold fewer than two eyes between them count for no one.
+func Territory(state *types.BoardState, dead []int, scoring Scoring) (black, white []int) {
+	var board scoreBoard
+	for pos := range board {
+		board[pos] = Color(state.PointAt(pos))
+	}
+	for _, pos := range dead {
+		board[pos] = Empty
+	}
+
+	if scoring != ScoringTerritory {
+		for _, r := range board.regions() {
+			switch r.owner {
+			case Black:
+				black = append(black, r.points...)
+			case White:
+				white = append(white, r.points...)
+			}
+		}
+		return black, white
+	}
+
+	board = board.withNeutralsFilled()
+	board = board.withFalseEyesFilled()
+	territories := make([]*region, 0)
+	chainOf := make(map[int]int) // Stone to the index of its chain
+	for i, r := range board.regions() {
+		if r.owner != Empty {
+			territories = append(territories, r)
+		}
+		if board[r.points[0]] != Empty {
+			for _, pos := range r.points {
+				chainOf[pos] = i
+			}
+		}
+	}
+
+	for _, r := range territories {
+		if board.eyesAround(r, territories, chainOf) < 2 {
+			continue
+		}
+		if r.owner == Black {
+			black = append(black, r.points...)
+		} else {
+			white = append(white, r.points...)
+		}
+	}
+	return black, white
+}
+
+// scoreBoard is a board being prepared for counting
+type scoreBoard [NumPoints]Color
+
+// region is a chain of stones or an area of empty points. An empty
+// region bordered by a single color is owned by that color.
+type region struct {
+	points   []int
+	boundary []int // Points next to the region, of another color
+	owner    Color
+}
+
+// regions splits the board into regions, in the order the frontend finds
+// them
+func (b *scoreBoard) regions() []*region {
+	var seen [NumPoints]bool
+	regions := make([]*region, 0)
+	for pos := range b {
+		if seen[pos] {
+			continue
+		}
+		r := b.regionAt(pos)
+		for _, p := range r.points {
+			seen[p] = true
+		}
+		regions = append(regions, r)
+	}
+	return regions
+}
+
+// regionAt returns the region holding pos, walking it depth first as the
+// frontend does so the points come in the same order
+func (b *scoreBoard) regionAt(pos int) *region {
+	r := &region{}
+	var checked, bordering [NumPoints]bool
+	stack := []int{pos}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if checked[p] {
+			continue
+		}
+		checked[p] = true
+		r.points = append(r.points, p)
+		for _, n := range types.Neighbors(p) {
+			switch {
+			case checked[n]:
+			case b[n] == b[pos]:
+				stack = append(stack, n)
+			case !bordering[n]:
+				bordering[n] = true
+				r.boundary = append(r.boundary, n)
+			}
+		}
+	}
+
+	if b[pos] == Empty && len(r.boundary) > 0 {
+		r.owner = b[r.boundary[0]]
+		for _, n := range r.boundary {
+			if b[n] != r.owner {
+				r.owner = Empty
+				break
+			}
+		}
+	}
+	return r
+}
+
+// withNeutralsFilled returns the board with every neutral point given
+// alternately to black and white, so neutral points border no territory.
+// The pattern is anchored on the first nonzero coordinate met, as in the
+// frontend.
+func (b *scoreBoard) withNeutralsFilled() scoreBoard {
+	filled := *b
+	for _, r := range b.regions() {
+		if b[r.points[0]] != Empty || r.owner != Empty {
+			continue
+		}
+		anchor := 0
+		for _, pos := range r.points {
+			x, y := pos%BoardSize, pos/BoardSize
+			if anchor == 0 {
+				anchor = x
+			}
+			if anchor == 0 {
+				anchor = y
+			}
+			if distance := y + abs(x-anchor); distance%2 == 0 {
+				filled[pos] = Black
+			} else {
+				filled[pos] = White
+			}
+		}
+	}
+	return filled
+}
+
+// withFalseEyesFilled fills the false eyes in territory that border
+// stones in atari with the color of their first neighbor, until no such
+// eye is left. The board has its neutral points filled.
+func (b *scoreBoard) withFalseEyesFilled() scoreBoard {
+	board := *b
+	for eyes := b.falseEyesBy(b); len(eyes) > 0; {
+		next := board
+		for _, pos := range eyes {
+			next[pos] = board[types.Neighbors(pos)[0]]
+		}
+		if next == board {
+			break
+		}
+		board = next
+		neutral := board.withNeutralsFilled()
+		eyes = neutral.falseEyesBy(&board)
+	}
+	return board
+}
+
+// falseEyesBy returns the false eyes in territory with a neighbor in
+// atari on the board given, which may differ in its neutral points
+func (b *scoreBoard) falseEyesBy(atari *scoreBoard) []int {
+	eyes := make([]int, 0)
+	for _, r := range b.regions() {
+		if r.owner == Empty {
+			continue
+		}
+		for _, pos := range r.points {
+			if !b.isFalseEye(pos) {
+				continue
+			}
+			for _, n := range types.Neighbors(pos) {
+				if atari.liberties(n) == 1 {
+					eyes = append(eyes, pos)
+					break
+				}
+			}
+		}
+	}
+	return eyes
+}
+
+// isFalseEye reports whether an empty point is a false eye: one with an
+// opposing stone on a diagonal on the edge, or on two diagonals elsewhere
+func (b *scoreBoard) isFalseEye(pos int) bool {
+	if b[pos] != Empty {
+		return false
+	}
+	x, y := pos%BoardSize, pos/BoardSize
+	var diagonals []int
+	for _, dx := range []int{-1, 1} {
+		for _, dy := range []int{-1, 1} {
+			if x+dx >= 0 && x+dx < BoardSize && y+dy >= 0 && y+dy < BoardSize {
+				diagonals = append(diagonals, (y+dy)*BoardSize+x+dx)
+			}
+		}
+	}
+	var occupied []int
+	for _, n := range types.Neighbors(pos) {
+		if b[n] != Empty {
+			occupied = append(occupied, n)
+		}
+	}
+
+	onEdge := len(diagonals) <= 2
+	need := 2
+	if onEdge {
+		need = 1
+	}
+	if len(occupied) < need {
+		return false
+	}
+	opposing := 0
+	for _, d := range diagonals {
+		if b[d] != Empty && b[d] != b[occupied[0]] {
+			opposing++
+		}
+	}
+	return opposing >= need
+}
+
+// liberties counts the empty points next to the region holding pos
+func (b *scoreBoard) liberties(pos int) int {
+	var counted [NumPoints]bool
+	liberties := 0
+	for _, p := range b.regionAt(pos).points {
+		for _, n := range types.Neighbors(p) {
+			if b[n] == Empty && !counted[n] {
+				counted[n] = true
+				liberties++
+			}
+		}
+	}
+	return liberties
+}
+
+// eyesAround counts the eyes of a territory together with the other
+// territories of its color that border the same chains, directly or
+// through one another
+func (b *scoreBoard) eyesAround(r *region, territories []*region, chainOf map[int]int) int {
+	chains := func(t *region) map[int]bool {
+		ids := make(map[int]bool)
+		for _, n := range t.boundary {
+			ids[chainOf[n]] = true
+		}
+		return ids
+	}
+
+	merged := map[*region]bool{r: true}
+	reached := chains(r)
+	for grown := true; grown; {
+		grown = false
+		for _, t := range territories {
+			if merged[t] || t.owner != r.owner {
+				continue
+			}
+			for id := range chains(t) {
+				if reached[id] {
+					merged[t] = true
+					for id := range chains(t) {
+						reached[id] = true
+					}
+					grown = true
+					break
+				}
+			}
+		}
+	}
+
+	eyes := 0
+	for t := range merged {
+		eyes += b.eyes(t)
+	}
+	return eyes
+}
+
+// eyes estimates the eyes a territory makes from the length of its
+// border, rounded up: small areas make one eye, larger ones two unless
+// they are a square four
+func (b *scoreBoard) eyes(r *region) int {
+	length := len(r.boundary)
+	for _, pos := range r.points {
+		x, y := pos%BoardSize, pos/BoardSize
+		if x == 0 || y == 0 || x == BoardSize-1 || y == BoardSize-1 {
+			length++
+		}
+		// The frontend counts the point at (1,1) as its corner
+		if x == 1 && y == 1 {
+			length++
+		}
+	}
+
+	switch {
+	case length <= 6:
+		return 1
+	case length == 8 && b.hasSquareFour(r):
+		return 1
+	}
+	return 2
+}
+
+// hasSquareFour reports whether a territory holds a 2x2 block
+func (b *scoreBoard) hasSquareFour(r *region) bool {
+	for _, pos := range r.points {
+		x, y := pos%BoardSize, pos/BoardSize
+		if x+1 < BoardSize && y+1 < BoardSize &&
+			b[pos+1] == Empty && b[pos+BoardSize] == Empty && b[pos+BoardSize+1] == Empty {
+			return true
+		}
+	}
+	return false
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Score counts a finished board both ways, using the prisoners taken
+// during play and the board's komi, which is added to white
+func Score(state *types.BoardState, dead []int) *types.ScoreData {
+	blackArea, whiteArea := Territory(state, dead, ScoringArea)
+	blackTerritory, whiteTerritory := Territory(state, dead, ScoringTerritory)
+
+	var blackStones, whiteStones, blackDead, whiteDead int
+	isDead := make(map[int]bool, len(dead))
+	for _, pos := range dead {
+		isDead[pos] = true
+	}
+	for pos := 0; pos < NumPoints; pos++ {
+		c := Color(state.PointAt(pos))
+		switch {
+		case c == Black && isDead[pos]:
+			blackDead++
+		case c == White && isDead[pos]:
+			whiteDead++
+		case c == Black:
+			blackStones++
+		case c == White:
+			whiteStones++
+		}
+	}
+
+	return &types.ScoreData{
+		AreaBlack:      float64(len(blackArea) + blackStones),
+		AreaWhite:      float64(len(whiteArea)+whiteStones) + state.Komi,
+		TerritoryBlack: float64(len(blackTerritory) + int(state.BlackCaptures) + whiteDead),
+		TerritoryWhite: float64(len(whiteTerritory)+int(state.WhiteCaptures)+blackDead) + state.Komi,
+	}
+}
+
+// FormatResult formats a score as an SGF result string such as "B+3.5"
+func FormatResult(score *types.ScoreData, scoring Scoring) string {
+	black, white := score.AreaBlack, score.AreaWhite
+	if scoring == ScoringTerritory {
+		black, white = score.TerritoryBlack, score.TerritoryWhite
+	}
+
+	switch {
+	case black > white:
+		return fmt.Sprintf("B+%g", black-white)
+	case white > black:
+		return fmt.Sprintf("W+%g", white-black)
+	}
+	return "0"
+}
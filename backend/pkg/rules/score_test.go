@@ -0,0 +1,181 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// walledBoard splits the board with a black wall on column 3 and a white
+// wall on column 5, leaving column 4 neutral, 57 points of black
+// territory and 247 of white territory
+func walledBoard() *types.BoardState {
+	state := &types.BoardState{Komi: 6.5, BlackCaptures: 3, WhiteCaptures: 2}
+	for y := 0; y < BoardSize; y++ {
+		state.SetPoint(at(3, y), types.PointBlack)
+		state.SetPoint(at(5, y), types.PointWhite)
+	}
+	return state
+}
+
+func TestScore(t *testing.T) {
+	invader := at(1, 1)
+
+	tests := []struct {
+		name    string
+		invader bool
+		dead    []int
+		want    types.ScoreData
+	}{
+		{
+			name: "settled",
+			want: types.ScoreData{AreaBlack: 76, AreaWhite: 272.5, TerritoryBlack: 60, TerritoryWhite: 255.5},
+		},
+		{
+			// A live white stone makes black's side neutral
+			name:    "live invader",
+			invader: true,
+			want:    types.ScoreData{AreaBlack: 19, AreaWhite: 273.5, TerritoryBlack: 3, TerritoryWhite: 255.5},
+		},
+		{
+			// A dead stone's point is territory and the stone a prisoner
+			name:    "dead invader",
+			invader: true,
+			dead:    []int{invader},
+			want:    types.ScoreData{AreaBlack: 76, AreaWhite: 272.5, TerritoryBlack: 61, TerritoryWhite: 255.5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := walledBoard()
+			if tt.invader {
+				state.SetPoint(invader, types.PointWhite)
+			}
+
+			if got := Score(state, tt.dead); *got != tt.want {
+				t.Errorf("Score = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+// sekiBoard has a black and a white group in seki in the top left
+// corner, each with one eye and sharing a liberty, walled in by a white
+// wall on column 2 and a black one on column 3
+func sekiBoard() *types.BoardState {
+	state := boardFrom(KoSimple,
+		".X.O.OX",
+		"XXXOOOX",
+		"OOOXXXX",
+	)
+	for y := 3; y < BoardSize; y++ {
+		state.SetPoint(at(2, y), types.PointWhite)
+		state.SetPoint(at(3, y), types.PointBlack)
+	}
+	state.Komi = 6.5
+	return state
+}
+
+// falseEyeBoard splits the board with a black wall on column 9 and a
+// white one on column 10. At the top, the black stone on (10,0) is in
+// atari and its point on (9,0) a false eye.
+func falseEyeBoard() *types.BoardState {
+	state := &types.BoardState{Komi: 6.5}
+	for y := 1; y < BoardSize; y++ {
+		state.SetPoint(at(9, y), types.PointBlack)
+		state.SetPoint(at(10, y), types.PointWhite)
+	}
+	state.SetPoint(at(8, 0), types.PointBlack)
+	state.SetPoint(at(10, 0), types.PointBlack)
+	state.SetPoint(at(11, 0), types.PointWhite)
+	return state
+}
+
+// The expected scores are those the frontend's scorer gives
+func TestScoreSekiAndFalseEyes(t *testing.T) {
+	tests := []struct {
+		name  string
+		board func() *types.BoardState
+		want  types.ScoreData
+	}{
+		{
+			// Area scoring counts the eyes of the groups in seki,
+			// territory scoring counts them for no one
+			name:  "seki",
+			board: sekiBoard,
+			want:  types.ScoreData{AreaBlack: 303, AreaWhite: 63.5, TerritoryBlack: 276, TerritoryWhite: 38.5},
+		},
+		{
+			// The false eye is black's area but not its territory
+			name:  "false eye",
+			board: falseEyeBoard,
+			want:  types.ScoreData{AreaBlack: 191, AreaWhite: 176.5, TerritoryBlack: 170, TerritoryWhite: 157.5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Score(tt.board(), nil); *got != tt.want {
+				t.Errorf("Score = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+
+	black, white := Territory(sekiBoard(), nil, ScoringTerritory)
+	for _, pos := range append(black, white...) {
+		if pos == at(0, 0) || pos == at(4, 0) {
+			t.Errorf("eye in seki at %d counted as territory", pos)
+		}
+	}
+	if black, _ := Territory(falseEyeBoard(), nil, ScoringTerritory); len(black) != 170 {
+		t.Errorf("black territory %d around the false eye, want 170", len(black))
+	}
+}
+
+func TestTerritory(t *testing.T) {
+	black, white := Territory(walledBoard(), nil, ScoringTerritory)
+	if len(black) != 57 || len(white) != 247 {
+		t.Fatalf("territory black %d, white %d, want 57 and 247", len(black), len(white))
+	}
+	for _, pos := range black {
+		if x := pos % BoardSize; x >= 3 {
+			t.Errorf("black territory at column %d", x)
+		}
+	}
+
+	if black, white := Territory(&types.BoardState{}, nil, ScoringTerritory); len(black) != 0 || len(white) != 0 {
+		t.Errorf("empty board territory black %d, white %d, want none", len(black), len(white))
+	}
+}
+
+func TestFormatResult(t *testing.T) {
+	score := &types.ScoreData{AreaBlack: 180, AreaWhite: 188.5, TerritoryBlack: 70, TerritoryWhite: 66.5}
+
+	tests := []struct {
+		score   *types.ScoreData
+		scoring Scoring
+		want    string
+	}{
+		{score, ScoringArea, "W+8.5"},
+		{score, ScoringTerritory, "B+3.5"},
+		{&types.ScoreData{AreaBlack: 10, AreaWhite: 10}, ScoringArea, "0"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatResult(tt.score, tt.scoring); got != tt.want {
+			t.Errorf("FormatResult(%+v, %s) = %q, want %q", *tt.score, tt.scoring, got, tt.want)
+		}
+	}
+}
+
+func TestParseScoring(t *testing.T) {
+	for _, scoring := range []Scoring{ScoringArea, ScoringTerritory} {
+		if got, ok := ParseScoring(string(scoring)); !ok || got != scoring {
+			t.Errorf("ParseScoring(%q) = %q, %v", scoring, got, ok)
+		}
+	}
+	if _, ok := ParseScoring("stone"); ok {
+		t.Error("ParseScoring accepted an unknown method")
+	}
+}
@@ -0,0 +1,36 @@
+package rules
+
+import "github.com/one-million-go/backend/pkg/types"
+
+// zobristSeed makes the hash table identical across restarts so stored
+// position histories stay comparable
+const zobristSeed = 0x9E3779B97F4A7C15
+
+// zobrist holds one random bitstring per point and stone color
+var zobrist [NumPoints][2]uint64
+
+func init() {
+	state := uint64(zobristSeed)
+	for pos := 0; pos < NumPoints; pos++ {
+		for c := 0; c < 2; c++ {
+			// splitmix64
+			state += 0x9E3779B97F4A7C15
+			z := state
+			z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+			z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+			zobrist[pos][c] = z ^ (z >> 31)
+		}
+	}
+}
+
+// Hash returns the Zobrist hash of the stones on the board. The empty
+// board hashes to zero.
+func Hash(state *types.BoardState) uint64 {
+	var h uint64
+	for pos := 0; pos < NumPoints; pos++ {
+		if c := state.PointAt(pos); c != types.PointEmpty {
+			h ^= zobrist[pos][c-1]
+		}
+	}
+	return h
+}
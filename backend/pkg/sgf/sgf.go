@@ -0,0 +1,327 @@
+// Package sgf reads and writes single-board games in the Smart Game
+// Format (FF[4]), so boards can be analysed in external tools.
+package sgf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+// Game is the part of an SGF game tree the server understands: the root
+// properties and the main line of moves
+type Game struct {
+	Size       int
+	Komi       *float64 // nil when KM is absent
+	Rules      string   // RU property, e.g. "Chinese"
+	KoRule     string   // Ko rule from the game comment, if written by this server
+	Result     string
+	BlackSetup []uint16 // AB setup stones
+	WhiteSetup []uint16 // AW setup stones
+	Moves      []types.Move
+}
+
+// koComment prefixes the game comment that records the server's ko rule
+const koComment = "Ko rule: "
+
+// rulesName maps the server's scoring methods to SGF RU values
+var rulesName = map[string]string{
+	"area":      "Chinese",
+	"territory": "Japanese",
+}
+
+// ScoringFor returns the scoring method implied by an SGF RU value
+func ScoringFor(rules string) string {
+	for scoring, name := range rulesName {
+		if strings.EqualFold(rules, name) {
+			return scoring
+		}
+	}
+	return ""
+}
+
+// Encode writes a board's move history as an SGF game
+func Encode(state *types.BoardState) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "(;FF[4]GM[1]CA[UTF-8]AP[OneMillionGo]SZ[%d]KM[%g]", types.BoardSize, state.Komi)
+	if name, ok := rulesName[state.Scoring]; ok {
+		fmt.Fprintf(&buf, "RU[%s]", name)
+	}
+	if state.KoRule != "" {
+		fmt.Fprintf(&buf, "GC[%s%s]", koComment, escape(state.KoRule))
+	}
+	if state.Result != "" {
+		fmt.Fprintf(&buf, "RE[%s]", escape(state.Result))
+	}
+	for _, setup := range []struct {
+		prop   string
+		points []uint16
+	}{{"AB", state.SetupBlack}, {"AW", state.SetupWhite}} {
+		if len(setup.points) == 0 {
+			continue
+		}
+		buf.WriteString(setup.prop)
+		for _, pos := range setup.points {
+			fmt.Fprintf(&buf, "[%s]", point(pos))
+		}
+	}
+
+	for _, move := range state.Moves {
+		color := "B"
+		if move.Player == 1 {
+			color = "W"
+		}
+		fmt.Fprintf(&buf, "\n;%s[%s]", color, point(move.Position))
+	}
+	buf.WriteString(")\n")
+
+	return buf.Bytes()
+}
+
+// point converts a board position to SGF coordinates, "" for a pass
+func point(pos uint16) string {
+	if pos >= types.BoardPoints {
+		return ""
+	}
+	x, y := pos%types.BoardSize, pos/types.BoardSize
+	return string([]byte{byte('a' + x), byte('a' + y)})
+}
+
+// parsePoint converts SGF coordinates to a board position. Both "" and
+// "tt" mean a pass on a 19x19 board.
+func parsePoint(value string) (uint16, error) {
+	if value == "" || value == "tt" {
+		return types.PassPosition, nil
+	}
+	if len(value) != 2 {
+		return 0, fmt.Errorf("invalid point %q", value)
+	}
+	x, y := int(value[0]-'a'), int(value[1]-'a')
+	if x < 0 || x >= types.BoardSize || y < 0 || y >= types.BoardSize {
+		return 0, fmt.Errorf("point %q outside the board", value)
+	}
+	return uint16(y*types.BoardSize + x), nil
+}
+
+func escape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "]", `\]`)
+}
+
+// Parse reads the first game of an SGF collection, following the first
+// variation at every branch
+func Parse(data []byte) (*Game, error) {
+	p := &parser{data: data}
+	nodes, err := p.mainLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("sgf: no game found")
+	}
+
+	game := &Game{Size: types.BoardSize}
+	for i, node := range nodes {
+		for _, prop := range node {
+			if err := game.apply(prop, i == 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if game.Size != types.BoardSize {
+		return nil, fmt.Errorf("sgf: unsupported board size %d", game.Size)
+	}
+	return game, nil
+}
+
+func (g *Game) apply(prop property, root bool) error {
+	value := ""
+	if len(prop.values) > 0 {
+		value = prop.values[0]
+	}
+
+	switch prop.id {
+	case "GM":
+		if value != "1" {
+			return fmt.Errorf("sgf: not a game of Go (GM[%s])", value)
+		}
+	case "SZ":
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("sgf: invalid board size %q", value)
+		}
+		g.Size = size
+	case "KM":
+		komi, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("sgf: invalid komi %q", value)
+		}
+		g.Komi = &komi
+	case "RU":
+		g.Rules = value
+	case "RE":
+		g.Result = value
+	case "GC":
+		if strings.HasPrefix(value, koComment) {
+			g.KoRule = strings.TrimPrefix(value, koComment)
+		}
+	case "AB", "AW":
+		if !root {
+			return fmt.Errorf("sgf: setup stones are only supported in the root node")
+		}
+		for _, v := range prop.values {
+			pos, err := parsePoint(v)
+			if err != nil || pos == types.PassPosition {
+				return fmt.Errorf("sgf: invalid setup point %q", v)
+			}
+			if prop.id == "AB" {
+				g.BlackSetup = append(g.BlackSetup, pos)
+			} else {
+				g.WhiteSetup = append(g.WhiteSetup, pos)
+			}
+		}
+	case "B", "W":
+		pos, err := parsePoint(value)
+		if err != nil {
+			return fmt.Errorf("sgf: %v", err)
+		}
+		player := byte(0)
+		if prop.id == "W" {
+			player = 1
+		}
+		g.Moves = append(g.Moves, types.Move{Position: pos, Player: player})
+	}
+	return nil
+}
+
+// property is a single SGF property with its raw values
+type property struct {
+	id     string
+	values []string
+}
+
+// parser is a minimal reader for SGF game trees
+type parser struct {
+	data []byte
+	pos  int
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.data) && strings.ContainsRune(" \t\r\n", rune(p.data[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *parser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return 0
+	}
+	return p.data[p.pos]
+}
+
+func (p *parser) expect(c byte) error {
+	if p.peek() != c {
+		return fmt.Errorf("sgf: expected %q at offset %d", c, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+// mainLine reads a game tree and returns the nodes of its first
+// variation. It keeps only the nesting depth rather than recursing, so
+// deeply nested variations cannot exhaust the stack.
+func (p *parser) mainLine() ([][]property, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+
+	var nodes [][]property
+	depth := 1
+	following := true   // Still on the first variation at every level
+	variations := false // A tree at this level has closed, so no node may follow
+	for depth > 0 {
+		switch p.peek() {
+		case ';':
+			if variations {
+				return nil, fmt.Errorf("sgf: expected %q at offset %d", ')', p.pos)
+			}
+			p.pos++
+			node, err := p.node()
+			if err != nil {
+				return nil, err
+			}
+			if following {
+				nodes = append(nodes, node)
+			}
+		case '(':
+			p.pos++
+			depth++
+			variations = false
+		case ')':
+			// Once a variation ends, the rest are ones to skip
+			p.pos++
+			depth--
+			variations = true
+			following = false
+		default:
+			return nil, fmt.Errorf("sgf: expected %q at offset %d", ')', p.pos)
+		}
+	}
+	return nodes, nil
+}
+
+func (p *parser) node() ([]property, error) {
+	var props []property
+	for {
+		c := p.peek()
+		if c < 'A' || c > 'Z' {
+			return props, nil
+		}
+
+		start := p.pos
+		for p.pos < len(p.data) && p.data[p.pos] >= 'A' && p.data[p.pos] <= 'Z' {
+			p.pos++
+		}
+		prop := property{id: string(p.data[start:p.pos])}
+
+		for p.peek() == '[' {
+			p.pos++
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			prop.values = append(prop.values, value)
+		}
+		if len(prop.values) == 0 {
+			return nil, fmt.Errorf("sgf: property %s has no value", prop.id)
+		}
+		props = append(props, prop)
+	}
+}
+
+func (p *parser) value() (string, error) {
+	var sb strings.Builder
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '\\':
+			if p.pos < len(p.data) {
+				sb.WriteByte(p.data[p.pos])
+				p.pos++
+			}
+		case ']':
+			return sb.String(), nil
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", errors.New("sgf: unterminated property value")
+}
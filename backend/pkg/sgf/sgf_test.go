@@ -0,0 +1,138 @@
+package sgf
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/one-million-go/backend/pkg/types"
+)
+
+func TestParseEscapes(t *testing.T) {
+	game, err := Parse([]byte(`(;GM[1]GC[Ko rule: a\]b\\c\d]RE[W+\]])`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if game.KoRule != `a]b\cd` || game.Result != "W+]" {
+		t.Errorf("ko rule %q and result %q, want %q and %q", game.KoRule, game.Result, `a]b\cd`, "W+]")
+	}
+}
+
+func TestParseVariations(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		moves []string
+	}{
+		{"no variations", `(;SZ[19];B[aa];W[bb])`, []string{"aa", "bb"}},
+		{"first variation followed", `(;B[aa](;W[bb];B[cc])(;W[dd]))`, []string{"aa", "bb", "cc"}},
+		{"nested variations", `(;B[aa](;W[bb](;B[cc](;W[dd])(;W[ee]))(;B[ff]))(;W[gg];B[hh]))`, []string{"aa", "bb", "cc", "dd"}},
+		{"variation at the root", `((;B[aa])(;B[bb]))`, []string{"aa"}},
+		{"second game ignored", "(;B[aa])\n(;B[bb])", []string{"aa"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			game, err := Parse([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			var moves []string
+			for _, move := range game.Moves {
+				moves = append(moves, point(move.Position))
+			}
+			if !reflect.DeepEqual(moves, tt.moves) {
+				t.Errorf("moves %v, want %v", moves, tt.moves)
+			}
+		})
+	}
+}
+
+func TestParseDeepNesting(t *testing.T) {
+	const depth = 1 << 20
+	data := strings.Repeat("(;B[aa]", depth) + strings.Repeat(")", depth)
+	game, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(game.Moves) != depth {
+		t.Errorf("%d moves, want %d", len(game.Moves), depth)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ``},
+		{"no game tree", `;B[aa]`},
+		{"property without value", `(;B;W[bb])`},
+		{"unterminated value", `(;B[aa`},
+		{"point off the board", `(;B[zz])`},
+		{"point of three letters", `(;B[abc])`},
+		{"invalid komi", `(;KM[six])`},
+		{"other game", `(;GM[2])`},
+		{"other board size", `(;SZ[13])`},
+		{"setup outside the root", `(;B[aa];AB[bb])`},
+		{"unclosed tree", `(;B[aa](;W[bb])`},
+		{"node after a variation", `(;B[aa](;W[bb]);B[cc])`},
+		{"lowercase property", `(;b[aa])`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if game, err := Parse([]byte(tt.data)); err == nil {
+				t.Errorf("Parse(%q) = %+v, want an error", tt.data, game)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	state := &types.BoardState{
+		Komi:       6.5,
+		Scoring:    "territory",
+		KoRule:     "positional]superko",
+		Result:     "B+R",
+		SetupBlack: []uint16{0, 20},
+		SetupWhite: []uint16{types.BoardPoints - 1},
+		Moves: []types.Move{
+			{Position: 60, Player: 0},
+			{Position: types.PassPosition, Player: 1},
+			{Position: 300, Player: 0},
+		},
+	}
+	data := Encode(state)
+	if !bytes.HasPrefix(data, []byte("(;FF[4]GM[1]")) {
+		t.Fatalf("encoded game does not start as FF[4]: %s", data)
+	}
+
+	game, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v\n%s", err, data)
+	}
+	if game.Size != types.BoardSize || game.Komi == nil || *game.Komi != state.Komi {
+		t.Errorf("size %d and komi %v, want %d and %g", game.Size, game.Komi, types.BoardSize, state.Komi)
+	}
+	if ScoringFor(game.Rules) != state.Scoring || game.KoRule != state.KoRule || game.Result != state.Result {
+		t.Errorf("rules %q, ko rule %q and result %q, want %s scoring, %q and %q",
+			game.Rules, game.KoRule, game.Result, state.Scoring, state.KoRule, state.Result)
+	}
+	if !reflect.DeepEqual(game.BlackSetup, state.SetupBlack) || !reflect.DeepEqual(game.WhiteSetup, state.SetupWhite) {
+		t.Errorf("setup %v and %v, want %v and %v", game.BlackSetup, game.WhiteSetup, state.SetupBlack, state.SetupWhite)
+	}
+	if !reflect.DeepEqual(game.Moves, state.Moves) {
+		t.Errorf("moves %+v, want %+v", game.Moves, state.Moves)
+	}
+
+	// A pass may also be written as tt
+	game, err = Parse([]byte(`(;FF[4];B[tt];W[])`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(game.Moves) != 2 || game.Moves[0].Position != types.PassPosition || game.Moves[1].Position != types.PassPosition {
+		t.Errorf("passes read as %+v", game.Moves)
+	}
+}
@@ -0,0 +1,183 @@
+package types
+
+import "encoding/json"
+
+const (
+	// BoardSize is the number of lines on each side of a board
+	BoardSize = 19
+
+	// BoardPoints is the number of intersections on a board
+	BoardPoints = BoardSize * BoardSize
+)
+
+// Point contents returned by BoardState.PointAt
+const (
+	PointEmpty byte = 0
+	PointBlack byte = 1
+	PointWhite byte = 2
+)
+
+// neighbors holds the precomputed orthogonal neighbors of every point
+var neighbors [BoardPoints][]int
+
+func init() {
+	for pos := 0; pos < BoardPoints; pos++ {
+		x, y := pos%BoardSize, pos/BoardSize
+		if x > 0 {
+			neighbors[pos] = append(neighbors[pos], pos-1)
+		}
+		if x < BoardSize-1 {
+			neighbors[pos] = append(neighbors[pos], pos+1)
+		}
+		if y > 0 {
+			neighbors[pos] = append(neighbors[pos], pos-BoardSize)
+		}
+		if y < BoardSize-1 {
+			neighbors[pos] = append(neighbors[pos], pos+BoardSize)
+		}
+	}
+}
+
+// Neighbors returns the orthogonally adjacent points of pos
+func Neighbors(pos int) []int {
+	return neighbors[pos]
+}
+
+// PointAt returns the content of the point at pos (row-major, 0-360)
+func (b *BoardState) PointAt(pos int) byte {
+	mask := byte(1) << (pos & 7)
+	switch {
+	case b.BlackStones[pos>>3]&mask != 0:
+		return PointBlack
+	case b.WhiteStones[pos>>3]&mask != 0:
+		return PointWhite
+	}
+	return PointEmpty
+}
+
+// SetPoint places a stone of the given color at pos, or clears it when
+// color is PointEmpty
+func (b *BoardState) SetPoint(pos int, color byte) {
+	mask := byte(1) << (pos & 7)
+	b.BlackStones[pos>>3] &^= mask
+	b.WhiteStones[pos>>3] &^= mask
+	switch color {
+	case PointBlack:
+		b.BlackStones[pos>>3] |= mask
+	case PointWhite:
+		b.WhiteStones[pos>>3] |= mask
+	}
+}
+
+// ClearStones removes every stone from the board
+func (b *BoardState) ClearStones() {
+	b.BlackStones = [46]byte{}
+	b.WhiteStones = [46]byte{}
+}
+
+// Snapshot returns a copy of the board that stays fixed while the
+// original keeps changing, for handing to another goroutine. Slices are
+// shared: the board only ever appends to them or replaces them.
+func (b *BoardState) Snapshot() *BoardState {
+	snapshot := *b
+	return &snapshot
+}
+
+// GroupAt returns every point in the chain of stones containing pos, or
+// nil when pos is empty
+func (b *BoardState) GroupAt(pos int) []int {
+	color := b.PointAt(pos)
+	if color == PointEmpty {
+		return nil
+	}
+
+	var visited [BoardPoints]bool
+	visited[pos] = true
+	group := []int{pos}
+	for i := 0; i < len(group); i++ {
+		for _, n := range neighbors[group[i]] {
+			if !visited[n] && b.PointAt(n) == color {
+				visited[n] = true
+				group = append(group, n)
+			}
+		}
+	}
+	return group
+}
+
+// Liberties counts the distinct empty points adjacent to a group
+func (b *BoardState) Liberties(group []int) int {
+	var seen [BoardPoints]bool
+	count := 0
+	for _, pos := range group {
+		for _, n := range neighbors[pos] {
+			if !seen[n] && b.PointAt(n) == PointEmpty {
+				seen[n] = true
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Stones lists the occupied points in row-major order
+func (b *BoardState) Stones() []Stone {
+	stones := make([]Stone, 0)
+	for pos := 0; pos < BoardPoints; pos++ {
+		var color string
+		switch b.PointAt(pos) {
+		case PointBlack:
+			color = "black"
+		case PointWhite:
+			color = "white"
+		default:
+			continue
+		}
+		stones = append(stones, Stone{
+			X:     uint8(pos % BoardSize),
+			Y:     uint8(pos / BoardSize),
+			Color: color,
+		})
+	}
+	return stones
+}
+
+// boardStateJSON adds the derived stone list to the encoded BoardState
+type boardStateJSON struct {
+	*boardStateAlias
+	Stones []Stone `json:"stones"`
+}
+
+type boardStateAlias BoardState
+
+// MarshalJSON encodes the board with its stones expanded from the
+// bitfields
+func (b *BoardState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(boardStateJSON{
+		boardStateAlias: (*boardStateAlias)(b),
+		Stones:          b.Stones(),
+	})
+}
+
+// UnmarshalJSON rebuilds the bitfields from the encoded stone list
+func (b *BoardState) UnmarshalJSON(data []byte) error {
+	decoded := boardStateJSON{boardStateAlias: (*boardStateAlias)(b)}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	b.ClearStones()
+	for _, s := range decoded.Stones {
+		if s.X >= BoardSize || s.Y >= BoardSize {
+			continue
+		}
+		pos := int(s.Y)*BoardSize + int(s.X)
+		switch s.Color {
+		case "black":
+			b.SetPoint(pos, PointBlack)
+		case "white":
+			b.SetPoint(pos, PointWhite)
+		}
+	}
+	return nil
+}
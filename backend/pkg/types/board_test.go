@@ -0,0 +1,69 @@
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// encodedStones returns the stone list of a board's JSON
+func encodedStones(t *testing.T, b *BoardState) []Stone {
+	t.Helper()
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var encoded struct {
+		Stones []Stone `json:"stones"`
+	}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	return encoded.Stones
+}
+
+func TestBoardStones(t *testing.T) {
+	last := BoardPoints - 1
+	steps := []struct {
+		name  string
+		pos   int
+		color byte
+		want  []Stone
+	}{
+		{"black on the first point", 0, PointBlack, []Stone{{0, 0, "black"}}},
+		{"white on the last point", last, PointWhite, []Stone{{0, 0, "black"}, {18, 18, "white"}}},
+		{"first point turned white", 0, PointWhite, []Stone{{0, 0, "white"}, {18, 18, "white"}}},
+		{"first point cleared", 0, PointEmpty, []Stone{{18, 18, "white"}}},
+		{"last point cleared", last, PointEmpty, []Stone{}},
+	}
+
+	b := &BoardState{}
+	for _, step := range steps {
+		b.SetPoint(step.pos, step.color)
+		if got := b.PointAt(step.pos); got != step.color {
+			t.Errorf("%s: PointAt(%d) = %d, want %d", step.name, step.pos, got, step.color)
+		}
+		if got := encodedStones(t, b); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: stones %v, want %v", step.name, got, step.want)
+		}
+
+		// The bitfields come back from the stone list
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		decoded := &BoardState{}
+		if err := json.Unmarshal(data, decoded); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if decoded.BlackStones != b.BlackStones || decoded.WhiteStones != b.WhiteStones {
+			t.Errorf("%s: bitfields changed in a JSON round trip", step.name)
+		}
+	}
+
+	// Bits past the last point are never set
+	b.SetPoint(last, PointBlack)
+	if b.BlackStones[45]&^1 != 0 {
+		t.Errorf("last byte %08b has bits past the board", b.BlackStones[45])
+	}
+}
@@ -0,0 +1,66 @@
+package types
+
+// payloads maps every message type to a constructor for its payload
+// struct; types without a payload map to nil. Every encoding of the
+// protocol decodes into these structs.
+var payloads = map[MessageType]func() interface{}{
+	// Client → Server
+	MsgHello:           func() interface{} { return &HelloData{} },
+	MsgSendMove:        func() interface{} { return &MoveRequestData{} },
+	MsgFetchBoard:      func() interface{} { return &FetchBoardData{} },
+	MsgFetchRegion:     func() interface{} { return &FetchRegionData{} },
+	MsgSubscribeRegion: func() interface{} { return &SubscribeRegionData{} },
+	MsgUnsubscribe:     func() interface{} { return &UnsubscribeRegionData{} },
+	MsgSetRuleset:      func() interface{} { return &SetRulesetData{} },
+	MsgClaimSeat:       func() interface{} { return &SeatData{} },
+	MsgLeaveSeat:       func() interface{} { return &SeatData{} },
+	MsgPass:            func() interface{} { return &BoardActionData{} },
+	MsgResign:          func() interface{} { return &BoardActionData{} },
+	MsgAcceptResult:    func() interface{} { return &BoardActionData{} },
+	MsgMarkDead:        func() interface{} { return &MarkDeadData{} },
+	MsgFetchHistory:    func() interface{} { return &FetchHistoryData{} },
+	MsgPing:            nil,
+
+	// Server → Client
+	MsgWelcome:     func() interface{} { return &WelcomeData{} },
+	MsgMoveResult:  func() interface{} { return &MoveResultData{} },
+	MsgBoardState:  func() interface{} { return &BoardState{} },
+	MsgBoardUpdate: func() interface{} { return &BoardUpdateData{} },
+	MsgBoardDelta:  func() interface{} { return &BoardDelta{} },
+	MsgBoardDeltas: func() interface{} { return &BoardDeltasData{} },
+	MsgRegionData:  func() interface{} { return &RegionDataResponse{} },
+	MsgGamePhase:   func() interface{} { return &GamePhaseData{} },
+	MsgSubscribed:  func() interface{} { return &SubscribedData{} },
+	MsgHistory:     func() interface{} { return &HistoryData{} },
+	MsgError:       func() interface{} { return &ErrorData{} },
+	MsgPong:        func() interface{} { return &PongData{} },
+}
+
+// NewPayload returns a pointer to an empty payload struct for a message
+// type, or nil when the type carries no payload or is unknown
+func NewPayload(t MessageType) interface{} {
+	if newPayload := payloads[t]; newPayload != nil {
+		return newPayload()
+	}
+	return nil
+}
+
+// KnownType reports whether a message type is part of the protocol
+func KnownType(t MessageType) bool {
+	_, known := payloads[t]
+	return known
+}
+
+// BoardRequest is implemented by request payloads that act on a single
+// board, so they can be routed to whoever owns it
+type BoardRequest interface {
+	Board() BoardCoordinate
+}
+
+func (d *MoveRequestData) Board() BoardCoordinate  { return NewBoardCoordinate(d.BoardX, d.BoardY) }
+func (d *FetchBoardData) Board() BoardCoordinate   { return NewBoardCoordinate(d.BoardX, d.BoardY) }
+func (d *FetchHistoryData) Board() BoardCoordinate { return NewBoardCoordinate(d.BoardX, d.BoardY) }
+func (d *BoardActionData) Board() BoardCoordinate  { return NewBoardCoordinate(d.BoardX, d.BoardY) }
+func (d *MarkDeadData) Board() BoardCoordinate     { return NewBoardCoordinate(d.BoardX, d.BoardY) }
+func (d *SeatData) Board() BoardCoordinate         { return NewBoardCoordinate(d.BoardX, d.BoardY) }
+func (d *SetRulesetData) Board() BoardCoordinate   { return NewBoardCoordinate(d.BoardX, d.BoardY) }
@@ -1,10 +1,17 @@
 package types
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 )
 
+// GridSize is the number of boards on each side of the grid
+const GridSize = 1000
+
+// MaxRegionSize is the most boards on each side of a FETCH_REGION
+const MaxRegionSize = 64
+
 // BoardCoordinate - 32-bit packed coordinate (16 bits each for X,Y)
 type BoardCoordinate uint32
 
@@ -28,11 +35,20 @@ func (bc BoardCoordinate) String() string {
 
 // Move represents a single move in a Go game
 type Move struct {
-	Position uint16 `json:"position"` // 0-360 for 19x19 board
+	Position uint16 `json:"position"` // 0-360 for 19x19 board, PassPosition for a pass
 	Player   byte   `json:"player"`   // 0=black, 1=white
 	MoveNum  uint16 `json:"moveNum"`  // Move number in game
 	X        uint8  `json:"x"`        // Board position X (0-18)
 	Y        uint8  `json:"y"`        // Board position Y (0-18)
+	Captures uint16 `json:"captures"` // Opponent stones removed by the move
+}
+
+// PassPosition is the Move.Position recorded for a pass
+const PassPosition uint16 = 361
+
+// IsPass reports whether the move is a pass
+func (m *Move) IsPass() bool {
+	return m.Position == PassPosition
 }
 
 // Stone represents a stone placement on the board
@@ -49,16 +65,69 @@ type BoardState struct {
 	WhiteStones [46]byte `json:"-"` // 361 bits for white stones
 
 	// Game metadata
+	Version       uint32 `json:"version"` // Increases with every change to the board
 	MoveCount     uint16 `json:"moveCount"`
 	LastMove      uint32 `json:"lastMove"`      // Unix timestamp
 	CurrentPlayer byte   `json:"currentPlayer"` // 0=black, 1=white
 	GamePhase     byte   `json:"gamePhase"`     // 0=playing, 1=finished, 2=scoring
 	Activity      byte   `json:"activity"`      // Recent activity counter 0-255
-	Reserved      byte   `json:"-"`             // Future use
+	Reserved      byte   `json:"-" wire:"-"`    // Future use
+	BlackCaptures uint16 `json:"blackCaptures"` // White stones captured by black
+	WhiteCaptures uint16 `json:"whiteCaptures"` // Black stones captured by white
+	KoRule        string `json:"koRule"`        // Ko rule enforced on this board
+	Passes        byte   `json:"passes"`        // Consecutive passes, two end play
+
+	// Setup (handicap) stones placed before the first move
+	SetupBlack []uint16 `json:"setupBlack,omitempty"`
+	SetupWhite []uint16 `json:"setupWhite,omitempty"`
+
+	// Scoring settings, fixed when the board is created
+	Komi    float64 `json:"komi"`
+	Scoring string  `json:"scoring"` // "area" or "territory"
+
+	// Game outcome: SGF style result (e.g. "B+R") and scoring agreement
+	Result        string     `json:"result,omitempty"`
+	Score         *ScoreData `json:"score,omitempty"`
+	DeadStones    []uint16   `json:"deadStones,omitempty"` // Positions marked dead while scoring
+	BlackAccepted bool       `json:"blackAccepted,omitempty"`
+	WhiteAccepted bool       `json:"whiteAccepted,omitempty"`
+
+	// Seats: client IDs of the players, empty when the seat is open
+	BlackPlayer string `json:"blackPlayer,omitempty"`
+	WhitePlayer string `json:"whitePlayer,omitempty"`
+
+	// Every position reached so far, for ko and superko checks
+	History []PositionHash `json:"-" wire:"-"`
+
+	// Most recent deltas, oldest first, for clients catching up
+	RecentDeltas []*BoardDelta `json:"-" wire:"-"`
+
+	// Move history; the stone list is derived from the bitfields when
+	// encoding JSON
+	Moves []Move `json:"moves"`
+}
+
+// Game phases stored in BoardState.GamePhase
+const (
+	PhasePlaying  byte = 0
+	PhaseFinished byte = 1
+	PhaseScoring  byte = 2
+)
 
-	// Cached data for JSON responses
-	Stones []Stone `json:"stones"`
-	Moves  []Move  `json:"moves"`
+// ScoreData holds a board's score under both counting methods, komi
+// included
+type ScoreData struct {
+	AreaBlack      float64 `json:"areaBlack"`
+	AreaWhite      float64 `json:"areaWhite"`
+	TerritoryBlack float64 `json:"territoryBlack"`
+	TerritoryWhite float64 `json:"territoryWhite"`
+}
+
+// PositionHash records the Zobrist hash of a position reached in a game
+type PositionHash struct {
+	Hash   uint64
+	Player byte // Player whose move produced the position: 0=black, 1=white
+	Pass   bool // Position was produced by a pass
 }
 
 // ActivityTracker tracks board usage statistics
@@ -86,18 +155,33 @@ type MessageType string
 
 const (
 	// Client → Server messages
+	MsgHello           MessageType = "HELLO"
 	MsgSendMove        MessageType = "SEND_MOVE"
 	MsgFetchBoard      MessageType = "FETCH_BOARD"
 	MsgFetchRegion     MessageType = "FETCH_REGION"
 	MsgSubscribeRegion MessageType = "SUBSCRIBE_REGION"
 	MsgUnsubscribe     MessageType = "UNSUBSCRIBE_REGION"
+	MsgSetRuleset      MessageType = "SET_RULESET"
+	MsgClaimSeat       MessageType = "CLAIM_SEAT"
+	MsgLeaveSeat       MessageType = "LEAVE_SEAT"
+	MsgPass            MessageType = "PASS"
+	MsgResign          MessageType = "RESIGN"
+	MsgAcceptResult    MessageType = "ACCEPT_RESULT"
+	MsgMarkDead        MessageType = "MARK_DEAD"
+	MsgFetchHistory    MessageType = "FETCH_HISTORY"
 	MsgPing            MessageType = "PING"
 
 	// Server → Client messages
+	MsgWelcome     MessageType = "WELCOME"
 	MsgMoveResult  MessageType = "MOVE_RESULT"
 	MsgBoardState  MessageType = "BOARD_STATE"
 	MsgBoardUpdate MessageType = "BOARD_UPDATE"
+	MsgBoardDelta  MessageType = "BOARD_DELTA"
+	MsgBoardDeltas MessageType = "BOARD_DELTAS"
 	MsgRegionData  MessageType = "REGION_DATA"
+	MsgGamePhase   MessageType = "GAME_PHASE"
+	MsgSubscribed  MessageType = "SUBSCRIBED"
+	MsgHistory     MessageType = "HISTORY"
 	MsgError       MessageType = "ERROR"
 	MsgPong        MessageType = "PONG"
 )
@@ -112,8 +196,54 @@ type Message struct {
 
 // Error response data
 type ErrorData struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"` // Invalid request fields
+}
+
+// Handshake opening a connection (Client → Server)
+type HelloData struct {
+	ProtocolVersion uint16   `json:"protocolVersion"`
+	Features        []string `json:"features"`              // Features the client supports
+	ResumeToken     string   `json:"resumeToken,omitempty"` // From an earlier WELCOME
+}
+
+// Handshake answer (Server → Client)
+type WelcomeData struct {
+	ClientID        string       `json:"clientId"`
+	Message         string       `json:"message"`
+	ProtocolVersion uint16       `json:"protocolVersion"`
+	Features        []string     `json:"features"` // Features enabled for the connection
+	ResumeToken     string       `json:"resumeToken,omitempty"`
+	Resumed         bool         `json:"resumed"` // The session of the HELLO resume token was restored
+	BoardSize       uint8        `json:"boardSize"`
+	GridWidth       uint16       `json:"gridWidth"`
+	GridHeight      uint16       `json:"gridHeight"`
+	ZoneSize        uint16       `json:"zoneSize"`
+	Ruleset         RulesetInfo  `json:"ruleset"`
+	Limits          ServerLimits `json:"limits"`
+}
+
+// Rules applied to new boards
+type RulesetInfo struct {
+	KoRule  string  `json:"koRule"`
+	Scoring string  `json:"scoring"`
+	Komi    float64 `json:"komi"`
+}
+
+// Limits the server enforces on clients
+type ServerLimits struct {
+	MaxMessageSize     uint32 `json:"maxMessageSize"`     // Largest message accepted, in bytes
+	MaxHistoryPage     uint16 `json:"maxHistoryPage"`     // Most moves per FETCH_HISTORY
+	MaxSubscribedZones uint16 `json:"maxSubscribedZones"` // Most zones subscribed at once
+	MaxRegionSize      uint16 `json:"maxRegionSize"`      // Most boards on each side of FETCH_REGION
+	RecentDeltas       uint16 `json:"recentDeltas"`       // Deltas kept per board for resyncing
+	ResumeGrace        uint32 `json:"resumeGrace"`        // Seconds a session waits for a reconnect
+}
+
+// Ping answer (Server → Client)
+type PongData struct {
+	Timestamp int64 `json:"timestamp"`
 }
 
 // Move request data (Client → Server)
@@ -121,13 +251,51 @@ type MoveRequestData struct {
 	BoardX   uint16 `json:"boardX"`
 	BoardY   uint16 `json:"boardY"`
 	Position uint16 `json:"position"`
-	Player   string `json:"player"` // "black" or "white"
+	Player   string `json:"player,omitempty"` // Optional, must match the mover's seat
 }
 
-// Board fetch request data
+// Board fetch request data. A client holding version N of the board sets
+// SinceVersion to N and receives the missing deltas when the server still
+// has them, or the full board otherwise.
 type FetchBoardData struct {
+	BoardX       uint16  `json:"boardX"`
+	BoardY       uint16  `json:"boardY"`
+	SinceVersion *uint32 `json:"sinceVersion,omitempty"`
+}
+
+// Move history page request data
+type FetchHistoryData struct {
+	BoardX uint16 `json:"boardX"`
+	BoardY uint16 `json:"boardY"`
+	Offset int    `json:"offset"` // Index of the first move to return
+	Limit  int    `json:"limit"`  // Maximum number of moves, 0 for the default
+}
+
+// Pass, resign and accept request data
+type BoardActionData struct {
+	BoardX uint16 `json:"boardX"`
+	BoardY uint16 `json:"boardY"`
+}
+
+// Dead stone toggle request data, applies to the whole group at Position
+type MarkDeadData struct {
+	BoardX   uint16 `json:"boardX"`
+	BoardY   uint16 `json:"boardY"`
+	Position uint16 `json:"position"`
+}
+
+// Seat claim/leave request data
+type SeatData struct {
+	BoardX uint16 `json:"boardX"`
+	BoardY uint16 `json:"boardY"`
+	Color  string `json:"color"` // "black" or "white"
+}
+
+// Ruleset change request data, only accepted before the first move
+type SetRulesetData struct {
 	BoardX uint16 `json:"boardX"`
 	BoardY uint16 `json:"boardY"`
+	KoRule string `json:"koRule"`
 }
 
 // Region fetch request data
@@ -145,11 +313,23 @@ type SubscribeRegionData struct {
 	Viewport *ClientViewport `json:"viewport"`
 }
 
+// Region unsubscription data; no zones means every zone
+type UnsubscribeRegionData struct {
+	Zones []ZoneID `json:"zones,omitempty"`
+}
+
+// Subscription confirmation (Server → Client), lists every zone the
+// client now receives updates for
+type SubscribedData struct {
+	Zones []ZoneID `json:"zones"`
+}
+
 // Move result data (Server → Client)
 type MoveResultData struct {
 	Success    bool        `json:"success"`
 	MoveID     string      `json:"moveId"`
 	BoardState *BoardState `json:"boardState,omitempty"`
+	Delta      *BoardDelta `json:"delta,omitempty"`
 	Error      *ErrorData  `json:"error,omitempty"`
 }
 
@@ -161,11 +341,71 @@ type BoardUpdateData struct {
 	NewState *BoardState `json:"newState"`
 }
 
+// Board change caused by a single move, pass or resignation
+// (Server → Client). A client that sees Version skip a number has missed
+// an update and should resync the board with FETCH_BOARD sinceVersion.
+type BoardDelta struct {
+	BoardX        uint16   `json:"boardX"`
+	BoardY        uint16   `json:"boardY"`
+	Version       uint32   `json:"version"`            // Board version after the change
+	Move          *Move    `json:"move,omitempty"`     // nil for a resignation
+	Captured      []uint16 `json:"captured,omitempty"` // Positions of removed stones
+	MoveCount     uint16   `json:"moveCount"`
+	CurrentPlayer byte     `json:"currentPlayer"`
+	GamePhase     byte     `json:"gamePhase"`
+	Result        string   `json:"result,omitempty"`
+}
+
+// Missing deltas in answer to FETCH_BOARD sinceVersion (Server → Client)
+type BoardDeltasData struct {
+	BoardX  uint16        `json:"boardX"`
+	BoardY  uint16        `json:"boardY"`
+	Version uint32        `json:"version"`
+	Deltas  []*BoardDelta `json:"deltas"`
+}
+
+// Game phase change notification (Server → Client)
+type GamePhaseData struct {
+	BoardX  uint16 `json:"boardX"`
+	BoardY  uint16 `json:"boardY"`
+	Version uint32 `json:"version"`
+	Phase   byte   `json:"phase"`
+	Result  string `json:"result,omitempty"`
+	Reason  string `json:"reason"` // "passes", "resign" or "agreement"
+}
+
+// Move history page response (Server → Client)
+type HistoryData struct {
+	BoardX uint16 `json:"boardX"`
+	BoardY uint16 `json:"boardY"`
+	Total  int    `json:"total"`
+	Offset int    `json:"offset"`
+	Moves  []Move `json:"moves"`
+}
+
 // Region data response (Server → Client)
 type RegionDataResponse struct {
-	StartX uint16                 `json:"startX"`
-	StartY uint16                 `json:"startY"`
-	Width  uint16                 `json:"width"`
-	Height uint16                 `json:"height"`
-	Boards map[string]*BoardState `json:"boards"` // Key: "x,y"
+	StartX uint16                          `json:"startX"`
+	StartY uint16                          `json:"startY"`
+	Width  uint16                          `json:"width"`
+	Height uint16                          `json:"height"`
+	Boards map[BoardCoordinate]*BoardState `json:"boards"` // JSON key: "x,y"
+}
+
+// MarshalJSON writes the board keys as "x,y"
+func (r *RegionDataResponse) MarshalJSON() ([]byte, error) {
+	type plain RegionDataResponse
+	boards := make(map[string]*BoardState, len(r.Boards))
+	for coord, state := range r.Boards {
+		x, y := coord.Unpack()
+		boards[fmt.Sprintf("%d,%d", x, y)] = state
+	}
+
+	return json.Marshal(&struct {
+		*plain
+		Boards map[string]*BoardState `json:"boards"`
+	}{
+		plain:  (*plain)(r),
+		Boards: boards,
+	})
 }
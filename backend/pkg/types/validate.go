@@ -0,0 +1,143 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError describes one invalid field of a request payload
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the invalid fields of a request payload
+type ValidationError []FieldError
+
+func (v ValidationError) Error() string {
+	parts := make([]string, len(v))
+	for i, f := range v {
+		parts[i] = f.Field + " " + f.Message
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Validator is implemented by request payloads that check their fields
+// after decoding
+type Validator interface {
+	Validate() error
+}
+
+// fieldChecker collects field errors while a payload is validated
+type fieldChecker struct {
+	errs ValidationError
+}
+
+func (c *fieldChecker) check(ok bool, field, message string) {
+	if !ok {
+		c.errs = append(c.errs, FieldError{Field: field, Message: message})
+	}
+}
+
+func (c *fieldChecker) board(boardX, boardY uint16) {
+	c.check(boardX < GridSize, "boardX", fmt.Sprintf("must be below %d", GridSize))
+	c.check(boardY < GridSize, "boardY", fmt.Sprintf("must be below %d", GridSize))
+}
+
+func (c *fieldChecker) position(field string, pos uint16) {
+	c.check(pos < BoardPoints, field, fmt.Sprintf("must be below %d", BoardPoints))
+}
+
+func (c *fieldChecker) color(field, color string, optional bool) {
+	c.check(color == "black" || color == "white" || (optional && color == ""), field, `must be "black" or "white"`)
+}
+
+func (c *fieldChecker) err() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return c.errs
+}
+
+func (d *HelloData) Validate() error {
+	var c fieldChecker
+	c.check(d.ProtocolVersion > 0, "protocolVersion", "is required")
+	return c.err()
+}
+
+func (d *MoveRequestData) Validate() error {
+	var c fieldChecker
+	c.board(d.BoardX, d.BoardY)
+	c.position("position", d.Position)
+	c.color("player", d.Player, true)
+	return c.err()
+}
+
+func (d *FetchBoardData) Validate() error {
+	var c fieldChecker
+	c.board(d.BoardX, d.BoardY)
+	return c.err()
+}
+
+func (d *FetchHistoryData) Validate() error {
+	var c fieldChecker
+	c.board(d.BoardX, d.BoardY)
+	c.check(d.Offset >= 0, "offset", "must not be negative")
+	c.check(d.Limit >= 0, "limit", "must not be negative")
+	return c.err()
+}
+
+func (d *BoardActionData) Validate() error {
+	var c fieldChecker
+	c.board(d.BoardX, d.BoardY)
+	return c.err()
+}
+
+func (d *MarkDeadData) Validate() error {
+	var c fieldChecker
+	c.board(d.BoardX, d.BoardY)
+	c.position("position", d.Position)
+	return c.err()
+}
+
+func (d *SeatData) Validate() error {
+	var c fieldChecker
+	c.board(d.BoardX, d.BoardY)
+	c.color("color", d.Color, false)
+	return c.err()
+}
+
+func (d *SetRulesetData) Validate() error {
+	var c fieldChecker
+	c.board(d.BoardX, d.BoardY)
+	c.check(d.KoRule != "", "koRule", "is required")
+	return c.err()
+}
+
+func (d *FetchRegionData) Validate() error {
+	var c fieldChecker
+	c.check(d.StartX < GridSize, "startX", fmt.Sprintf("must be below %d", GridSize))
+	c.check(d.StartY < GridSize, "startY", fmt.Sprintf("must be below %d", GridSize))
+	c.check(d.Width >= 1 && d.Width <= MaxRegionSize, "width", fmt.Sprintf("must be between 1 and %d", MaxRegionSize))
+	c.check(d.Height >= 1 && d.Height <= MaxRegionSize, "height", fmt.Sprintf("must be between 1 and %d", MaxRegionSize))
+	return c.err()
+}
+
+func (d *SubscribeRegionData) Validate() error {
+	var c fieldChecker
+	c.check(d.CenterX < GridSize, "centerX", fmt.Sprintf("must be below %d", GridSize))
+	c.check(d.CenterY < GridSize, "centerY", fmt.Sprintf("must be below %d", GridSize))
+	if vp := d.Viewport; vp != nil {
+		c.check(vp.CenterX < GridSize, "viewport.centerX", fmt.Sprintf("must be below %d", GridSize))
+		c.check(vp.CenterY < GridSize, "viewport.centerY", fmt.Sprintf("must be below %d", GridSize))
+	}
+	return c.err()
+}
+
+func (d *UnsubscribeRegionData) Validate() error {
+	var c fieldChecker
+	for i, zone := range d.Zones {
+		c.check(zone < ZonesPerRow*ZonesPerRow, fmt.Sprintf("zones[%d]", i), fmt.Sprintf("must be below %d", ZonesPerRow*ZonesPerRow))
+	}
+	return c.err()
+}
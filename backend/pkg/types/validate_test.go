@@ -0,0 +1,47 @@
+package types
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFetchRegionDataValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   FetchRegionData
+		fields []string
+	}{
+		{"single board", FetchRegionData{StartX: 0, StartY: 0, Width: 1, Height: 1}, nil},
+		{"largest region", FetchRegionData{StartX: 10, StartY: 20, Width: MaxRegionSize, Height: MaxRegionSize}, nil},
+		{"clipped at the grid edge", FetchRegionData{StartX: GridSize - 1, StartY: GridSize - 1, Width: 10, Height: 10}, nil},
+		{"empty", FetchRegionData{Width: 0, Height: 5}, []string{"width"}},
+		{"too wide", FetchRegionData{Width: MaxRegionSize + 1, Height: 5}, []string{"width"}},
+		{"whole grid", FetchRegionData{Width: GridSize, Height: GridSize}, []string{"width", "height"}},
+		{"outside the grid", FetchRegionData{StartX: GridSize, StartY: 5, Width: 1, Height: 1}, []string{"startX"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.data.Validate()
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+
+			var fieldErrs ValidationError
+			if !errors.As(err, &fieldErrs) {
+				t.Fatalf("Validate error = %v, want field errors", err)
+			}
+			var fields []string
+			for _, f := range fieldErrs {
+				fields = append(fields, f.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("invalid fields %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
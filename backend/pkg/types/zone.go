@@ -0,0 +1,47 @@
+package types
+
+const (
+	// ZoneSize is the number of boards on each side of a subscription zone
+	ZoneSize = 16
+
+	// ZonesPerRow is the number of zones across the grid
+	ZonesPerRow = (GridSize + ZoneSize - 1) / ZoneSize
+)
+
+// ZoneFor returns the zone containing a board. Zones tile the grid in
+// ZoneSize x ZoneSize squares numbered row by row.
+func ZoneFor(coord BoardCoordinate) ZoneID {
+	x, y := coord.Unpack()
+	return ZoneID(uint32(y/ZoneSize)*ZonesPerRow + uint32(x/ZoneSize))
+}
+
+// Origin returns the coordinate of the top-left board of the zone
+func (z ZoneID) Origin() BoardCoordinate {
+	x := uint16(z%ZonesPerRow) * ZoneSize
+	y := uint16(z/ZonesPerRow) * ZoneSize
+	return NewBoardCoordinate(x, y)
+}
+
+// ZonesInRect returns every zone overlapping the given rectangle of
+// boards, clipped to the grid
+func ZonesInRect(startX, startY, width, height uint16) []ZoneID {
+	if width == 0 || height == 0 || startX >= GridSize || startY >= GridSize {
+		return nil
+	}
+	endX := uint32(startX) + uint32(width) - 1
+	endY := uint32(startY) + uint32(height) - 1
+	if endX >= GridSize {
+		endX = GridSize - 1
+	}
+	if endY >= GridSize {
+		endY = GridSize - 1
+	}
+
+	zones := make([]ZoneID, 0)
+	for zy := uint32(startY) / ZoneSize; zy <= endY/ZoneSize; zy++ {
+		for zx := uint32(startX) / ZoneSize; zx <= endX/ZoneSize; zx++ {
+			zones = append(zones, ZoneID(zy*ZonesPerRow+zx))
+		}
+	}
+	return zones
+}
@@ -0,0 +1,72 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestZoneFor(t *testing.T) {
+	const last = ZonesPerRow - 1
+	tests := []struct {
+		name   string
+		x, y   uint16
+		zone   ZoneID
+		origin BoardCoordinate
+	}{
+		{"first board", 0, 0, 0, NewBoardCoordinate(0, 0)},
+		{"end of the first zone", ZoneSize - 1, ZoneSize - 1, 0, NewBoardCoordinate(0, 0)},
+		{"start of the second row", 0, ZoneSize, ZonesPerRow, NewBoardCoordinate(0, ZoneSize)},
+		{"last board", GridSize - 1, GridSize - 1, last*ZonesPerRow + last, NewBoardCoordinate(last*ZoneSize, last*ZoneSize)},
+		// The last zone runs past the grid to 1007
+		{"end of the last column", 1007, 0, last, NewBoardCoordinate(last*ZoneSize, 0)},
+		{"end of the last row", 0, 1007, last * ZonesPerRow, NewBoardCoordinate(0, last*ZoneSize)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone := ZoneFor(NewBoardCoordinate(tt.x, tt.y))
+			if zone != tt.zone {
+				t.Fatalf("ZoneFor(%d,%d) = %d, want %d", tt.x, tt.y, zone, tt.zone)
+			}
+			if origin := zone.Origin(); origin != tt.origin {
+				x, y := origin.Unpack()
+				t.Errorf("zone %d starts at %d,%d", zone, x, y)
+			}
+		})
+	}
+}
+
+func TestZonesInRect(t *testing.T) {
+	const last = ZonesPerRow - 1
+	tests := []struct {
+		name                 string
+		startX, startY, w, h uint16
+		zones                []ZoneID
+	}{
+		{"single board", 5, 5, 1, 1, []ZoneID{0}},
+		{"whole zone", ZoneSize, 0, ZoneSize, ZoneSize, []ZoneID{1}},
+		{"across a column edge", ZoneSize - 1, 0, 2, 1, []ZoneID{0, 1}},
+		{"across four zones", ZoneSize - 2, ZoneSize - 2, 4, 4, []ZoneID{0, 1, ZonesPerRow, ZonesPerRow + 1}},
+		{"three zones wide", 10, 0, 2*ZoneSize + 1, 1, []ZoneID{0, 1, 2}},
+		{"last column", GridSize - 1, 0, 1, 1, []ZoneID{last}},
+		{"last row", 0, GridSize - 1, 1, 1, []ZoneID{last * ZonesPerRow}},
+		{"clipped at the corner", GridSize - 20, GridSize - 20, 100, 100, []ZoneID{
+			(last-1)*ZonesPerRow + last - 1, (last-1)*ZonesPerRow + last,
+			last*ZonesPerRow + last - 1, last*ZonesPerRow + last,
+		}},
+		{"starting past the grid in the last zone", 1007, 0, 1, 1, nil},
+		{"empty", 5, 5, 0, 3, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zones := ZonesInRect(tt.startX, tt.startY, tt.w, tt.h)
+			if len(zones) == 0 && len(tt.zones) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(zones, tt.zones) {
+				t.Errorf("ZonesInRect(%d,%d,%d,%d) = %v, want %v", tt.startX, tt.startY, tt.w, tt.h, zones, tt.zones)
+			}
+		})
+	}
+}